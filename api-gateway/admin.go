@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// scanBatchSize is the COUNT hint used when scanning cache keys
+	scanBatchSize = 100
+
+	// maxListedKeys bounds the number of keys returned by listCacheKeys
+	maxListedKeys = 1000
+)
+
+// registerAdminRoutes sets up the /admin endpoints
+func registerAdminRoutes(r *gin.Engine) {
+	admin := r.Group("/admin", adminIPAllowlist(), adminAuth())
+	admin.POST("/cache/purge", purgeCache)
+	admin.GET("/cache/keys", listCacheKeys)
+	admin.GET("/cache/stats", cacheStats)
+	admin.POST("/cache/refresh", refreshCache)
+	admin.GET("/status", adminStatus)
+	admin.POST("/loglevel", setLogLevelHandler)
+	admin.POST("/maintenance", setMaintenance)
+	admin.POST("/symbols/refresh", refreshEndpoint("symbols"))
+	registerRateLimitOverrideRoutes(admin)
+}
+
+// adminAPIKeys are the admin keys from the comma-separated ADMIN_API_KEYS
+// env var
+var adminAPIKeys = parseAPIKeys(getEnv("ADMIN_API_KEYS", ""))
+
+// adminAuth creates a middleware that requires an admin key. Admin
+// endpoints are disabled when no admin keys are configured.
+func adminAuth() gin.HandlerFunc {
+	if len(adminAPIKeys) == 0 {
+		slog.Warn("ADMIN_API_KEYS not set - admin endpoints disabled")
+	}
+
+	return func(c *gin.Context) {
+		if !isAdminRequest(c) {
+			errorResponse(c, http.StatusUnauthorized, "admin_auth_required", "admin authentication required")
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAdminRequest reports whether the request carries a valid admin key
+func isAdminRequest(c *gin.Context) bool {
+	key := c.GetHeader("X-API-Key")
+	return len(adminAPIKeys) > 0 && key != "" && validAPIKey(key, adminAPIKeys)
+}
+
+// purgeRequest is the body accepted by purgeCache
+type purgeRequest struct {
+	Endpoint string `json:"endpoint"`
+	All      bool   `json:"all"`
+}
+
+// purgeCache deletes cached responses for one endpoint, or all of them
+func purgeCache(c *gin.Context) {
+	var req purgeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	var pattern string
+	switch {
+	case req.All:
+		pattern = namespacedPattern("cache:*")
+	case isCachedEndpoint(req.Endpoint):
+		pattern = namespacedPattern(fmt.Sprintf("cache:%s:*", escapeGlob(req.Endpoint)))
+	default:
+		errorResponse(c, http.StatusBadRequest, "unknown_endpoint", fmt.Sprintf("Unknown endpoint %q", req.Endpoint))
+		return
+	}
+
+	deleted, err := deleteKeys(c.Request.Context(), pattern)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error purging cache: %v", err))
+		return
+	}
+
+	slog.Info("Purged cache keys", "pattern", pattern, "deleted", deleted, "request_id", c.GetString("request_id"))
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+// refreshEndpoint creates a handler that purges the cached responses of
+// endpoint and re-fetches its default variant, e.g. when new coins are
+// listed
+func refreshEndpoint(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pattern := namespacedPattern(fmt.Sprintf("cache:%s:*", escapeGlob(endpoint)))
+		deleted, err := deleteKeys(c.Request.Context(), pattern)
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error purging cache: %v", err))
+			return
+		}
+		slog.Info("Purged cache keys", "pattern", pattern, "deleted", deleted, "request_id", c.GetString("request_id"))
+
+		_, status, _, err := loadCachedBody(c.Request.Context(), endpoint, "")
+		if err != nil {
+			errorResponse(c, http.StatusBadGateway, "refresh_failed", fmt.Sprintf("Error refreshing %s after deleting %d keys: %v", endpoint, deleted, err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"deleted": deleted, "status": status})
+	}
+}
+
+// refreshRequest is the body accepted by refreshCache
+type refreshRequest struct {
+	Endpoint string `json:"endpoint"`
+	Query    string `json:"query"`
+}
+
+// refreshCache re-fetches one cached variant of an endpoint from the
+// backend, updating the cache, and responds with the fresh body
+func refreshCache(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	settings, ok := cachedEndpoints[req.Endpoint]
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, "unknown_endpoint", fmt.Sprintf("Unknown endpoint %q", req.Endpoint))
+		return
+	}
+
+	// Refreshed as if the client sent none of the vary headers
+	query := keyedQuery(req.Endpoint, normalizeQuery(strings.TrimPrefix(req.Query, "?")))
+	cacheKey := cacheKeyFor(req.Endpoint, query) + varyKey(nil, settings.vary)
+	resp, err := fetchAndCache(c.Request.Context(), req.Endpoint, query, nil, nil, cacheKey, settings.ttl, settings.staleWindow)
+	if err != nil {
+		respondBackendError(c, err)
+		return
+	}
+
+	slog.Info("Refreshed cache key", "key", cacheKey, "status", resp.status, "request_id", c.GetString("request_id"))
+	resp.copyHeaders(c)
+	setCacheStatus(c, req.Endpoint, "REFRESH")
+	if resp.status >= http.StatusBadRequest {
+		respondBackendStatus(c, resp.status, resp.body)
+		return
+	}
+	resp.write(c)
+}
+
+// logLevelRequest is the body accepted by setLogLevelHandler
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// setLogLevelHandler changes the log level until the next restart
+func setLogLevelHandler(c *gin.Context) {
+	var req logLevelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	level, ok := logLevels[strings.ToUpper(req.Level)]
+	if !ok {
+		errorResponse(c, http.StatusBadRequest, "invalid_level", fmt.Sprintf("Unknown log level %q", req.Level))
+		return
+	}
+
+	previous := logLevelVar.Level()
+	setLogLevel(level)
+	slog.Warn("Log level changed", "level", level.String(), "previous", previous.String(), "request_id", c.GetString("request_id"))
+	c.JSON(http.StatusOK, gin.H{"level": level.String(), "previous": previous.String()})
+}
+
+// deleteKeys deletes all keys matching pattern, returning the number of keys deleted
+func deleteKeys(ctx context.Context, pattern string) (int64, error) {
+	var deleted int64
+	err := cacheStore.Scan(ctx, pattern, func(keys []string) error {
+		n, err := cacheStore.Del(ctx, keys...)
+		deleted += n
+		return err
+	})
+	return deleted, err
+}
+
+// isAdminPath reports whether path is served by the admin routes
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/admin/")
+}
+
+// cacheKeyInfo describes a cached key returned by listCacheKeys
+type cacheKeyInfo struct {
+	Key        string  `json:"key"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+	Value      *string `json:"value,omitempty"`
+}
+
+// listCacheKeys lists cache keys whose endpoint starts with the prefix query
+// param, with their remaining TTL and optionally their stored bodies. With
+// ?count= or ?cursor= the keys are returned one page at a time, along with
+// the cursor of the next page.
+func listCacheKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+	pattern := namespacedPattern("cache:" + escapeGlob(c.Query("prefix")) + "*")
+	withValues := c.Query("withValues") == "true"
+	_, paged := c.GetQuery("cursor")
+	if _, ok := c.GetQuery("count"); ok || paged {
+		listCacheKeysPage(c, pattern, withValues)
+		return
+	}
+
+	var keys []string
+	truncated := false
+	err := cacheStore.Scan(ctx, pattern, func(batch []string) error {
+		if truncated {
+			return errStopScan
+		}
+		keys = append(keys, batch...)
+		if len(keys) > maxListedKeys {
+			truncated = true
+			keys = keys[:maxListedKeys]
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error scanning cache: %v", err))
+		return
+	}
+
+	infos, err := describeKeys(ctx, keys, withValues)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error reading cache keys: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": infos, "count": len(infos), "truncated": truncated})
+}
+
+// listCacheKeysPage lists one page of the keys matching pattern, starting
+// at the opaque cursor query param. The response cursor is empty once all
+// keys have been listed.
+func listCacheKeysPage(c *gin.Context, pattern string, withValues bool) {
+	ctx := c.Request.Context()
+	count := int64(scanBatchSize)
+	if value := c.Query("count"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n <= 0 || n > maxListedKeys {
+			errorResponse(c, http.StatusBadRequest, "invalid_count", fmt.Sprintf("count must be between 1 and %d", maxListedKeys))
+			return
+		}
+		count = n
+	}
+	cursor, err := base64.RawURLEncoding.DecodeString(c.Query("cursor"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_cursor", "invalid cursor")
+		return
+	}
+
+	keys, next, err := cacheStore.ScanPage(ctx, pattern, string(cursor), count)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error scanning cache: %v", err))
+		return
+	}
+	infos, err := describeKeys(ctx, keys, withValues)
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error reading cache keys: %v", err))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": infos, "count": len(infos), "cursor": base64.RawURLEncoding.EncodeToString([]byte(next))})
+}
+
+// describeKeys fetches the TTL, and optionally the decoded body, of each key
+func describeKeys(ctx context.Context, keys []string, withValues bool) ([]cacheKeyInfo, error) {
+	infos := make([]cacheKeyInfo, 0, len(keys))
+	for _, key := range keys {
+		value, ttl, err := cacheStore.Get(ctx, key)
+		if err != nil && err != errCacheMiss {
+			return nil, err
+		}
+		info := cacheKeyInfo{Key: key, TTLSeconds: ttl.Seconds()}
+		if err == errCacheMiss || ttl < 0 {
+			// No expiry, or the key was deleted since the scan
+			info.TTLSeconds = -1
+		}
+		if withValues && err == nil {
+			if body, ok := decodeCachedBody(key, value); ok {
+				info.Value = &body
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// decodeCachedBody returns the plain body stored in an encoded cache entry
+// under key
+func decodeCachedBody(key string, data []byte) (string, bool) {
+	encoded, ok := verifyCacheEntry(key, data)
+	if !ok {
+		return "", false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(encoded, &entry); err != nil {
+		return "", false
+	}
+	body, err := entry.plainBody()
+	if err != nil {
+		return "", false
+	}
+	return string(body), true
+}
+
+// escapeGlob escapes Redis glob metacharacters in s
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testAdminKey is the admin key of adminTestRouter
+const testAdminKey = "admin-key"
+
+// adminHeader authenticates admin requests to adminTestRouter
+var adminHeader = http.Header{"X-API-Key": {testAdminKey}, "Content-Type": {"application/json"}}
+
+// adminTestRouter returns a router serving the admin endpoints with
+// testAdminKey as the admin key
+func adminTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	setForTest(t, &adminAPIKeys, parseAPIKeys(testAdminKey))
+	r := gin.New()
+	registerAdminRoutes(r)
+	return r
+}
+
+// decodeJSON decodes the body of a JSON response into v
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body, err)
+	}
+}
+
+// seedCache stores a value under each of keys
+func seedCache(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := cacheStore.Set(context.Background(), key, []byte("{}"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// cacheHas reports whether key is set in the cache
+func cacheHas(key string) bool {
+	_, _, err := cacheStore.Get(context.Background(), key)
+	return err == nil
+}
+
+func TestPurgeCache(t *testing.T) {
+	backends := map[string]func(t *testing.T){
+		"memory": func(t *testing.T) { setForTest[Cache](t, &cacheStore, newMemoryCache(0)) },
+		"redis":  func(t *testing.T) { newTestRedis(t) },
+	}
+	for name, useCache := range backends {
+		t.Run(name, func(t *testing.T) {
+			useCache(t)
+			r := adminTestRouter(t)
+			cachedTestRouter("purge-a", time.Minute, time.Minute)
+			cachedTestRouter("purge-b", time.Minute, time.Minute)
+			seedCache(t, "cache:purge-a:", "cache:purge-a:x=1", "cache:purge-b:", "ratelimit:192.0.2.1")
+
+			w := serve(r, http.MethodPost, "/admin/cache/purge", adminHeader, `{"endpoint":"purge-a"}`)
+			var resp struct{ Deleted int64 }
+			decodeJSON(t, w, &resp)
+			if w.Code != http.StatusOK || resp.Deleted != 2 {
+				t.Fatalf("endpoint purge: status %d, body %s, want 2 deleted", w.Code, w.Body)
+			}
+			if cacheHas("cache:purge-a:x=1") || !cacheHas("cache:purge-b:") {
+				t.Error("endpoint purge deleted the wrong keys")
+			}
+
+			w = serve(r, http.MethodPost, "/admin/cache/purge", adminHeader, `{"all":true}`)
+			decodeJSON(t, w, &resp)
+			if w.Code != http.StatusOK || resp.Deleted != 1 {
+				t.Fatalf("full purge: status %d, body %s, want 1 deleted", w.Code, w.Body)
+			}
+			if cacheHas("cache:purge-b:") || !cacheHas("ratelimit:192.0.2.1") {
+				t.Error("full purge deleted the wrong keys")
+			}
+		})
+	}
+}
+
+func TestPurgeCacheRejectsBadRequests(t *testing.T) {
+	setForTest[Cache](t, &cacheStore, newMemoryCache(0))
+	r := adminTestRouter(t)
+
+	if w := serve(r, http.MethodPost, "/admin/cache/purge", nil, `{"all":true}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("without admin key: status %d, want 401", w.Code)
+	}
+	w := serve(r, http.MethodPost, "/admin/cache/purge", adminHeader, `{"endpoint":"nope"}`)
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != "unknown_endpoint" {
+		t.Errorf("unknown endpoint: status %d, body %s, want 400 unknown_endpoint", w.Code, w.Body)
+	}
+}
+
+// cacheKeyListing is a /admin/cache/keys response
+type cacheKeyListing struct {
+	Keys      []cacheKeyInfo
+	Count     int
+	Truncated bool
+	Cursor    string
+}
+
+func TestListCacheKeys(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	admin := adminTestRouter(t)
+	prices := cachedTestRouter("keys-prices", time.Minute, time.Minute)
+	get(prices, "/api/keys-prices?symbol=BTC")
+	get(prices, "/api/keys-prices?symbol=ETH")
+	get(cachedTestRouter("keys-news", time.Minute, time.Minute), "/api/keys-news")
+
+	var listing cacheKeyListing
+	decodeJSON(t, serve(admin, http.MethodGet, "/admin/cache/keys?prefix=keys-prices", adminHeader, ""), &listing)
+	if listing.Count != 2 || len(listing.Keys) != 2 {
+		t.Fatalf("listed %d keys, want the 2 keys-prices keys", listing.Count)
+	}
+	for _, info := range listing.Keys {
+		if info.TTLSeconds <= 0 || info.TTLSeconds > 120 || info.Value != nil {
+			t.Errorf("key %s: TTL %.0fs, value %v, want a TTL within 2m and no value", info.Key, info.TTLSeconds, info.Value)
+		}
+	}
+
+	decodeJSON(t, serve(admin, http.MethodGet, "/admin/cache/keys?prefix=keys-&withValues=true", adminHeader, ""), &listing)
+	if listing.Count != 3 {
+		t.Fatalf("listed %d keys, want 3", listing.Count)
+	}
+	for _, info := range listing.Keys {
+		if info.Value == nil || *info.Value != `{"ok":true}` {
+			t.Errorf("key %s: value %v, want the cached body", info.Key, info.Value)
+		}
+	}
+}
+
+func TestListCacheKeysPaged(t *testing.T) {
+	setForTest[Cache](t, &cacheStore, newMemoryCache(0))
+	admin := adminTestRouter(t)
+	seedCache(t, "cache:paged:a", "cache:paged:b", "cache:paged:c", "lkg:paged:a")
+
+	seen := map[string]bool{}
+	cursor := ""
+	for page := 0; page < 10; page++ {
+		var listing cacheKeyListing
+		decodeJSON(t, serve(admin, http.MethodGet, "/admin/cache/keys?prefix=paged&count=1&cursor="+cursor, adminHeader, ""), &listing)
+		for _, info := range listing.Keys {
+			seen[info.Key] = true
+		}
+		if cursor = listing.Cursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != 3 || seen["lkg:paged:a"] {
+		t.Errorf("paged listing returned %v, want the 3 cache:paged keys", seen)
+	}
+
+	if w := serve(admin, http.MethodGet, "/admin/cache/keys?count=0", adminHeader, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("count=0: status %d, want 400", w.Code)
+	}
+}
+
+func TestRefreshCache(t *testing.T) {
+	var version atomic.Int64
+	var query atomic.Value
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		query.Store(r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, version.Add(1))
+	})
+	admin := adminTestRouter(t)
+	r := cachedTestRouter("admin-refresh", time.Minute, time.Minute)
+
+	get(r, "/api/admin-refresh?vs_currency=usd&symbol=BTC")
+	w := serve(admin, http.MethodPost, "/admin/cache/refresh", adminHeader, `{"endpoint":"admin-refresh","query":"?vs_currency=usd&symbol=BTC"}`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"version":2}` || w.Header().Get("X-Cache") != "REFRESH" {
+		t.Fatalf("refresh: status %d, X-Cache %q, body %s, want the fresh body", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	if q := query.Load(); q != "symbol=BTC&vs_currency=usd" {
+		t.Errorf("backend query %q, want the normalized query", q)
+	}
+	if w := get(r, "/api/admin-refresh?symbol=BTC&vs_currency=usd"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"version":2}` {
+		t.Errorf("after refresh: X-Cache %q, body %s, want the refreshed entry", w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+}
+
+func TestRefreshCacheRejectsBadRequests(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	admin := adminTestRouter(t)
+	cachedTestRouter("admin-refresh-down", time.Minute, time.Minute)
+
+	for _, tc := range []struct {
+		body   string
+		status int
+		code   string
+	}{
+		{`{"endpoint":`, http.StatusBadRequest, "invalid_body"},
+		{`{"endpoint":"unknown"}`, http.StatusBadRequest, "unknown_endpoint"},
+		{`{"endpoint":"admin-refresh-down"}`, http.StatusInternalServerError, ""},
+	} {
+		w := serve(admin, http.MethodPost, "/admin/cache/refresh", adminHeader, tc.body)
+		if w.Code != tc.status || (tc.code != "" && errorCode(t, w) != tc.code) {
+			t.Errorf("%s: status %d, body %s, want %d %s", tc.body, w.Code, w.Body, tc.status, tc.code)
+		}
+	}
+	if cacheHas(namespacedKey("cache:admin-refresh-down:")) {
+		t.Error("failed refresh cached")
+	}
+}
+
+func TestListCacheKeysPagesThroughEveryKeyOnce(t *testing.T) {
+	for _, backend := range []string{"memory", "redis"} {
+		t.Run(backend, func(t *testing.T) {
+			if backend == "redis" {
+				newTestRedis(t)
+			} else {
+				setForTest[Cache](t, &cacheStore, newMemoryCache(0))
+			}
+			admin := adminTestRouter(t)
+			var keys []string
+			for i := 0; i < 50; i++ {
+				keys = append(keys, fmt.Sprintf("cache:paging:symbol=%02d", i))
+			}
+			seedCache(t, append(keys, "cache:other:symbol=1", "lkg:paging:symbol=1")...)
+
+			seen := map[string]int{}
+			cursor, pages := "", 0
+			for ; pages == 0 || cursor != ""; pages++ {
+				if pages > 50 {
+					t.Fatal("listing never returned an empty cursor")
+				}
+				w := serve(admin, http.MethodGet, "/admin/cache/keys?prefix=paging&count=7&cursor="+cursor, adminHeader, "")
+				var listing cacheKeyListing
+				decodeJSON(t, w, &listing)
+				for _, info := range listing.Keys {
+					seen[info.Key]++
+				}
+				cursor = listing.Cursor
+			}
+			if len(seen) != len(keys) {
+				t.Errorf("listed %d distinct keys over %d pages, want %d", len(seen), pages, len(keys))
+			}
+			for _, key := range keys {
+				if seen[key] != 1 {
+					t.Errorf("%s listed %d times, want once", key, seen[key])
+				}
+			}
+			if backend == "memory" && pages != 8 {
+				t.Errorf("listed %d pages of 7 keys, want 8", pages)
+			}
+		})
+	}
+}
+
+func TestListCacheKeysRejectsInvalidCursor(t *testing.T) {
+	setForTest[Cache](t, &cacheStore, newMemoryCache(0))
+	admin := adminTestRouter(t)
+	w := serve(admin, http.MethodGet, "/admin/cache/keys?cursor=not*base64", adminHeader, "")
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != "invalid_cursor" {
+		t.Errorf("status %d, body %s, want 400 invalid_cursor", w.Code, w.Body)
+	}
+}
+
+func TestSetLogLevel(t *testing.T) {
+	previous := logLevelVar.Level()
+	t.Cleanup(func() { setLogLevel(previous) })
+	setLogLevel(slog.LevelError)
+	logs := captureLogs(t, logLevelVar)
+	r := adminTestRouter(t)
+
+	slog.Debug("before")
+	w := serve(r, http.MethodPost, "/admin/loglevel", adminHeader, `{"level":"debug"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	var changed struct{ Level, Previous string }
+	decodeJSON(t, w, &changed)
+	if changed.Level != "DEBUG" || changed.Previous != "ERROR" {
+		t.Errorf("response %+v, want DEBUG from ERROR", changed)
+	}
+	slog.Debug("after")
+	if len(logs.lines(t, "before")) != 0 || len(logs.lines(t, "after")) != 1 {
+		t.Error("debug logs not honoring the new level")
+	}
+	if gin.DefaultWriter != os.Stdout {
+		t.Error("gin output still silenced at DEBUG")
+	}
+
+	serve(r, http.MethodPost, "/admin/loglevel", adminHeader, `{"level":"WARN"}`)
+	slog.Info("quiet")
+	slog.Warn("loud")
+	if len(logs.lines(t, "quiet")) != 0 || len(logs.lines(t, "loud")) != 1 {
+		t.Error("logs not honoring WARN")
+	}
+	if gin.DefaultWriter != io.Discard {
+		t.Error("gin output not silenced above INFO")
+	}
+}
+
+func TestSetLogLevelRejectsInvalidRequests(t *testing.T) {
+	previous := logLevelVar.Level()
+	t.Cleanup(func() { setLogLevel(previous) })
+	setLogLevel(slog.LevelError)
+	r := adminTestRouter(t)
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		body   string
+		status int
+	}{
+		{"unknown level", adminHeader, `{"level":"verbose"}`, http.StatusBadRequest},
+		{"invalid body", adminHeader, `{"level":`, http.StatusBadRequest},
+		{"no admin key", http.Header{"Content-Type": {"application/json"}}, `{"level":"DEBUG"}`, http.StatusUnauthorized},
+	} {
+		if w := serve(r, http.MethodPost, "/admin/loglevel", tc.header, tc.body); w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+	if level := logLevelVar.Level(); level != slog.LevelError {
+		t.Errorf("log level changed to %v by rejected requests", level)
+	}
+}
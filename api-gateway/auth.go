@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authExemptPaths are served without an API key
+var authExemptPaths = map[string]bool{
+	"/health": true,
+	"/ready":  true,
+}
+
+// wsAPIKeyParam is the query param carrying the API key of websocket
+// handshakes, since browsers can't set headers on them
+const wsAPIKeyParam = "api_key"
+
+// apiKeyAuth creates a middleware that requires a valid X-API-Key header, or
+// api_key query param on websocket handshakes. Keys are read from the
+// comma-separated API_KEYS env var, and tenant and admin keys are accepted
+// too; when there are no API or tenant keys authentication is disabled. A
+// tenant key stores the tenant in the request context and as "tenant_id" in
+// the gin context.
+func apiKeyAuth(tenants []*tenant) gin.HandlerFunc {
+	keys := parseAPIKeys(getEnv("API_KEYS", ""))
+	if len(keys) == 0 && len(tenants) == 0 {
+		slog.Warn("API_KEYS not set - API key authentication disabled")
+		return func(c *gin.Context) { c.Next() }
+	}
+	slog.Info("API key authentication enabled", "keys", len(keys), "tenants", len(tenants))
+	keys = append(keys, adminAPIKeys...)
+
+	return func(c *gin.Context) {
+		// Admin routes are checked separately by adminAuth
+		if authExemptPaths[c.Request.URL.Path] || isAdminPath(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		key := requestAPIKey(c)
+		if key == "" {
+			errorResponse(c, http.StatusUnauthorized, "missing_api_key", "missing API key")
+			return
+		}
+		if t := findTenant(key, tenants); t != nil {
+			c.Set("tenant_id", t.ID)
+			c.Request = c.Request.WithContext(withTenant(c.Request.Context(), t))
+			c.Next()
+			return
+		}
+		if !validAPIKey(key, keys) {
+			errorResponse(c, http.StatusUnauthorized, "invalid_api_key", "invalid API key")
+			return
+		}
+		c.Next()
+	}
+}
+
+// requestAPIKey returns the X-API-Key header of the request. The api_key
+// query param of a websocket handshake is moved into the header, so it is
+// neither logged nor treated differently by later middleware.
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader("X-API-Key"); key != "" || !c.IsWebsocket() {
+		return key
+	}
+	query := c.Request.URL.Query()
+	key := query.Get(wsAPIKeyParam)
+	if key != "" {
+		query.Del(wsAPIKeyParam)
+		c.Request.URL.RawQuery = query.Encode()
+		c.Request.Header.Set("X-API-Key", key)
+	}
+	return key
+}
+
+// parseAPIKeys splits a comma-separated list of API keys
+func parseAPIKeys(value string) [][]byte {
+	var keys [][]byte
+	for _, k := range strings.Split(value, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, []byte(k))
+		}
+	}
+	return keys
+}
+
+// validAPIKey compares key against every allowed key in constant time
+func validAPIKey(key string, keys [][]byte) bool {
+	valid := 0
+	for _, k := range keys {
+		valid |= subtle.ConstantTimeCompare([]byte(key), k)
+	}
+	return valid == 1
+}
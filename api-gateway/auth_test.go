@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authTestRouter returns a router behind apiKeyAuth serving 200 on /health
+// and /api/prices
+func authTestRouter(t *testing.T, apiKeys string, tenants ...*tenant) *gin.Engine {
+	t.Helper()
+	t.Setenv("API_KEYS", apiKeys)
+	r := gin.New()
+	r.Use(apiKeyAuth(tenants))
+	ok := func(c *gin.Context) { c.String(http.StatusOK, c.GetString("tenant_id")) }
+	r.GET("/health", ok)
+	r.GET("/api/prices", ok)
+	return r
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	r := authTestRouter(t, "key-1, key-2")
+
+	for _, tc := range []struct {
+		name, path, key string
+		status          int
+		code            string
+	}{
+		{"valid key", "/api/prices", "key-2", http.StatusOK, ""},
+		{"invalid key", "/api/prices", "key-3", http.StatusUnauthorized, "invalid_api_key"},
+		{"missing key", "/api/prices", "", http.StatusUnauthorized, "missing_api_key"},
+		{"health without key", "/health", "", http.StatusOK, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := http.Header{}
+			if tc.key != "" {
+				header.Set("X-API-Key", tc.key)
+			}
+			w := serve(r, http.MethodGet, tc.path, header, "")
+			if w.Code != tc.status {
+				t.Fatalf("status %d, want %d", w.Code, tc.status)
+			}
+			if tc.code != "" && errorCode(t, w) != tc.code {
+				t.Errorf("error code %q, want %q", errorCode(t, w), tc.code)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthDisabledWithoutKeys(t *testing.T) {
+	if w := get(authTestRouter(t, ""), "/api/prices"); w.Code != http.StatusOK {
+		t.Errorf("status %d without configured keys, want 200", w.Code)
+	}
+}
+
+func TestValidAPIKey(t *testing.T) {
+	keys := parseAPIKeys("abc,def")
+	if !validAPIKey("def", keys) {
+		t.Error("configured key rejected")
+	}
+	for _, key := range []string{"", "ab", "abcd", "DEF"} {
+		if validAPIKey(key, keys) {
+			t.Errorf("key %q accepted", key)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// backends are the backend replicas parsed from BACKEND_URL
+	backends = parseBackends(backendURL)
+
+	backendFailureThreshold = getEnvInt("BACKEND_FAILURE_THRESHOLD", 3)
+	backendCooldown         = getEnvDuration("BACKEND_COOLDOWN", 30*time.Second)
+
+	// backendPathPrefix is prepended to proxied paths, from the
+	// BACKEND_PATH_PREFIX env var (e.g. /v1 maps /api/prices to /v1/api/prices)
+	backendPathPrefix = normalizePathPrefix(getEnv("BACKEND_PATH_PREFIX", ""))
+)
+
+// errNoBackends is returned when BACKEND_URL lists no backends
+var errNoBackends = errors.New("no backends configured")
+
+// backend is a single backend replica with its health state
+type backend struct {
+	url string
+
+	// weight is the backend's share of requests relative to the rest of
+	// its pool, or 0 if BACKEND_URL gave it none
+	weight int
+
+	// currentWeight is the smooth weighted round-robin state, guarded by
+	// weightedMu
+	currentWeight int
+
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// normalizePathPrefix returns prefix with a leading slash and without a
+// trailing one, or "" if it is empty
+func normalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// errUnknownEndpoint is returned for backend requests to endpoints that are
+// not registered routes
+var errUnknownEndpoint = errors.New("unknown endpoint")
+
+// backendURI returns the backend request URI for a registered endpoint and a
+// raw query. The query is parsed and re-encoded so only well-formed params
+// are forwarded; malformed pairs are dropped.
+func backendURI(endpoint, rawQuery string) (string, error) {
+	if !isProxiedEndpoint(endpoint) {
+		return "", fmt.Errorf("%w %q", errUnknownEndpoint, endpoint)
+	}
+	values, _ := url.ParseQuery(rawQuery)
+	target := url.URL{Path: backendPathPrefix + "/api/" + endpoint, RawQuery: values.Encode()}
+	return target.RequestURI(), nil
+}
+
+// parseBackends splits a comma-separated list of backend URLs, each
+// optionally followed by |weight (e.g. http://a|3,http://b|1)
+func parseBackends(value string) []*backend {
+	var list []*backend
+	for _, u := range strings.Split(value, ",") {
+		u, weight := strings.TrimSpace(u), 0
+		if i := strings.LastIndex(u, "|"); i >= 0 {
+			w, err := strconv.Atoi(strings.TrimSpace(u[i+1:]))
+			if err != nil || w <= 0 {
+				slog.Warn("Ignoring invalid backend weight", "backend", u)
+			} else {
+				weight = w
+			}
+			u = strings.TrimSpace(u[:i])
+		}
+		if u = strings.TrimRight(u, "/"); u != "" {
+			list = append(list, &backend{url: u, weight: weight})
+		}
+	}
+	return list
+}
+
+// healthy reports whether the backend is outside its failure cooldown
+func (b *backend) healthy() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().After(b.downUntil)
+}
+
+// markSuccess resets the backend's consecutive failure count
+func (b *backend) markSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// markFailure records a failed request and takes the backend out of
+// rotation once it reaches the failure threshold
+func (b *backend) markFailure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures >= backendFailureThreshold {
+		b.failures = 0
+		b.downUntil = time.Now().Add(backendCooldown)
+		slog.Warn("Backend marked unhealthy", "backend", b.url, "cooldown", backendCooldown.String())
+	}
+}
+
+var (
+	endpointPoolsMu sync.Mutex
+	endpointPools   = map[string][]*backend{}
+)
+
+// endpointBackends returns the backends serving endpoint, from its
+// BACKEND_<ENDPOINT>_URL env var (e.g. BACKEND_PREDICTIONS_URL), falling
+// back to the BACKEND_URL backends when it is unset
+func endpointBackends(endpoint string) []*backend {
+	endpointPoolsMu.Lock()
+	defer endpointPoolsMu.Unlock()
+
+	pool, ok := endpointPools[endpoint]
+	if !ok {
+		pool = parseBackends(getEnv(endpointEnvKey("BACKEND_", endpoint)+"_URL", ""))
+		endpointPools[endpoint] = pool
+	}
+	if len(pool) == 0 {
+		return backends
+	}
+	return pool
+}
+
+// candidateBackends returns the healthy backends in order, or every backend
+// if none are currently healthy. In pools with weights the first candidate
+// is picked by weighted round-robin, the rest following in order.
+func candidateBackends(pool []*backend) []*backend {
+	var healthy []*backend
+	for _, b := range pool {
+		if b.healthy() {
+			healthy = append(healthy, b)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = pool
+	}
+	if len(healthy) < 2 || !isWeighted(pool) {
+		return healthy
+	}
+
+	first := pickWeighted(healthy)
+	ordered := make([]*backend, 0, len(healthy))
+	ordered = append(ordered, healthy[first])
+	ordered = append(ordered, healthy[:first]...)
+	return append(ordered, healthy[first+1:]...)
+}
+
+// isWeighted reports whether any backend of pool was given a weight
+func isWeighted(pool []*backend) bool {
+	for _, b := range pool {
+		if b.weight > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// weightedMu guards the round-robin state of every backend
+var weightedMu sync.Mutex
+
+// pickWeighted returns the index of the next of candidates by smooth
+// weighted round-robin, which spreads each backend's share evenly instead
+// of sending it in bursts. Backends without a weight count as weight 1.
+func pickWeighted(candidates []*backend) int {
+	weightedMu.Lock()
+	defer weightedMu.Unlock()
+
+	best, total := 0, 0
+	for i, b := range candidates {
+		weight := max(b.weight, 1)
+		b.currentWeight += weight
+		total += weight
+		if b.currentWeight > candidates[best].currentWeight {
+			best = i
+		}
+	}
+	candidates[best].currentWeight -= total
+	return best
+}
+
+// tryBackends sends a request for uri (path and query) to the first healthy
+// backend in pool, failing over to the next one on connection errors or 5xx
+// responses. The response from the last backend tried is returned.
+func tryBackends(ctx context.Context, pool []*backend, method, uri string, header http.Header, body []byte) (*http.Response, error) {
+	candidates := candidateBackends(pool)
+	for i, b := range candidates {
+		var bodyReader io.Reader
+		if len(body) > 0 {
+			bodyReader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, b.url+uri, bodyReader)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			req.Header = header.Clone()
+		}
+		injectTraceContext(ctx, req)
+
+		resp, err := backendClient.Do(req)
+		if ctx.Err() != nil {
+			// The client went away, which says nothing about backend health
+			return resp, err
+		}
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			b.markSuccess()
+			return resp, nil
+		}
+
+		b.markFailure()
+		if i == len(candidates)-1 || !canResend(method, err) {
+			return resp, err
+		}
+		if err != nil {
+			sampledLog.Warn("Backend failed, trying next", "backend", b.url, "error", err)
+		} else {
+			sampledLog.Warn("Backend returned error status, trying next", "backend", b.url, "status", resp.StatusCode)
+			resp.Body.Close()
+		}
+	}
+	return nil, errNoBackends
+}
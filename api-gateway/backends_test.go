@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFailoverSkipsUnhealthyBackend(t *testing.T) {
+	bad := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	good := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &backends, parseBackends(bad.URL+","+good.URL))
+	setForTest(t, &backendFailureThreshold, 1)
+	setForTest(t, &backendCooldown, time.Minute)
+	setForTest(t, &backendMaxRetries, 0)
+
+	cached := cachedTestRouter("failover-cached", time.Minute, time.Minute)
+	direct := directTestRouter(t, "failover-direct", http.MethodGet)
+	for _, target := range []string{"/api/failover-cached?q=1", "/api/failover-direct", "/api/failover-cached?q=2"} {
+		r := cached
+		if target == "/api/failover-direct" {
+			r = direct
+		}
+		if w := get(r, target); w.Code != http.StatusOK || w.Body.String() != `{"ok":true}` {
+			t.Errorf("%s: status %d, body %q", target, w.Code, w.Body)
+		}
+	}
+
+	if hits := bad.hits.Load(); hits != 1 {
+		t.Errorf("failing backend got %d requests, want 1 before its cooldown", hits)
+	}
+	if hits := good.hits.Load(); hits != 3 {
+		t.Errorf("healthy backend got %d requests, want 3", hits)
+	}
+}
+
+func TestFailoverUsesEveryBackendWhenAllAreDown(t *testing.T) {
+	a := &backend{url: "http://a", downUntil: time.Now().Add(time.Minute)}
+	b := &backend{url: "http://b", downUntil: time.Now().Add(time.Minute)}
+	if got := candidateBackends([]*backend{a, b}); len(got) != 2 {
+		t.Errorf("candidateBackends returned %d backends, want both", len(got))
+	}
+
+	b.downUntil = time.Time{}
+	if got := candidateBackends([]*backend{a, b}); len(got) != 1 || got[0] != b {
+		t.Errorf("candidateBackends = %v, want only the healthy backend", got)
+	}
+}
+
+func TestParseBackends(t *testing.T) {
+	list := parseBackends(" http://a/ ,, http://b ")
+	if len(list) != 2 || list[0].url != "http://a" || list[1].url != "http://b" {
+		t.Errorf("parseBackends returned %d backends, want http://a and http://b", len(list))
+	}
+}
+
+func TestBackendPathPrefix(t *testing.T) {
+	var path atomic.Value
+	ok := jsonBackend(`{"ok":true}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		path.Store(r.URL.RequestURI())
+		ok(w, r)
+	})
+	cached := cachedTestRouter("prefix-cached", time.Minute, time.Minute)
+	direct := directTestRouter(t, "prefix-direct", http.MethodPost)
+
+	for _, tc := range []struct {
+		prefix, want string
+	}{
+		{"", "/api/prefix-%s?a=1"},
+		{"v1", "/v1/api/prefix-%s?a=1"},
+		{"/v1/", "/v1/api/prefix-%s?a=1"},
+		{" /internal/v2 ", "/internal/v2/api/prefix-%s?a=1"},
+	} {
+		setForTest(t, &backendPathPrefix, normalizePathPrefix(tc.prefix))
+		get(cached, "/api/prefix-cached?a=1&prefix="+url.QueryEscape(tc.prefix))
+		if got, want := path.Load(), fmt.Sprintf(tc.want, "cached")+"&prefix="+url.QueryEscape(tc.prefix); got != want {
+			t.Errorf("prefix %q: cached proxy requested %v, want %s", tc.prefix, got, want)
+		}
+		serve(direct, http.MethodPost, "/api/prefix-direct?a=1", nil, `{}`)
+		if got, want := path.Load(), fmt.Sprintf(tc.want, "direct"); got != want {
+			t.Errorf("prefix %q: direct proxy requested %v, want %s", tc.prefix, got, want)
+		}
+	}
+}
+
+func TestBackendURIRejectsUnknownEndpoints(t *testing.T) {
+	if _, err := backendURI("../admin", ""); !errors.Is(err, errUnknownEndpoint) {
+		t.Errorf("backendURI of an unregistered endpoint returned %v, want errUnknownEndpoint", err)
+	}
+}
+
+func TestEndpointBackendOverride(t *testing.T) {
+	ml := newTestBackend(t, jsonBackend(`{"source":"ml"}`))
+	primary := newTestBackend(t, jsonBackend(`{"source":"main"}`))
+	t.Setenv("BACKEND_OVERRIDE_PREDICTIONS_URL", ml.URL)
+	t.Setenv("BACKEND_OVERRIDE_TRAIN_URL", ml.URL)
+	t.Cleanup(func() {
+		endpointPoolsMu.Lock()
+		defer endpointPoolsMu.Unlock()
+		for _, endpoint := range []string{"override-predictions", "override-prices", "override-train"} {
+			delete(endpointPools, endpoint)
+		}
+	})
+
+	for _, tc := range []struct {
+		r      http.Handler
+		target string
+		want   string
+	}{
+		{cachedTestRouter("override-predictions", time.Minute, time.Minute), "/api/override-predictions", `{"source":"ml"}`},
+		{cachedTestRouter("override-prices", time.Minute, time.Minute), "/api/override-prices", `{"source":"main"}`},
+		{directTestRouter(t, "override-train", http.MethodGet), "/api/override-train", `{"source":"ml"}`},
+	} {
+		if w := get(tc.r, tc.target); w.Body.String() != tc.want {
+			t.Errorf("%s: body %s, want %s", tc.target, w.Body, tc.want)
+		}
+	}
+	if hits := ml.hits.Load(); hits != 2 {
+		t.Errorf("override backend hit %d times, want 2", hits)
+	}
+	if hits := primary.hits.Load(); hits != 1 {
+		t.Errorf("BACKEND_URL backend hit %d times, want 1", hits)
+	}
+}
+
+func TestBackendURISanitizesQuery(t *testing.T) {
+	directTestRouter(t, "sanitized")
+	for _, tc := range []struct {
+		rawQuery, want string
+	}{
+		{"symbol=BTC", "/api/sanitized?symbol=BTC"},
+		{"symbol=BTC%23admin", "/api/sanitized?symbol=BTC%23admin"},
+		{"symbol=BTC%26admin%3Dtrue", "/api/sanitized?symbol=BTC%26admin%3Dtrue"},
+		{"symbol=../../admin", "/api/sanitized?symbol=..%2F..%2Fadmin"},
+		{"symbol=BTC%0D%0AHost:%20evil", "/api/sanitized?symbol=BTC%0D%0AHost%3A+evil"},
+		{"a=%zz&symbol=BTC", "/api/sanitized?symbol=BTC"},
+		{"a=1;b=2&symbol=BTC", "/api/sanitized?symbol=BTC"},
+		{"", "/api/sanitized"},
+	} {
+		got, err := backendURI("sanitized", tc.rawQuery)
+		if err != nil || got != tc.want {
+			t.Errorf("backendURI(%q) = %q, %v, want %q", tc.rawQuery, got, err, tc.want)
+		}
+	}
+}
+
+func TestProxiesForwardCraftedQueriesSafely(t *testing.T) {
+	var uri atomic.Value
+	ok := jsonBackend(`{"ok":true}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		uri.Store(r.URL.RequestURI())
+		ok(w, r)
+	})
+	cached := cachedTestRouter("crafted-cached", time.Minute, time.Minute)
+	direct := directTestRouter(t, "crafted-direct", http.MethodGet)
+
+	for _, tc := range []struct {
+		r      http.Handler
+		target string
+		want   string
+	}{
+		{cached, "/api/crafted-cached?symbol=BTC%23&vs=usd#admin", "/api/crafted-cached?symbol=BTC%23&vs=usd%23admin"},
+		{direct, "/api/crafted-direct?symbol=BTC%26admin%3D1", "/api/crafted-direct?symbol=BTC%26admin%3D1"},
+		{direct, "/api/crafted-direct?path=%2F..%2Fadmin%2Fcache", "/api/crafted-direct?path=%2F..%2Fadmin%2Fcache"},
+	} {
+		if w := get(tc.r, tc.target); w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", tc.target, w.Code)
+		}
+		if got := uri.Load(); got != tc.want {
+			t.Errorf("%s: backend requested %v, want %s", tc.target, got, tc.want)
+		}
+	}
+}
+
+func TestParseWeightedBackends(t *testing.T) {
+	list := parseBackends("http://a|3, http://b/ | 1 ,http://c|x,http://d|0")
+	want := []backend{{url: "http://a", weight: 3}, {url: "http://b", weight: 1}, {url: "http://c"}, {url: "http://d"}}
+	if len(list) != len(want) {
+		t.Fatalf("parseBackends returned %d backends, want %d", len(list), len(want))
+	}
+	for i, b := range list {
+		if b.url != want[i].url || b.weight != want[i].weight {
+			t.Errorf("backend %d = %s with weight %d, want %s with weight %d", i, b.url, b.weight, want[i].url, want[i].weight)
+		}
+	}
+}
+
+func TestWeightedBackendDistribution(t *testing.T) {
+	big := newTestBackend(t, jsonBackend(`{}`))
+	small := newTestBackend(t, jsonBackend(`{}`))
+	setForTest(t, &backends, parseBackends(big.URL+"|3,"+small.URL+"|1"))
+	r := directTestRouter(t, "weighted-direct", http.MethodGet)
+
+	const requests = 400
+	for i := 0; i < requests; i++ {
+		if w := get(r, "/api/weighted-direct"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	share := float64(big.hits.Load()) / requests
+	if share < 0.7 || share > 0.8 {
+		t.Errorf("big backend got %d of %d requests, want about 3/4", big.hits.Load(), requests)
+	}
+	if big.hits.Load()+small.hits.Load() != requests {
+		t.Errorf("backends got %d and %d requests, want %d in all", big.hits.Load(), small.hits.Load(), requests)
+	}
+
+	// Unhealthy backends are skipped whatever their weight
+	backends[0].downUntil = time.Now().Add(time.Minute)
+	before := small.hits.Load()
+	for i := 0; i < 20; i++ {
+		get(r, "/api/weighted-direct")
+	}
+	if got := small.hits.Load() - before; got != 20 {
+		t.Errorf("small backend got %d of 20 requests while the big one was down, want all", got)
+	}
+}
+
+func TestPickWeightedSpreadsRequests(t *testing.T) {
+	a, b := &backend{url: "http://a", weight: 3}, &backend{url: "http://b", weight: 1}
+	var picks []string
+	for i := 0; i < 8; i++ {
+		picks = append(picks, []*backend{a, b}[pickWeighted([]*backend{a, b})].url)
+	}
+	for i := 0; i+4 <= len(picks); i += 4 {
+		n := 0
+		for _, url := range picks[i : i+4] {
+			if url == "http://a" {
+				n++
+			}
+		}
+		if n != 3 {
+			t.Errorf("picks %v, want a three times in every four", picks)
+		}
+	}
+	if picks[0] == picks[1] && picks[1] == picks[2] {
+		t.Errorf("picks %v, want b interleaved rather than a burst of a", picks)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// batchResult is the outcome of one sub-resource of a batch request
+type batchResult struct {
+	Status int             `json:"status"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+
+	// err is the backend error behind Error, logged rather than sent when
+	// its details are internal
+	err error
+}
+
+// batchHandler serves several cached endpoints in one response, keyed by
+// resource name. The cached resources are read in one cache round trip and
+// missing ones are fetched concurrently. A failing resource reports its own
+// error instead of failing the batch.
+func batchHandler(c *gin.Context) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(c.Query("include"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		errorResponse(c, http.StatusBadRequest, "missing_include", "include parameter is required")
+		return
+	}
+
+	ctx := c.Request.Context()
+	cacheKeys := make([]string, 0, len(names))
+	for _, name := range names {
+		if isWarmableEndpoint(name) {
+			cacheKeys = append(cacheKeys, defaultCacheKey(ctx, name, ""))
+		}
+	}
+	entries := getCacheEntries(ctx, cacheKeys)
+
+	results := make([]batchResult, len(names))
+	var g errgroup.Group
+	next := 0
+	for i, name := range names {
+		settings, ok := cachedEndpoints[name]
+		if !ok {
+			results[i] = batchResult{Status: http.StatusNotFound, Error: "Unknown resource"}
+			continue
+		}
+		if !settings.warmable {
+			results[i] = batchResult{Status: http.StatusBadRequest, Error: "Resource requires query parameters"}
+			continue
+		}
+		cacheKey, entry := cacheKeys[next], entries[next]
+		next++
+		if entry != nil {
+			results[i] = batchBodyResult(cachedEntryBody(name, "", cacheKey, entry, settings))
+			continue
+		}
+		i, name := i, name
+		g.Go(func() error {
+			results[i] = batchBodyResult(fetchCachedBody(ctx, name, "", cacheKey, settings))
+			return nil
+		})
+	}
+	g.Wait()
+
+	if c.Request.Context().Err() != nil {
+		c.Abort()
+		return
+	}
+
+	response := make(map[string]batchResult, len(names))
+	for i, name := range names {
+		response[name] = results[i]
+		if err := results[i].err; err != nil {
+			sampledLog.Warn("Batch resource failed", "resource", name, "error", err, "request_id", c.GetString("request_id"))
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// batchBodyResult returns the batch result of the default payload of one
+// cached endpoint, as loaded by cachedEntryBody or fetchCachedBody
+func batchBodyResult(body []byte, status int, _ string, err error) batchResult {
+	if err != nil {
+		status, code, message := backendErrorStatus(err)
+		result := batchResult{Status: status, Error: message}
+		if code == "backend_error" {
+			result.err = err
+		}
+		return result
+	}
+	if status != http.StatusOK {
+		return batchResult{Status: status, Error: fmt.Sprintf("backend returned status %d", status)}
+	}
+	if !json.Valid(body) {
+		return batchResult{Status: http.StatusBadGateway, Error: "backend returned invalid JSON"}
+	}
+	return batchResult{Status: status, Data: body}
+}
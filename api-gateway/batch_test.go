@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// batchTestRouter returns a router serving /api/batch over the cached
+// endpoints batch-prices and batch-news, whose backend fails news requests
+// while newsDown is set
+func batchTestRouter(t *testing.T, newsDown *atomic.Bool) (*gin.Engine, *testBackend) {
+	t.Helper()
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/batch-news" && newsDown.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"path":%q}`, r.URL.Path)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	cachedProxy("batch-prices", time.Minute, time.Minute, 0)
+	cachedProxy("batch-news", time.Minute, time.Minute, 0)
+	r := gin.New()
+	r.GET("/api/batch", batchHandler)
+	return r, backend
+}
+
+// getBatch requests the batch of include and decodes its results
+func getBatch(t *testing.T, r *gin.Engine, include string) map[string]batchResult {
+	t.Helper()
+	w := get(r, "/api/batch?include="+include)
+	if w.Code != http.StatusOK {
+		t.Fatalf("batch status %d, body %s", w.Code, w.Body)
+	}
+	var results map[string]batchResult
+	decodeJSON(t, w, &results)
+	return results
+}
+
+func TestBatchFullSuccess(t *testing.T) {
+	r, backend := batchTestRouter(t, new(atomic.Bool))
+
+	for i := 0; i < 2; i++ {
+		results := getBatch(t, r, "batch-prices,batch-news,batch-prices")
+		if len(results) != 2 {
+			t.Fatalf("got %d results, want 2", len(results))
+		}
+		for name, result := range results {
+			if want := `{"path":"/api/` + name + `"}`; result.Status != http.StatusOK || string(result.Data) != want {
+				t.Errorf("%s: status %d, data %s, want %s", name, result.Status, result.Data, want)
+			}
+		}
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2 as the second batch is served from the cache", hits)
+	}
+}
+
+func TestBatchPartialFailure(t *testing.T) {
+	newsDown := new(atomic.Bool)
+	newsDown.Store(true)
+	r, _ := batchTestRouter(t, newsDown)
+	cachedProxy("batch-history", time.Minute, time.Minute, 0)
+	requireQuery("batch-history")
+
+	results := getBatch(t, r, "batch-prices,batch-news,batch-unknown,batch-history")
+	if prices := results["batch-prices"]; prices.Status != http.StatusOK || prices.Data == nil {
+		t.Errorf("batch-prices: status %d, error %q, want its data", prices.Status, prices.Error)
+	}
+	if news := results["batch-news"]; news.Status < http.StatusInternalServerError || news.Error == "" || news.Data != nil {
+		t.Errorf("batch-news: status %d, error %q, want a backend error", news.Status, news.Error)
+	}
+	if unknown := results["batch-unknown"]; unknown.Status != http.StatusNotFound {
+		t.Errorf("batch-unknown: status %d, want 404", unknown.Status)
+	}
+	if history := results["batch-history"]; history.Status != http.StatusBadRequest {
+		t.Errorf("batch-history: status %d, want 400 as it requires query params", history.Status)
+	}
+
+	if w := get(r, "/api/batch"); w.Code != http.StatusBadRequest || errorCode(t, w) != "missing_include" {
+		t.Errorf("without include: status %d, body %s, want 400 missing_include", w.Code, w.Body)
+	}
+}
+
+// commandLog is a Redis hook recording the commands sent on their own and
+// the commands of each pipeline
+type commandLog struct {
+	mu        sync.Mutex
+	commands  []string
+	pipelines [][]string
+}
+
+// BeforeProcess records a command sent on its own
+func (l *commandLog) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.commands = append(l.commands, cmd.Name())
+	return ctx, nil
+}
+
+// AfterProcess implements redis.Hook
+func (l *commandLog) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+// BeforeProcessPipeline records the commands of a pipeline
+func (l *commandLog) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var names []string
+	for _, cmd := range cmds {
+		names = append(names, cmd.Name())
+	}
+	l.pipelines = append(l.pipelines, names)
+	return ctx, nil
+}
+
+// AfterProcessPipeline implements redis.Hook
+func (l *commandLog) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// reset forgets the commands recorded so far
+func (l *commandLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.commands, l.pipelines = nil, nil
+}
+
+func TestBatchLooksUpCacheInOnePipeline(t *testing.T) {
+	r, backend := batchTestRouter(t, new(atomic.Bool))
+	newTestRedis(t)
+	commands := &commandLog{}
+	rdb.AddHook(commands)
+	getBatch(t, r, "batch-prices,batch-news")
+	commands.reset()
+
+	getBatch(t, r, "batch-prices,batch-news")
+	commands.mu.Lock()
+	if want := [][]string{{"get", "ttl", "get", "ttl"}}; !reflect.DeepEqual(commands.pipelines, want) || len(commands.commands) != 0 {
+		t.Errorf("cached batch sent pipelines %v and commands %v, want one pipeline of lookups", commands.pipelines, commands.commands)
+	}
+	commands.mu.Unlock()
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want the cached batch served from Redis", hits)
+	}
+
+	if _, err := cacheStore.Del(context.Background(), cacheKeyFor("batch-news", "")); err != nil {
+		t.Fatal(err)
+	}
+	commands.reset()
+	results := getBatch(t, r, "batch-prices,batch-news")
+	if results["batch-news"].Status != http.StatusOK || backend.hits.Load() != 3 {
+		t.Errorf("missed key: result %+v, backend hit %d times, want it fetched from the backend", results["batch-news"], backend.hits.Load())
+	}
+	commands.mu.Lock()
+	if len(commands.pipelines) == 0 || !reflect.DeepEqual(commands.pipelines[0], []string{"get", "ttl", "get", "ttl"}) {
+		t.Errorf("pipelines %v, want the lookups in the first", commands.pipelines)
+	}
+	commands.mu.Unlock()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logBodyMaxBytes caps the request and response body snippets logged at
+// debug level, from the LOG_BODY_MAX_BYTES env var
+var logBodyMaxBytes = getEnvInt("LOG_BODY_MAX_BYTES", 512)
+
+// redactedNames are the headers and query params, lowercased, whose values
+// are never logged
+var redactedNames = map[string]bool{
+	"authorization": true,
+	"x-api-key":     true,
+	"api_key":       true,
+	"apikey":        true,
+	"access_token":  true,
+}
+
+// redactedValue replaces the values of redactedNames in logs
+const redactedValue = "REDACTED"
+
+// logBodies creates a middleware that, while LOG_LEVEL is DEBUG, logs each
+// request's query and headers with secrets redacted and the first
+// LOG_BODY_MAX_BYTES of its request and response bodies. At other levels it
+// does nothing, so full bodies are never logged.
+func logBodies() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if logLevelVar.Level() > slog.LevelDebug || logBodyMaxBytes <= 0 {
+			c.Next()
+			return
+		}
+
+		reqBody := &snippetReader{ReadCloser: c.Request.Body, snippet: snippet{max: logBodyMaxBytes}}
+		c.Request.Body = reqBody
+		writer := &snippetResponseWriter{ResponseWriter: c.Writer, snippet: snippet{max: logBodyMaxBytes}}
+		c.Writer = writer
+		c.Next()
+
+		slog.Debug("request bodies",
+			"request_id", c.GetString("request_id"),
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"query", redactQuery(c.Request.URL.RawQuery),
+			"headers", redactHeaders(c.Request.Header),
+			"request_body", reqBody.String(),
+			"request_bytes", reqBody.total,
+			"response_body", writer.String(),
+			"response_bytes", writer.total,
+		)
+	}
+}
+
+// redactQuery returns rawQuery with the values of redactedNames replaced
+func redactQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return redactedValue
+	}
+	for name := range values {
+		if redactedNames[strings.ToLower(name)] {
+			values[name] = []string{redactedValue}
+		}
+	}
+	return values.Encode()
+}
+
+// redactHeaders returns header as a map with the values of redactedNames
+// replaced
+func redactHeaders(header http.Header) map[string]string {
+	redacted := make(map[string]string, len(header))
+	for name, values := range header {
+		if redactedNames[strings.ToLower(name)] {
+			redacted[name] = redactedValue
+			continue
+		}
+		redacted[name] = strings.Join(values, ", ")
+	}
+	return redacted
+}
+
+// snippet keeps the first max bytes written to it and counts the rest
+type snippet struct {
+	max   int
+	buf   []byte
+	total int
+}
+
+// keep records data
+func (s *snippet) keep(data []byte) {
+	s.total += len(data)
+	if room := s.max - len(s.buf); room > 0 {
+		s.buf = append(s.buf, data[:min(room, len(data))]...)
+	}
+}
+
+// String returns the kept bytes, marking truncation
+func (s *snippet) String() string {
+	if s.total > len(s.buf) {
+		return string(s.buf) + "...(truncated)"
+	}
+	return string(s.buf)
+}
+
+// snippetReader keeps a snippet of a request body as it is read
+type snippetReader struct {
+	io.ReadCloser
+	snippet
+}
+
+// Read reads from the body, keeping a snippet
+func (r *snippetReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.keep(p[:n])
+	return n, err
+}
+
+// snippetResponseWriter keeps a snippet of a response body as it is written
+type snippetResponseWriter struct {
+	gin.ResponseWriter
+	snippet
+}
+
+// Write writes data, keeping a snippet
+func (w *snippetResponseWriter) Write(data []byte) (int, error) {
+	w.keep(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString writes s, keeping a snippet
+func (w *snippetResponseWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
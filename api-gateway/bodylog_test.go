@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bodyLogTestRouter returns a router logging the bodies of a direct proxy at
+// level, with snippets capped at maxBytes
+func bodyLogTestRouter(t *testing.T, level slog.Level, maxBytes int) *gin.Engine {
+	t.Helper()
+	previous := logLevelVar.Level()
+	logLevelVar.Set(level)
+	t.Cleanup(func() { logLevelVar.Set(previous) })
+	setForTest(t, &logBodyMaxBytes, maxBytes)
+	directEndpoints["bodylog"] = true
+	t.Cleanup(func() { delete(directEndpoints, "bodylog") })
+	r := gin.New()
+	r.Use(logBodies())
+	r.POST("/api/bodylog", directProxy)
+	return r
+}
+
+func TestLogBodiesAtDebugLevel(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"price":50000}`))
+	logs := captureLogs(t, slog.LevelDebug)
+	r := bodyLogTestRouter(t, slog.LevelDebug, 512)
+	header := http.Header{"Authorization": {"Bearer secret-token"}, "X-API-Key": {"secret-key"}, "X-Trace": {"abc"}}
+
+	w := serve(r, http.MethodPost, "/api/bodylog?symbol=BTC&api_key=secret-param", header, `{"symbols":["BTC"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	lines := logs.lines(t, "request bodies")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d body lines, want 1", len(lines))
+	}
+	line := lines[0]
+	if line["request_body"] != `{"symbols":["BTC"]}` || line["response_body"] != `{"price":50000}` {
+		t.Errorf("logged bodies %q and %q", line["request_body"], line["response_body"])
+	}
+	if line["query"] != "api_key=REDACTED&symbol=BTC" {
+		t.Errorf("logged query %q, want api_key redacted", line["query"])
+	}
+	headers, _ := line["headers"].(map[string]any)
+	if headers["Authorization"] != redactedValue || headers["X-Api-Key"] != redactedValue || headers["X-Trace"] != "abc" {
+		t.Errorf("logged headers %v, want Authorization and X-API-Key redacted", headers)
+	}
+	if logged := fmt.Sprint(line); strings.Contains(logged, "secret") {
+		t.Errorf("secrets logged: %s", logged)
+	}
+}
+
+func TestLogBodiesTruncatesSnippets(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"price":50000}`))
+	logs := captureLogs(t, slog.LevelDebug)
+	r := bodyLogTestRouter(t, slog.LevelDebug, 8)
+
+	serve(r, http.MethodPost, "/api/bodylog", nil, `{"symbols":["BTC","ETH"]}`)
+	lines := logs.lines(t, "request bodies")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d body lines, want 1", len(lines))
+	}
+	line := lines[0]
+	if line["request_body"] != `{"symbol...(truncated)` || line["request_bytes"] != float64(25) {
+		t.Errorf("request body logged as %q of %v bytes", line["request_body"], line["request_bytes"])
+	}
+	if line["response_body"] != `{"price"...(truncated)` || line["response_bytes"] != float64(15) {
+		t.Errorf("response body logged as %q of %v bytes", line["response_body"], line["response_bytes"])
+	}
+}
+
+func TestLogBodiesOffAboveDebugLevel(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"price":50000}`))
+	logs := captureLogs(t, slog.LevelDebug)
+	r := bodyLogTestRouter(t, slog.LevelInfo, 512)
+
+	if w := serve(r, http.MethodPost, "/api/bodylog", nil, `{"symbols":["BTC"]}`); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if lines := logs.lines(t, "request bodies"); len(lines) != 0 {
+		t.Errorf("logged bodies at INFO level: %v", lines)
+	}
+}
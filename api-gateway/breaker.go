@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/sony/gobreaker"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// errCircuitOpen is returned while the backend circuit breaker is open
+var errCircuitOpen = errors.New("backend circuit breaker open")
+
+// backendBreaker opens after BREAKER_FAILURE_THRESHOLD consecutive failed
+// backend requests, failing fast until BREAKER_OPEN_TIMEOUT passes and a
+// probe request succeeds
+var backendBreaker = newBackendBreaker(
+	uint32(getEnvInt("BREAKER_FAILURE_THRESHOLD", 5)),
+	getEnvDuration("BREAKER_OPEN_TIMEOUT", 30*time.Second),
+)
+
+// newBackendBreaker creates the circuit breaker guarding backend requests
+func newBackendBreaker(threshold uint32, openTimeout time.Duration) *gobreaker.TwoStepCircuitBreaker {
+	return gobreaker.NewTwoStepCircuitBreaker(gobreaker.Settings{
+		Name:        "backend",
+		MaxRequests: 1,
+		Timeout:     openTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= threshold
+		},
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			slog.Warn("Circuit breaker state changed", "breaker", name, "from", from.String(), "to", to.String())
+		},
+	})
+}
+
+// doBackendRequest sends a request for uri (path and query) to the pool of
+// backends through the circuit breaker, returning errCircuitOpen without contacting
+// the backend while it is open
+func doBackendRequest(ctx context.Context, pool []*backend, method, uri string, header http.Header, body []byte) (*http.Response, error) {
+	ctx, span := tracer.Start(ctx, "backend.request", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.method", method), attribute.String("http.target", uri)))
+	defer span.End()
+
+	done, err := backendBreaker.Allow()
+	if err != nil {
+		span.SetStatus(codes.Error, errCircuitOpen.Error())
+		return nil, errCircuitOpen
+	}
+
+	resp, err := retryBackendRequest(ctx, pool, method, uri, header, body)
+	// A client disconnect says nothing about backend health
+	done(ctx.Err() != nil || (err == nil && resp.StatusCode < http.StatusInternalServerError))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	}
+	return resp, err
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sony/gobreaker"
+)
+
+func TestBreakerOpensFailsFastAndRecovers(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	ok := jsonBackend(`{"ok":true}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok(w, r)
+	})
+	logs := captureLogs(t, slog.LevelWarn)
+	setForTest(t, &backendBreaker, newBackendBreaker(2, 50*time.Millisecond))
+	setForTest(t, &backendMaxRetries, 0)
+	r := directTestRouter(t, "breaker-direct", http.MethodGet)
+
+	for i := 0; i < 2; i++ {
+		get(r, "/api/breaker-direct")
+	}
+	if state := backendBreaker.State(); state != gobreaker.StateOpen {
+		t.Fatalf("breaker %s after 2 failures, want open", state)
+	}
+
+	start := time.Now()
+	w := get(r, "/api/breaker-direct")
+	if w.Code != http.StatusServiceUnavailable || errorCode(t, w) != "backend_unavailable" {
+		t.Errorf("open breaker: status %d, body %s, want 503 backend_unavailable", w.Code, w.Body)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("open breaker took %s to fail", elapsed)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2 as the open breaker skips it", hits)
+	}
+
+	down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if w := get(r, "/api/breaker-direct"); w.Code != http.StatusOK {
+		t.Errorf("half-open probe: status %d, want 200", w.Code)
+	}
+	if state := backendBreaker.State(); state != gobreaker.StateClosed {
+		t.Errorf("breaker %s after a successful probe, want closed", state)
+	}
+
+	var transitions []string
+	for _, line := range logs.lines(t, "Circuit breaker state changed") {
+		transitions = append(transitions, line["from"].(string)+"->"+line["to"].(string))
+	}
+	if len(transitions) != 3 || transitions[0] != "closed->open" || transitions[1] != "open->half-open" || transitions[2] != "half-open->closed" {
+		t.Errorf("logged transitions %v, want closed->open->half-open->closed", transitions)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/errgroup"
+)
+
+// bulkPricesEndpoint serves the prices of several symbols in one response
+const bulkPricesEndpoint = "prices/bulk"
+
+var (
+	// maxBulkSymbols caps the symbols of a bulk prices request, from the
+	// MAX_BULK_SYMBOLS env var
+	maxBulkSymbols = getEnvInt("MAX_BULK_SYMBOLS", 20)
+
+	// bulkPricesMode is how bulk prices are fetched, from the
+	// BULK_PRICES_MODE env var: fanout requests each symbol concurrently
+	// through the prices cache, backend forwards to the backend's own
+	// /api/prices/bulk
+	bulkPricesMode = strings.ToLower(getEnv("BULK_PRICES_MODE", "fanout"))
+)
+
+// registerBulkPrices registers /api/prices/bulk?symbols=BTC,ETH, cached like
+// prices and fetched as configured by BULK_PRICES_MODE
+func registerBulkPrices(r gin.IRoutes, route routeConfig, middleware []gin.HandlerFunc) {
+	if bulkPricesMode != "backend" {
+		endpointFetchers[bulkPricesEndpoint] = fanOutPrices
+	}
+	cachedRoute(r, bulkPricesEndpoint, route.ttl, route.MaxCacheBytes, append(middleware, bulkSymbols())...)
+	requireQuery(bulkPricesEndpoint)
+}
+
+// bulkSymbols creates a middleware that validates the comma-separated
+// symbols query param and rewrites it sorted and without duplicates, so
+// equivalent requests share a cache entry. It rejects requests for no
+// symbols or over maxBulkSymbols.
+func bulkSymbols() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		symbols, err := parseSymbols(c.Query("symbols"))
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "invalid_symbols", err.Error())
+			return
+		}
+		query := c.Request.URL.Query()
+		query.Set("symbols", strings.Join(symbols, ","))
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
+
+// parseSymbols splits a comma-separated list of symbols, returning them
+// sorted and deduplicated
+func parseSymbols(value string) ([]string, error) {
+	seen := map[string]bool{}
+	var symbols []string
+	for _, symbol := range strings.Split(value, ",") {
+		symbol = strings.TrimSpace(symbol)
+		if symbol == "" || seen[symbol] {
+			continue
+		}
+		if !symbolPattern.MatchString(symbol) {
+			return nil, fmt.Errorf("Invalid symbol %q", symbol)
+		}
+		seen[symbol] = true
+		symbols = append(symbols, symbol)
+	}
+	if len(symbols) == 0 {
+		return nil, fmt.Errorf("symbols parameter is required")
+	}
+	if len(symbols) > maxBulkSymbols {
+		return nil, fmt.Errorf("at most %d symbols are allowed", maxBulkSymbols)
+	}
+	sort.Strings(symbols)
+	return symbols, nil
+}
+
+// fanOutPrices fetches the price of each symbol in rawQuery concurrently
+// through the prices cache and merges them into a JSON object keyed by
+// symbol. The first symbol that fails fails the whole response.
+func fanOutPrices(ctx context.Context, rawQuery string, header http.Header) (*backendResponse, error) {
+	values, _ := url.ParseQuery(rawQuery)
+	symbols, err := parseSymbols(values.Get("symbols"))
+	if err != nil {
+		return nil, err
+	}
+	values.Del("symbols")
+
+	bodies := make([]json.RawMessage, len(symbols))
+	statuses := make([]int, len(symbols))
+	g, gctx := errgroup.WithContext(ctx)
+	for i, symbol := range symbols {
+		i, symbol := i, symbol
+		query := url.Values{}
+		for name, vs := range values {
+			query[name] = vs
+		}
+		query.Set("symbol", symbol)
+		g.Go(func() error {
+			body, status, _, err := loadCachedBody(gctx, "prices", normalizeQuery(query.Encode()))
+			bodies[i], statuses[i] = body, status
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	merged := make(map[string]json.RawMessage, len(symbols))
+	for i, symbol := range symbols {
+		if statuses[i] != http.StatusOK || !json.Valid(bodies[i]) {
+			body, _ := json.Marshal(gin.H{"error": fmt.Sprintf("Error fetching price of %s: backend returned status %d", symbol, statuses[i])})
+			return jsonResponse(http.StatusBadGateway, body), nil
+		}
+		merged[symbol] = bodies[i]
+	}
+	// Not HTML-escaped so the backend's bodies are kept as they are
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(merged); err != nil {
+		return nil, err
+	}
+	return jsonResponse(http.StatusOK, bytes.TrimSuffix(body.Bytes(), []byte("\n"))), nil
+}
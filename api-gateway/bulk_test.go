@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkPricesFansOutConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	arrived := 0
+	allArrived := make(chan struct{})
+	var concurrent atomic.Bool
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		symbol := r.URL.Query().Get("symbol")
+		if symbol != "SOL" {
+			// The first request's symbols only all arrive if they are
+			// fetched concurrently
+			mu.Lock()
+			if arrived++; arrived == 2 {
+				close(allArrived)
+			}
+			mu.Unlock()
+			select {
+			case <-allArrived:
+				concurrent.Store(true)
+			case <-time.After(time.Second):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"symbol":"` + symbol + `"}`))
+	})
+	r := pricesTestRouter(t)
+
+	w := get(r, "/api/prices/bulk?symbols=ETH,BTC,ETH")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("status %d, X-Cache %q, want a 200 MISS", w.Code, w.Header().Get("X-Cache"))
+	}
+	if w.Body.String() != `{"BTC":{"symbol":"BTC"},"ETH":{"symbol":"ETH"}}` {
+		t.Errorf("merged body %s", w.Body)
+	}
+	if !concurrent.Load() {
+		t.Error("symbols were not fetched concurrently")
+	}
+	if !cacheHas(cacheKeyFor(bulkPricesEndpoint, "symbols=BTC%2CETH")) {
+		t.Error("merged response not cached")
+	}
+	for _, symbol := range []string{"BTC", "ETH"} {
+		if !cacheHas(cacheKeyFor("prices", "symbol="+symbol)) {
+			t.Errorf("price of %s not cached", symbol)
+		}
+	}
+
+	if w := get(r, "/api/prices/bulk?symbols=BTC,ETH"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("equivalent bulk request: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+	w = get(r, "/api/prices/bulk?symbols=BTC,SOL")
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":{"symbol":"BTC"},"SOL":{"symbol":"SOL"}}` {
+		t.Errorf("status %d, body %s", w.Code, w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want each symbol fetched once", hits)
+	}
+}
+
+func TestBulkPricesFailsWithAnySymbol(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") == "DOGE" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	r := pricesTestRouter(t)
+
+	w := get(r, "/api/prices/bulk?symbols=BTC,DOGE")
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status %d, want 502", w.Code)
+	}
+	if cacheHas(cacheKeyFor(bulkPricesEndpoint, "symbols=BTC%2CDOGE")) {
+		t.Error("failed bulk response was cached")
+	}
+}
+
+func TestBulkPricesRejectsInvalidSymbols(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{}`))
+	setForTest(t, &maxBulkSymbols, 2)
+	r := pricesTestRouter(t)
+
+	for _, target := range []string{
+		"/api/prices/bulk?symbols=BTC,ETH,SOL",
+		"/api/prices/bulk?symbols=",
+		"/api/prices/bulk",
+		"/api/prices/bulk?symbols=BTC,not-a-symbol!",
+	} {
+		w := get(r, target)
+		if w.Code != http.StatusBadRequest || errorCode(t, w) != "invalid_symbols" {
+			t.Errorf("%s: status %d, code %q, want 400 invalid_symbols", target, w.Code, errorCode(t, w))
+		}
+	}
+	if w := get(r, "/api/prices/bulk?symbols=BTC,ETH,BTC"); w.Code != http.StatusOK {
+		t.Errorf("duplicate symbols within the cap: status %d, want 200", w.Code)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want rejected requests never fetched", hits)
+	}
+}
+
+func TestBulkPricesBackendMode(t *testing.T) {
+	var uri atomic.Value
+	ok := jsonBackend(`{"BTC":{},"ETH":{}}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		uri.Store(r.URL.RequestURI())
+		ok(w, r)
+	})
+	setForTest(t, &bulkPricesMode, "backend")
+	if fetch, ok := endpointFetchers[bulkPricesEndpoint]; ok {
+		delete(endpointFetchers, bulkPricesEndpoint)
+		t.Cleanup(func() { endpointFetchers[bulkPricesEndpoint] = fetch })
+	}
+	r := pricesTestRouter(t)
+
+	w := get(r, "/api/prices/bulk?symbols=ETH,BTC")
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":{},"ETH":{}}` {
+		t.Fatalf("status %d, body %s", w.Code, w.Body)
+	}
+	if uri.Load() != "/api/prices/bulk?symbols=BTC%2CETH" {
+		t.Errorf("backend URI %v, want the sorted symbols forwarded to the backend's bulk endpoint", uri.Load())
+	}
+	get(r, "/api/prices/bulk?symbols=BTC,ETH")
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want the combined response cached", hits)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// redisBypass is set while Redis is unreachable and the cache is skipped
+	redisBypass atomic.Bool
+
+	// redisProbeInterval is how often Redis is re-probed while bypassed
+	redisProbeInterval = getEnvDuration("REDIS_PROBE_INTERVAL", 10*time.Second)
+
+	// redisWritesPausedUntil is the UnixNano time until which cache writes
+	// are skipped after Redis ran out of memory
+	redisWritesPausedUntil atomic.Int64
+
+	// redisOOMCooldown is how long writes are paused after an OOM error
+	redisOOMCooldown = getEnvDuration("REDIS_OOM_COOLDOWN", 30*time.Second)
+)
+
+// redisBypassed reports whether the cache is currently being bypassed
+func redisBypassed() bool {
+	return redisBypass.Load()
+}
+
+// redisWritesPaused reports whether cache writes are paused while Redis is
+// out of memory. Reads continue as normal.
+func redisWritesPaused() bool {
+	return time.Now().UnixNano() < redisWritesPausedUntil.Load()
+}
+
+// checkRedisError switches to cache bypass mode if err indicates that Redis
+// is unreachable. A background probe exits bypass once Redis responds again.
+// If Redis is out of memory, writes are paused for redisOOMCooldown instead.
+func checkRedisError(err error) {
+	if isRedisOOMError(err) {
+		pauseRedisWrites(err)
+		return
+	}
+	if !isRedisConnError(err) {
+		return
+	}
+	if !redisBypass.CompareAndSwap(false, true) {
+		return
+	}
+
+	slog.Error("Redis unavailable, bypassing cache", "error", err)
+	go probeRedis()
+}
+
+// probeRedis pings Redis until it responds, then leaves bypass mode
+func probeRedis() {
+	ticker := time.NewTicker(redisProbeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		probeCtx, cancel := context.WithTimeout(context.Background(), redisProbeInterval)
+		err := cacheStore.Ping(probeCtx)
+		cancel()
+		if errors.Is(err, redis.ErrClosed) {
+			return
+		}
+		if err == nil {
+			redisBypass.Store(false)
+			slog.Info("Redis available again, cache re-enabled")
+			return
+		}
+	}
+}
+
+// pauseRedisWrites pauses cache writes for redisOOMCooldown, logging only
+// when a new pause starts rather than on every rejected write
+func pauseRedisWrites(err error) {
+	now := time.Now()
+	previous := redisWritesPausedUntil.Swap(now.Add(redisOOMCooldown).UnixNano())
+	if now.UnixNano() >= previous {
+		slog.Error("Redis out of memory, pausing cache writes", "cooldown", redisOOMCooldown.String(), "error", err)
+	}
+}
+
+// isRedisOOMError reports whether err is Redis rejecting a write because
+// maxmemory was reached
+func isRedisOOMError(err error) bool {
+	var redisErr redis.Error
+	return errors.As(err, &redisErr) && strings.HasPrefix(redisErr.Error(), "OOM ")
+}
+
+// isRedisConnError reports whether err is a Redis connectivity failure as
+// opposed to a missing key or a cancelled request
+func isRedisConnError(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
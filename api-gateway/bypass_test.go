@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// errCacheDown is the connection error returned by flakyCache while down
+var errCacheDown = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// flakyCache wraps a Cache, failing every call with a connection error
+// while down is set
+type flakyCache struct {
+	Cache
+	down atomic.Bool
+}
+
+// newFlakyCache returns a flakyCache around an empty memory cache and makes
+// it the gateway's cache for the rest of the test
+func newFlakyCache(t *testing.T) *flakyCache {
+	t.Helper()
+	cache := &flakyCache{Cache: newMemoryCache(0)}
+	setForTest[Cache](t, &cacheStore, cache)
+	t.Cleanup(func() {
+		cache.down.Store(false)
+		redisBypass.Store(false)
+	})
+	return cache
+}
+
+func (c *flakyCache) Get(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	if c.down.Load() {
+		return nil, 0, errCacheDown
+	}
+	return c.Cache.Get(ctx, key)
+}
+
+func (c *flakyCache) GetMany(ctx context.Context, keys []string) ([][]byte, []time.Duration, error) {
+	if c.down.Load() {
+		return nil, nil, errCacheDown
+	}
+	return c.Cache.GetMany(ctx, keys)
+}
+
+func (c *flakyCache) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	if c.down.Load() {
+		return errCacheDown
+	}
+	return c.Cache.Set(ctx, key, value, expiry)
+}
+
+func (c *flakyCache) Ping(ctx context.Context) error {
+	if c.down.Load() {
+		return errCacheDown
+	}
+	return c.Cache.Ping(ctx)
+}
+
+func TestCacheBypassWhileRedisIsDown(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	cache := newFlakyCache(t)
+	setForTest(t, &redisProbeInterval, 10*time.Millisecond)
+	r := cachedTestRouter("bypass", time.Minute, time.Minute)
+
+	cache.down.Store(true)
+	for i := 0; i < 2; i++ {
+		w := get(r, "/api/bypass")
+		if w.Code != http.StatusOK || w.Body.String() != `{"ok":true}` {
+			t.Fatalf("request %d while down: status %d, body %s", i, w.Code, w.Body)
+		}
+		if i > 0 && w.Header().Get("X-Cache") != "BYPASS" {
+			t.Errorf("X-Cache %q while bypassed, want BYPASS", w.Header().Get("X-Cache"))
+		}
+	}
+	if !redisBypassed() {
+		t.Fatal("cache errors didn't start bypass mode")
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times while bypassed, want 2", hits)
+	}
+
+	// The probe leaves bypass mode once the cache answers again
+	cache.down.Store(false)
+	waitFor(t, func() bool { return !redisBypassed() })
+	if w := get(r, "/api/bypass"); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("X-Cache %q after recovery, want MISS", w.Header().Get("X-Cache"))
+	}
+	if w := get(r, "/api/bypass"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache %q after recovery, want HIT", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestIsRedisConnError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errCacheDown, true},
+		{nil, false},
+		{errCacheMiss, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+	} {
+		if got := isRedisConnError(tc.err); got != tc.want {
+			t.Errorf("isRedisConnError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cache stores the encoded cache entries. It is implemented by Redis and,
+// for local development and tests, by an in-process LRU.
+type Cache interface {
+	// Get returns the value of key and its remaining TTL, which is negative
+	// if the key does not expire, or errCacheMiss if key is not set
+	Get(ctx context.Context, key string) ([]byte, time.Duration, error)
+	// GetMany loads several keys like Get in one round trip, returning nil
+	// values for keys that are not set
+	GetMany(ctx context.Context, keys []string) ([][]byte, []time.Duration, error)
+	// Set stores value under key, expiring after expiry unless it is zero
+	Set(ctx context.Context, key string, value []byte, expiry time.Duration) error
+	// Del deletes keys, returning the number that existed
+	Del(ctx context.Context, keys ...string) (int64, error)
+	// Scan calls fn with batches of keys matching the glob pattern. fn
+	// returns errStopScan to stop early.
+	Scan(ctx context.Context, pattern string, fn func(keys []string) error) error
+	// ScanPage returns about count keys matching the glob pattern from
+	// cursor, which is "" for the first page, and the cursor of the next
+	// page, which is "" after the last one
+	ScanPage(ctx context.Context, pattern, cursor string, count int64) ([]string, string, error)
+	// Ping checks that the cache is reachable
+	Ping(ctx context.Context) error
+	// Close releases the cache's resources
+	Close() error
+}
+
+var (
+	// cacheStore is the Cache selected by CACHE_BACKEND
+	cacheStore Cache
+
+	// errCacheMiss is returned by Cache.Get for keys that are not set
+	errCacheMiss = errors.New("cache miss")
+
+	// errStopScan stops Cache.Scan early without an error
+	errStopScan = errors.New("stop scan")
+)
+
+// newCache creates the Cache for CACHE_BACKEND. redis (the default) uses
+// the REDIS_MODE client; memory keeps up to CACHE_MEMORY_MAX_ENTRIES entries
+// in process so the gateway can run without Redis.
+func newCache(backend string) (Cache, error) {
+	switch strings.ToLower(backend) {
+	case "", "redis":
+		mode := getEnv("REDIS_MODE", "standalone")
+		client, err := newRedisClient(mode)
+		if err != nil {
+			return nil, fmt.Errorf("configuring Redis (mode %s): %w", mode, err)
+		}
+		rdb = client
+		return &redisCache{client: client}, nil
+	case "memory":
+		return newMemoryCache(getEnvInt("CACHE_MEMORY_MAX_ENTRIES", 10000)), nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// testCacheContract checks the behavior every Cache implementation shares.
+// advance moves the cache's clock forward by d.
+func testCacheContract(t *testing.T, cache Cache, advance func(d time.Duration)) {
+	ctx := context.Background()
+
+	if _, _, err := cache.Get(ctx, "p:missing"); !errors.Is(err, errCacheMiss) {
+		t.Errorf("Get of a missing key returned %v, want errCacheMiss", err)
+	}
+
+	if err := cache.Set(ctx, "p:forever", []byte("a"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, ttl, err := cache.Get(ctx, "p:forever"); err != nil || string(value) != "a" || ttl >= 0 {
+		t.Errorf("Get without expiry = %q, %s, %v, want a with a negative TTL", value, ttl, err)
+	}
+	if err := cache.Set(ctx, "p:minute", []byte("b"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if value, ttl, err := cache.Get(ctx, "p:minute"); err != nil || string(value) != "b" || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Get with expiry = %q, %s, %v, want b with at most a minute left", value, ttl, err)
+	}
+
+	values, ttls, err := cache.GetMany(ctx, []string{"p:forever", "p:missing", "p:minute"})
+	if err != nil || len(values) != 3 || string(values[0]) != "a" || values[1] != nil || string(values[2]) != "b" || ttls[2] <= 0 {
+		t.Errorf("GetMany = %q, %v, %v", values, ttls, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		cache.Set(ctx, fmt.Sprintf("p:page:%d", i), []byte("x"), time.Minute)
+	}
+	cache.Set(ctx, "other:page", []byte("x"), time.Minute)
+	var scanned []string
+	err = cache.Scan(ctx, "p:page:*", func(keys []string) error {
+		scanned = append(scanned, keys...)
+		return nil
+	})
+	if sort.Strings(scanned); err != nil || len(scanned) != 5 || scanned[0] != "p:page:0" {
+		t.Errorf("Scan = %q, %v, want the 5 p:page keys", scanned, err)
+	}
+	if err := cache.Scan(ctx, "p:*", func([]string) error { return errStopScan }); err != nil {
+		t.Errorf("Scan stopped early returned %v", err)
+	}
+	var paged []string
+	cursor := ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		if pages > 10 {
+			t.Fatal("ScanPage never returned the last page")
+		}
+		var keys []string
+		if keys, cursor, err = cache.ScanPage(ctx, "p:page:*", cursor, 2); err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, keys...)
+	}
+	if len(paged) != 5 {
+		t.Errorf("ScanPage returned %q across pages, want the 5 p:page keys", paged)
+	}
+
+	if deleted, err := cache.Del(ctx, "p:forever", "p:missing"); err != nil || deleted != 1 {
+		t.Errorf("Del = %d, %v, want 1 existing key deleted", deleted, err)
+	}
+	if _, _, err := cache.Get(ctx, "p:forever"); !errors.Is(err, errCacheMiss) {
+		t.Errorf("Get of a deleted key returned %v, want errCacheMiss", err)
+	}
+
+	cache.Set(ctx, "p:short", []byte("c"), 50*time.Millisecond)
+	advance(100 * time.Millisecond)
+	if _, _, err := cache.Get(ctx, "p:short"); !errors.Is(err, errCacheMiss) {
+		t.Errorf("Get of an expired key returned %v, want errCacheMiss", err)
+	}
+
+	if err := cache.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+}
+
+func TestMemoryCacheContract(t *testing.T) {
+	testCacheContract(t, newMemoryCache(100), time.Sleep)
+}
+
+func TestRedisCacheContract(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	testCacheContract(t, &redisCache{client: client}, server.FastForward)
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	cache := newMemoryCache(2)
+	cache.Set(ctx, "a", []byte("a"), 0)
+	cache.Set(ctx, "b", []byte("b"), 0)
+	cache.Get(ctx, "a")
+	cache.Set(ctx, "c", []byte("c"), 0)
+
+	if _, _, err := cache.Get(ctx, "b"); !errors.Is(err, errCacheMiss) {
+		t.Error("least recently used key not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, _, err := cache.Get(ctx, key); err != nil {
+			t.Errorf("%s evicted: %v", key, err)
+		}
+	}
+}
+
+func TestNewCache(t *testing.T) {
+	cache, err := newCache("MEMORY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.(*memoryCache); !ok {
+		t.Errorf("CACHE_BACKEND=memory built a %T", cache)
+	}
+	if _, err := newCache("memcached"); err == nil {
+		t.Error("unknown CACHE_BACKEND: no error")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backendCacheTTL returns how long a backend response may be cached based
+// on its Cache-Control header, falling back to defaultTTL when the header
+// sets no lifetime. It reports false if the response must not be cached.
+// s-maxage takes precedence over max-age as the gateway is a shared cache.
+func backendCacheTTL(header http.Header, defaultTTL time.Duration) (time.Duration, bool) {
+	maxAge, sMaxAge := -1, -1
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache", "private":
+				return 0, false
+			case "max-age":
+				maxAge = parseDeltaSeconds(arg, maxAge)
+			case "s-maxage":
+				sMaxAge = parseDeltaSeconds(arg, sMaxAge)
+			}
+		}
+	}
+
+	seconds := sMaxAge
+	if seconds < 0 {
+		seconds = maxAge
+	}
+	switch {
+	case seconds < 0:
+		return defaultTTL, true
+	case seconds == 0:
+		return 0, false
+	default:
+		return time.Duration(seconds) * time.Second, true
+	}
+}
+
+// parseDeltaSeconds parses a Cache-Control delta-seconds argument, returning
+// fallback if it is invalid
+func parseDeltaSeconds(arg string, fallback int) int {
+	seconds, err := strconv.Atoi(strings.Trim(arg, `"`))
+	if err != nil || seconds < 0 {
+		return fallback
+	}
+	return seconds
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestBackendCacheTTL(t *testing.T) {
+	for _, tc := range []struct {
+		cacheControl string
+		ttl          time.Duration
+		cacheable    bool
+	}{
+		{"", time.Minute, true},
+		{"public", time.Minute, true},
+		{"max-age=30", 30 * time.Second, true},
+		{"public, max-age=30, s-maxage=10", 10 * time.Second, true},
+		{`max-age="45"`, 45 * time.Second, true},
+		{"max-age=abc", time.Minute, true},
+		{"max-age=0", 0, false},
+		{"no-store", 0, false},
+		{"max-age=30, no-cache", 0, false},
+		{"Private", 0, false},
+	} {
+		header := http.Header{}
+		if tc.cacheControl != "" {
+			header.Set("Cache-Control", tc.cacheControl)
+		}
+		ttl, cacheable := backendCacheTTL(header, time.Minute)
+		if ttl != tc.ttl || cacheable != tc.cacheable {
+			t.Errorf("%q: got %s, %v, want %s, %v", tc.cacheControl, ttl, cacheable, tc.ttl, tc.cacheable)
+		}
+	}
+}
+
+func TestCachedProxyHonorsBackendCacheControl(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if cc := r.URL.Query().Get("cc"); cc != "" {
+			w.Header().Set("Cache-Control", cc)
+		}
+		jsonBackend(`{"ok":true}`)(w, r)
+	})
+	r := cachedTestRouter("cachecontrol", time.Minute, time.Minute)
+
+	for _, tc := range []struct {
+		cacheControl string
+		cached       bool
+		maxRemaining int
+	}{
+		{"", true, 120},
+		{"max-age=30", true, 90},
+		{"no-store", false, 0},
+		{"no-cache", false, 0},
+	} {
+		target := "/api/cachecontrol?cc=" + url.QueryEscape(tc.cacheControl)
+		hits := backend.hits.Load()
+		get(r, target)
+		w := get(r, target)
+		if cached := backend.hits.Load() == hits+1; cached != tc.cached {
+			t.Errorf("%q: cached %v, want %v", tc.cacheControl, cached, tc.cached)
+			continue
+		}
+		if !tc.cached {
+			continue
+		}
+		remaining, _ := strconv.Atoi(w.Header().Get("X-Cache-TTL-Remaining"))
+		if remaining > tc.maxRemaining || remaining < tc.maxRemaining-2 {
+			t.Errorf("%q: X-Cache-TTL-Remaining %d, want about %d", tc.cacheControl, remaining, tc.maxRemaining)
+		}
+	}
+}
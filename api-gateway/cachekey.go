@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// bodyKey returns the cache key suffix of a POST with body, a hash of its
+// content type and body, or "" for GETs, whose body is nil
+func bodyKey(header http.Header, body []byte) string {
+	if body == nil {
+		return ""
+	}
+	return "|post=" + hashHex([]byte(header.Get("Content-Type")), body)
+}
+
+// caseInsensitiveParams are query params whose values are lowercased when
+// building cache keys, from the comma-separated CASE_INSENSITIVE_PARAMS
+var caseInsensitiveParams = parseParamSet(getEnv("CASE_INSENSITIVE_PARAMS", "vs_currency"))
+
+// parseParamSet splits a comma-separated list of query param names
+func parseParamSet(value string) map[string]bool {
+	set := map[string]bool{}
+	for _, p := range strings.Split(value, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+// normalizeQuery canonicalizes a raw query string so semantically identical
+// requests share a cache entry. Params are sorted by name, repeated values
+// keep their order, and only case-insensitive params are lowercased.
+// Unparseable queries are returned unchanged.
+func normalizeQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	for name, vs := range values {
+		if caseInsensitiveParams[name] {
+			for i, v := range vs {
+				vs[i] = strings.ToLower(v)
+			}
+		}
+	}
+	return values.Encode()
+}
+
+// cacheKeyBuilder returns the part of a normalized query that identifies a
+// cached response
+type cacheKeyBuilder func(query string) string
+
+// keyParams creates a cacheKeyBuilder keeping only the named query params,
+// for endpoints where other params such as tracking tags don't change the
+// response
+func keyParams(names ...string) cacheKeyBuilder {
+	return func(query string) string {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return query
+		}
+		kept := url.Values{}
+		for _, name := range names {
+			if vs, ok := values[name]; ok {
+				kept[name] = vs
+			}
+		}
+		return kept.Encode()
+	}
+}
+
+// routeVary returns the request headers whose values select the cached
+// representation of endpoint, from its comma-separated VARY_<ENDPOINT> env
+// var (e.g. VARY_PRICES=Accept,Accept-Language). Routes vary on none by
+// default so their cache is not fragmented.
+func routeVary(endpoint string) []string {
+	var vary []string
+	for _, name := range strings.Split(getEnv(endpointEnvKey("VARY_", endpoint), ""), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			vary = append(vary, http.CanonicalHeaderKey(name))
+		}
+	}
+	return vary
+}
+
+// varyKey returns the cache key suffix for the normalized values of the
+// vary headers in header, or "" if the route does not vary
+func varyKey(header http.Header, vary []string) string {
+	if len(vary) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for _, name := range vary {
+		values.Set(strings.ToLower(name), normalizeHeaderValue(header.Values(name)))
+	}
+	return "|" + values.Encode()
+}
+
+// normalizeHeaderValue canonicalizes a list header such as Accept by
+// lowercasing it and removing optional whitespace
+func normalizeHeaderValue(values []string) string {
+	var parts []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.Join(strings.Fields(strings.ToLower(part)), ""); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// varyRequestHeader returns the vary headers of header to forward to the
+// backend, or nil if the route does not vary
+func varyRequestHeader(header http.Header, vary []string) http.Header {
+	if len(vary) == 0 {
+		return nil
+	}
+	forwarded := http.Header{}
+	for _, name := range vary {
+		for _, v := range header.Values(name) {
+			forwarded.Add(name, v)
+		}
+	}
+	return forwarded
+}
+
+// varyResponseHeader returns the Vary header value for a route
+func varyResponseHeader(vary []string) string {
+	return strings.Join(append([]string{"Accept-Encoding"}, vary...), ", ")
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"symbol=BTC&vs_currency=usd", "symbol=BTC&vs_currency=usd"},
+		{"vs_currency=USD&symbol=BTC", "symbol=BTC&vs_currency=usd"},
+		{"ids=b&ids=a", "ids=b&ids=a"},
+		{"symbol=btc", "symbol=btc"},
+		{"", ""},
+		{"bad=%zz", "bad=%zz"},
+	} {
+		if got := normalizeQuery(tc.query); got != tc.want {
+			t.Errorf("normalizeQuery(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
+
+func TestReorderedQueriesShareCacheEntry(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	r := cachedTestRouter("cachekey-normalize", time.Minute, time.Minute)
+
+	for _, tc := range []struct {
+		query, cache string
+	}{
+		{"symbol=BTC&vs_currency=usd", "MISS"},
+		{"vs_currency=usd&symbol=BTC", "HIT"},
+		{"vs_currency=USD&symbol=BTC", "HIT"},
+		{"symbol=btc&vs_currency=usd", "MISS"},
+	} {
+		if w := get(r, "/api/cachekey-normalize?"+tc.query); w.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("%s: X-Cache %q, want %s", tc.query, w.Header().Get("X-Cache"), tc.cache)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+}
+
+func TestVaryHeadersSelectCacheEntries(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"accept":%q,"language":%q}`, r.Header.Get("Accept"), r.Header.Get("Accept-Language"))
+	})
+	t.Setenv("VARY_VARY_ACCEPT", "accept, Accept-Language")
+	r := gin.New()
+	r.GET("/api/vary-accept", cachedProxy("vary-accept", time.Minute, time.Minute, 0, routeVary("vary-accept")...))
+
+	for _, tc := range []struct {
+		accept, language, cache, body string
+	}{
+		{"application/json", "en", "MISS", `{"accept":"application/json","language":"en"}`},
+		{"Application/JSON", "en", "HIT", `{"accept":"application/json","language":"en"}`},
+		{"text/csv", "en", "MISS", `{"accept":"text/csv","language":"en"}`},
+		{"application/json", "de", "MISS", `{"accept":"application/json","language":"de"}`},
+	} {
+		w := serve(r, http.MethodGet, "/api/vary-accept", http.Header{"Accept": {tc.accept}, "Accept-Language": {tc.language}}, "")
+		if w.Header().Get("X-Cache") != tc.cache || w.Body.String() != tc.body {
+			t.Errorf("%s, %s: X-Cache %q, body %s, want %s %s", tc.accept, tc.language, w.Header().Get("X-Cache"), w.Body, tc.cache, tc.body)
+		}
+		if vary := w.Header().Get("Vary"); vary != "Accept-Encoding, Accept, Accept-Language" {
+			t.Errorf("Vary %q", vary)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+}
+
+func TestRoutesWithoutVaryShareOneEntry(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	r := cachedTestRouter("vary-none", time.Minute, time.Minute)
+
+	serve(r, http.MethodGet, "/api/vary-none", http.Header{"Accept": {"application/json"}}, "")
+	w := serve(r, http.MethodGet, "/api/vary-none", http.Header{"Accept": {"text/csv"}}, "")
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("X-Cache %q, Vary %q, want HIT varying only on Accept-Encoding", w.Header().Get("X-Cache"), w.Header().Get("Vary"))
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1", hits)
+	}
+}
+
+func TestKeyBuilderIgnoresIrrelevantParams(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	routeKeyBuilders["keyed-news"] = keyParams("category", "lang")
+	t.Cleanup(func() { delete(routeKeyBuilders, "keyed-news") })
+	news := cachedTestRouter("keyed-news", time.Minute, time.Minute)
+	prices := cachedTestRouter("keyed-prices", time.Minute, time.Minute)
+
+	for _, tc := range []struct {
+		r             http.Handler
+		target, cache string
+	}{
+		{news, "/api/keyed-news?category=bitcoin&lang=en&utm_source=twitter", "MISS"},
+		{news, "/api/keyed-news?lang=en&ref=home&category=bitcoin", "HIT"},
+		{news, "/api/keyed-news?category=bitcoin&lang=en", "HIT"},
+		{news, "/api/keyed-news?category=ethereum&lang=en&utm_source=twitter", "MISS"},
+		{news, "/api/keyed-news?category=bitcoin&lang=de", "MISS"},
+		{prices, "/api/keyed-prices?symbol=BTC&utm_source=twitter", "MISS"},
+		{prices, "/api/keyed-prices?symbol=BTC&utm_source=reddit", "MISS"},
+		{prices, "/api/keyed-prices?utm_source=reddit&symbol=BTC", "HIT"},
+	} {
+		if w := get(tc.r, tc.target); w.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("%s: X-Cache %q, want %s", tc.target, w.Header().Get("X-Cache"), tc.cache)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 5 {
+		t.Errorf("backend hit %d times, want 5", hits)
+	}
+}
+
+func TestKeyParams(t *testing.T) {
+	build := keyParams("category", "lang")
+	for _, tc := range []struct {
+		query, want string
+	}{
+		{"category=bitcoin&lang=en&utm_source=x", "category=bitcoin&lang=en"},
+		{"category=a&category=b&ref=home", "category=a&category=b"},
+		{"utm_source=x", ""},
+		{"", ""},
+	} {
+		if got := build(tc.query); got != tc.want {
+			t.Errorf("keyParams(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+	if got := routeKeyBuilders["news"]("category=bitcoin&utm_source=x"); got != "category=bitcoin" {
+		t.Errorf("news key %q, want only its category", got)
+	}
+}
+
+func TestPostBodiesHaveDistinctCacheEntries(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"method":%q,"body":%q}`, r.Method, body)
+	})
+	routes, err := validateRoutes([]routeConfig{
+		{Endpoint: "post-query", TTL: "1m", Cache: true, Methods: []string{http.MethodPost}},
+		{Endpoint: "get-query", TTL: "1m", Cache: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	registerRoutes(r, routes)
+	jsonHeader := http.Header{"Content-Type": {"application/json"}}
+	textHeader := http.Header{"Content-Type": {"text/plain"}}
+
+	for i, tc := range []struct {
+		method string
+		header http.Header
+		body   string
+		cache  string
+		want   string
+	}{
+		{http.MethodGet, nil, "", "MISS", `{"method":"GET","body":""}`},
+		{http.MethodPost, jsonHeader, `{"a":1}`, "MISS", `{"method":"POST","body":"{\"a\":1}"}`},
+		{http.MethodPost, jsonHeader, `{"a":1}`, "HIT", `{"method":"POST","body":"{\"a\":1}"}`},
+		{http.MethodPost, jsonHeader, `{"a":2}`, "MISS", `{"method":"POST","body":"{\"a\":2}"}`},
+		{http.MethodPost, textHeader, `{"a":1}`, "MISS", `{"method":"POST","body":"{\"a\":1}"}`},
+		{http.MethodGet, nil, "", "HIT", `{"method":"GET","body":""}`},
+	} {
+		w := serve(r, tc.method, "/api/post-query?q=1", tc.header, tc.body)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tc.cache || w.Body.String() != tc.want {
+			t.Errorf("request %d %s %s: status %d, X-Cache %q, body %s, want %s %s", i, tc.method, tc.body, w.Code, w.Header().Get("X-Cache"), w.Body, tc.cache, tc.want)
+		}
+	}
+	cacheKey := cacheKeyFor("post-query", "q=1")
+	for _, key := range []string{cacheKey, cacheKey + bodyKey(jsonHeader, []byte(`{"a":1}`)), cacheKey + bodyKey(jsonHeader, []byte(`{"a":2}`))} {
+		if !cacheHas(key) {
+			t.Errorf("%s not cached", key)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want once per method, body and content type", hits)
+	}
+
+	if w := serve(r, http.MethodPost, "/api/get-query", jsonHeader, `{}`); w.Code == http.StatusOK || backend.hits.Load() != 4 {
+		t.Errorf("POST to a GET-only cached route: status %d, want it not proxied", w.Code)
+	}
+}
+
+func TestBodyKey(t *testing.T) {
+	header := http.Header{"Content-Type": {"application/json"}}
+	if key := bodyKey(header, nil); key != "" {
+		t.Errorf("GET body key %q, want none", key)
+	}
+	empty, a, b := bodyKey(header, []byte{}), bodyKey(header, []byte("a")), bodyKey(header, []byte("b"))
+	if empty == "" || empty == a || a == b || !strings.HasPrefix(a, "|post=") {
+		t.Errorf("body keys %q, %q, %q, want distinct POST keys", empty, a, b)
+	}
+	if a != bodyKey(header.Clone(), []byte("a")) {
+		t.Error("body key is not deterministic")
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// cacheCompression is the algorithm cached bodies are compressed with,
+	// gzip, zstd or none, from the CACHE_COMPRESSION env var
+	cacheCompression = parseCacheCompression(getEnv("CACHE_COMPRESSION", "gzip"))
+
+	// cacheCompressMinBytes is the smallest cached body worth compressing,
+	// from the CACHE_COMPRESS_MIN_BYTES env var
+	cacheCompressMinBytes = getEnvInt("CACHE_COMPRESS_MIN_BYTES", 256)
+
+	// zstdEncoder and zstdDecoder are shared, as EncodeAll and DecodeAll are
+	// safe for concurrent use
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// parseCacheCompression validates the CACHE_COMPRESSION value, falling back
+// to gzip if it is unknown
+func parseCacheCompression(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "gzip", "zstd", "none":
+		return value
+	}
+	slog.Warn("Unknown CACHE_COMPRESSION, using gzip", "compression", value)
+	return "gzip"
+}
+
+// compressCacheBody compresses a body about to be cached with
+// cacheCompression, returning the encoding used, or "" if the body is too
+// small to bother or compression is off
+func compressCacheBody(body []byte) ([]byte, string, error) {
+	if cacheCompression == "none" || len(body) < max(cacheCompressMinBytes, 1) {
+		return body, "", nil
+	}
+	if cacheCompression == "zstd" {
+		return zstdEncoder.EncodeAll(body, nil), "zstd", nil
+	}
+	compressed, err := gzipBytes(body)
+	if err != nil {
+		return nil, "", err
+	}
+	return compressed, "gzip", nil
+}
+
+// decodeBody decompresses data compressed with encoding, returning it
+// unchanged if encoding is empty
+func decodeBody(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case "gzip":
+		return gunzipBytes(data)
+	case "zstd":
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// gzipBytes compresses data with gzip
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipBytes decompresses gzip data
+func gunzipBytes(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// acceptsGzip reports whether the client advertised gzip support
+func acceptsGzip(c *gin.Context) bool {
+	return acceptsEncoding(c, "gzip")
+}
+
+// acceptsEncoding reports whether the client advertised support for a
+// content encoding
+func acceptsEncoding(c *gin.Context, encoding string) bool {
+	for _, enc := range strings.Split(c.GetHeader("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(strings.SplitN(enc, ";", 2)[0])
+		if enc == encoding || enc == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCacheEntry writes a cached response, sending the stored compressed
+// body as-is to clients that accept its encoding and decompressing it for
+// the rest. Age and X-Cache-TTL-Remaining report how fresh the entry is.
+func serveCacheEntry(c *gin.Context, entry *cacheEntry) {
+	contentType := entry.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	if !entry.CachedAt.IsZero() {
+		age := int(time.Since(entry.CachedAt).Seconds())
+		c.Header("Age", strconv.Itoa(max(age, 0)))
+	}
+	if entry.ttl > 0 {
+		c.Header("X-Cache-TTL-Remaining", strconv.Itoa(int(entry.ttl.Seconds())))
+	}
+	status := entry.statusCode()
+	if status == http.StatusOK && notModified(c, entry.ETag, entry.lastModified()) {
+		return
+	}
+
+	if encoding := entry.encoding(); encoding != "" && acceptsEncoding(c, encoding) && status < http.StatusBadRequest {
+		c.Header("Content-Encoding", encoding)
+		c.Data(status, contentType, entry.Body)
+		return
+	}
+
+	body, err := entry.plainBody()
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", "Error decompressing cached response")
+		return
+	}
+	if status >= http.StatusBadRequest {
+		respondBackendStatus(c, status, body)
+		return
+	}
+	c.Data(status, contentType, body)
+}
+
+// lastModified returns when the entry's body last changed, falling back to
+// when it was cached for entries stored without a Last-Modified time
+func (e *cacheEntry) lastModified() time.Time {
+	if !e.LastModified.IsZero() {
+		return e.LastModified
+	}
+	return e.CachedAt
+}
+
+// statusCode returns the status to serve the entry with
+func (e *cacheEntry) statusCode() int {
+	if e.Status != 0 {
+		return e.Status
+	}
+	return http.StatusOK
+}
+
+// encoding returns the encoding of the entry's body, or "" if it is not
+// compressed. Entries from before Encoding was stored only set Gzipped.
+func (e *cacheEntry) encoding() string {
+	if e.Encoding == "" && e.Gzipped {
+		return "gzip"
+	}
+	return e.Encoding
+}
+
+// plainBody returns the entry's body, decompressing it if needed
+func (e *cacheEntry) plainBody() ([]byte, error) {
+	return decodeBody(e.Body, e.encoding())
+}
+
+// gzipMinSize is the smallest response body compressed by compressResponses
+var gzipMinSize = getEnvInt("GZIP_MIN_SIZE", 1024)
+
+// compressResponses creates a middleware that gzips response bodies of at
+// least gzipMinSize bytes for clients that accept gzip. Responses that
+// already have a Content-Encoding, such as compressed cache entries, are sent
+// unchanged, as are websocket upgrades.
+func compressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if gzipMinSize <= 0 || !acceptsGzip(c) || c.IsWebsocket() {
+			c.Next()
+			return
+		}
+
+		w := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+			w.finish()
+		}()
+		c.Next()
+	}
+}
+
+// gzipResponseWriter buffers a response body so compressResponses can decide
+// whether to compress it once the handler has finished
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	streaming bool // flushed by the handler, so sent uncompressed
+}
+
+// Write buffers data until finish
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+// WriteString buffers s until finish
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the response so far uncompressed and stops buffering, so
+// streamed responses are not held back
+func (w *gzipResponseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		if w.body.Len() > 0 {
+			w.ResponseWriter.Write(w.body.Bytes())
+			w.body.Reset()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the buffered body, compressed if it is large enough and not
+// already encoded
+func (w *gzipResponseWriter) finish() {
+	if w.streaming || w.body.Len() == 0 {
+		return
+	}
+	body := w.body.Bytes()
+	header := w.Header()
+	if !strings.Contains(strings.Join(header.Values("Vary"), ","), "Accept-Encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if len(body) >= gzipMinSize && header.Get("Content-Encoding") == "" {
+		if compressed, err := gzipBytes(body); err == nil {
+			body = compressed
+			header.Set("Content-Encoding", "gzip")
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	w.ResponseWriter.Write(body)
+}
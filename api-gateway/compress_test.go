@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCompressedCacheServesGzipAndPlainClients(t *testing.T) {
+	setForTest(t, &cacheCompression, "gzip")
+	setForTest(t, &cacheCompressMinBytes, 1)
+	body := `{"news":"` + strings.Repeat("bitcoin ", 512) + `"}`
+	newTestBackend(t, jsonBackend(body))
+	r := cachedTestRouter("compress-gzip", time.Minute, time.Minute)
+	gzipHeader := http.Header{"Accept-Encoding": {"gzip"}}
+
+	get(r, "/api/compress-gzip")
+
+	for _, tc := range []struct {
+		name     string
+		header   http.Header
+		encoding string
+	}{
+		{"plain client", nil, ""},
+		{"gzip client", gzipHeader, "gzip"},
+	} {
+		w := serve(r, http.MethodGet, "/api/compress-gzip", tc.header, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tc.name, w.Code)
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != tc.encoding {
+			t.Fatalf("%s: Content-Encoding %q, want %q", tc.name, encoding, tc.encoding)
+		}
+		got, err := decodeBody(w.Body.Bytes(), tc.encoding)
+		if err != nil {
+			t.Fatalf("%s: decoding body: %v", tc.name, err)
+		}
+		if string(got) != body {
+			t.Errorf("%s: body %.40q..., want the backend body", tc.name, got)
+		}
+	}
+}
+
+func TestCompressCacheBodyRoundTrip(t *testing.T) {
+	setForTest(t, &cacheCompressMinBytes, 16)
+	small := []byte(`{}`)
+	large := bytes.Repeat([]byte(`{"price":1}`), 100)
+
+	for _, compression := range []string{"gzip", "zstd", "none"} {
+		setForTest(t, &cacheCompression, compression)
+		for _, body := range [][]byte{small, large} {
+			data, encoding, err := compressCacheBody(body)
+			if err != nil {
+				t.Fatalf("%s: %v", compression, err)
+			}
+			if len(body) < 16 || compression == "none" {
+				if encoding != "" {
+					t.Errorf("%s: %d byte body encoded as %q", compression, len(body), encoding)
+				}
+			} else if encoding != compression || len(data) >= len(body) {
+				t.Errorf("%s: encoded as %q to %d of %d bytes", compression, encoding, len(data), len(body))
+			}
+			decoded, err := decodeBody(data, encoding)
+			if err != nil || !bytes.Equal(decoded, body) {
+				t.Errorf("%s: round trip gave %q, %v", compression, decoded, err)
+			}
+		}
+	}
+}
+
+func TestCachedEntriesRecordCompressionAlgorithm(t *testing.T) {
+	large := `{"news":"` + strings.Repeat("ethereum ", 256) + `"}`
+	small := `{"price":1}`
+
+	for _, tc := range []struct {
+		compression string
+		body        string
+		encoding    string
+	}{
+		{"gzip", large, "gzip"},
+		{"zstd", large, "zstd"},
+		{"none", large, ""},
+		{"gzip", small, ""},
+		{"zstd", small, ""},
+	} {
+		setForTest(t, &cacheCompression, tc.compression)
+		setForTest(t, &cacheCompressMinBytes, 64)
+		endpoint := "compress-" + tc.compression + "-" + strconv.Itoa(len(tc.body))
+		newTestBackend(t, jsonBackend(tc.body))
+		r := cachedTestRouter(endpoint, time.Minute, time.Minute)
+
+		get(r, "/api/"+endpoint)
+
+		data, ttl, err := cacheStore.Get(context.Background(), cacheKeyFor(endpoint, ""))
+		if err != nil {
+			t.Fatalf("%s: reading cache entry: %v", endpoint, err)
+		}
+		entry, ok := decodeCacheEntry(cacheKeyFor(endpoint, ""), data, ttl)
+		if !ok {
+			t.Fatalf("%s: cache entry did not decode", endpoint)
+		}
+		if entry.Encoding != tc.encoding || entry.Gzipped != (tc.encoding == "gzip") {
+			t.Errorf("%s: stored with encoding %q (gzipped %v), want %q", endpoint, entry.Encoding, entry.Gzipped, tc.encoding)
+		}
+		if tc.encoding == "" && string(entry.Body) != tc.body {
+			t.Errorf("%s: body below the threshold stored as %.40q, want it uncompressed", endpoint, entry.Body)
+		}
+		if body, err := decodeBody(entry.Body, entry.Encoding); err != nil || string(body) != tc.body {
+			t.Errorf("%s: stored body decodes to %.40q, %v", endpoint, body, err)
+		}
+
+		w := get(r, "/api/"+endpoint)
+		if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Content-Encoding") != "" || w.Body.String() != tc.body {
+			t.Errorf("%s: plain client got %s %q %.40q, want the decompressed HIT", endpoint,
+				w.Header().Get("X-Cache"), w.Header().Get("Content-Encoding"), w.Body)
+		}
+		if tc.encoding != "" {
+			w = serve(r, http.MethodGet, "/api/"+endpoint, http.Header{"Accept-Encoding": {tc.encoding}}, "")
+			if w.Header().Get("Content-Encoding") != tc.encoding {
+				t.Errorf("%s: %s client got Content-Encoding %q", endpoint, tc.encoding, w.Header().Get("Content-Encoding"))
+			}
+			if body, err := decodeBody(w.Body.Bytes(), tc.encoding); err != nil || string(body) != tc.body {
+				t.Errorf("%s: %s client body decodes to %.40q, %v", endpoint, tc.encoding, body, err)
+			}
+		}
+	}
+}
+
+func TestParseCacheCompression(t *testing.T) {
+	for value, want := range map[string]string{
+		"gzip":   "gzip",
+		" ZSTD ": "zstd",
+		"None":   "none",
+		"brotli": "gzip",
+		"":       "gzip",
+	} {
+		if got := parseCacheCompression(value); got != want {
+			t.Errorf("parseCacheCompression(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+// compressTestRouter returns a router compressing the responses of the
+// cached /api/<endpoint> route
+func compressTestRouter(endpoint string) *gin.Engine {
+	r := gin.New()
+	r.Use(compressResponses())
+	r.GET("/api/"+endpoint, cachedProxy(endpoint, time.Minute, time.Minute, 0))
+	return r
+}
+
+func TestCompressResponses(t *testing.T) {
+	setForTest(t, &gzipMinSize, 1024)
+	large := `{"news":"` + strings.Repeat("ethereum ", 256) + `"}`
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body := large
+		if r.URL.Query().Get("size") == "small" {
+			body = `{"ok":true}`
+		}
+		jsonBackend(body)(w, r)
+	})
+	r := compressTestRouter("compress-out")
+	gzipHeader := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+
+	for _, tc := range []struct {
+		name     string
+		target   string
+		header   http.Header
+		cache    string
+		encoding string
+		body     string
+	}{
+		{"gzip client miss", "/api/compress-out", gzipHeader, "MISS", "gzip", large},
+		{"gzip client hit", "/api/compress-out", gzipHeader, "HIT", "gzip", large},
+		{"plain client", "/api/compress-out", nil, "HIT", "", large},
+		{"small body", "/api/compress-out?size=small", gzipHeader, "MISS", "", `{"ok":true}`},
+	} {
+		w := serve(r, http.MethodGet, tc.target, tc.header, "")
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("%s: status %d, X-Cache %q, want 200 %s", tc.name, w.Code, w.Header().Get("X-Cache"), tc.cache)
+		}
+		if encoding := w.Header().Get("Content-Encoding"); encoding != tc.encoding {
+			t.Fatalf("%s: Content-Encoding %q, want %q", tc.name, encoding, tc.encoding)
+		}
+		if length := w.Header().Get("Content-Length"); length != "" && length != strconv.Itoa(w.Body.Len()) {
+			t.Errorf("%s: Content-Length %s, want the %d bytes sent", tc.name, length, w.Body.Len())
+		}
+		if !strings.Contains(w.Header().Get("Vary"), "Accept-Encoding") {
+			t.Errorf("%s: Vary %q, want Accept-Encoding", tc.name, w.Header().Get("Vary"))
+		}
+		body, err := decodeBody(w.Body.Bytes(), tc.encoding)
+		if err != nil {
+			t.Fatalf("%s: decoding body: %v", tc.name, err)
+		}
+		if string(body) != tc.body {
+			t.Errorf("%s: body %.40q..., want the backend body", tc.name, body)
+		}
+	}
+}
+
+func TestCompressResponsesDoesNotRecompressCachedGzip(t *testing.T) {
+	setForTest(t, &gzipMinSize, 1)
+	setForTest(t, &cacheCompression, "gzip")
+	setForTest(t, &cacheCompressMinBytes, 1)
+	body := `{"news":"` + strings.Repeat("solana ", 256) + `"}`
+	newTestBackend(t, jsonBackend(body))
+	r := compressTestRouter("compress-twice")
+	gzipHeader := http.Header{"Accept-Encoding": {"gzip"}}
+
+	get(r, "/api/compress-twice")
+	w := serve(r, http.MethodGet, "/api/compress-twice", gzipHeader, "")
+	if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("X-Cache %q, Content-Encoding %q, want a gzipped hit", w.Header().Get("X-Cache"), w.Header().Get("Content-Encoding"))
+	}
+	got, err := decodeBody(w.Body.Bytes(), "gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != body {
+		t.Errorf("body after one gunzip %.40q..., want the backend body", got)
+	}
+}
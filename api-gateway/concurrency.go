@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errEndpointSaturated is returned when an endpoint already has its maximum
+// number of backend fetches in flight
+var errEndpointSaturated = errors.New("backend endpoint saturated")
+
+var (
+	// concurrencyWait is how long a fetch queues for a free slot before
+	// failing, from the CONCURRENCY_WAIT env var. Zero fails immediately.
+	concurrencyWait = getEnvDuration("CONCURRENCY_WAIT", 1*time.Second)
+
+	endpointSlotsMu sync.Mutex
+	endpointSlots   = map[string]chan struct{}{}
+)
+
+// endpointSemaphore returns the semaphore limiting in-flight backend
+// fetches for endpoint, sized by its CONCURRENCY_<ENDPOINT> env var (e.g.
+// CONCURRENCY_PREDICTIONS=4), or nil if the endpoint is unlimited
+func endpointSemaphore(endpoint string) chan struct{} {
+	endpointSlotsMu.Lock()
+	defer endpointSlotsMu.Unlock()
+
+	sem, ok := endpointSlots[endpoint]
+	if !ok {
+		if limit := getEnvInt(endpointEnvKey("CONCURRENCY_", endpoint), 0); limit > 0 {
+			sem = make(chan struct{}, limit)
+		}
+		endpointSlots[endpoint] = sem
+	}
+	return sem
+}
+
+// acquireEndpointSlot waits up to concurrencyWait for a free backend fetch
+// slot for endpoint, returning a function releasing it. This protects
+// expensive backend endpoints independently of client rate limiting.
+func acquireEndpointSlot(ctx context.Context, endpoint string) (func(), error) {
+	sem := endpointSemaphore(endpoint)
+	if sem == nil {
+		return func() {}, nil
+	}
+	release := func() { <-sem }
+
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if concurrencyWait <= 0 {
+		sampledLog.Warn("Backend endpoint saturated", "endpoint", endpoint, "limit", cap(sem))
+		return nil, errEndpointSaturated
+	}
+
+	timer := time.NewTimer(concurrencyWait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		sampledLog.Warn("Backend endpoint saturated", "endpoint", endpoint, "limit", cap(sem))
+		return nil, errEndpointSaturated
+	}
+}
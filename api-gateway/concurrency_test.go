@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingBackend returns a handler tracking the requests in flight, each
+// held until release is closed
+func blockingBackend(inFlight, maxInFlight *atomic.Int64, release <-chan struct{}) http.HandlerFunc {
+	ok := jsonBackend(`{"ok":true}`)
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			peak := maxInFlight.Load()
+			if n <= peak || maxInFlight.CompareAndSwap(peak, n) {
+				break
+			}
+		}
+		<-release
+		ok(w, r)
+	}
+}
+
+func TestConcurrencyLimitQueuesFetches(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	release := make(chan struct{})
+	newTestBackend(t, blockingBackend(&inFlight, &maxInFlight, release))
+	t.Setenv("CONCURRENCY_CONC_QUEUE", "2")
+	setForTest(t, &concurrencyWait, 5*time.Second)
+	r := directTestRouter(t, "conc-queue", http.MethodGet)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = get(r, "/api/conc-queue").Code
+		}(i)
+	}
+	waitFor(t, func() bool { return inFlight.Load() == 2 })
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if peak := maxInFlight.Load(); peak != 2 {
+		t.Errorf("%d backend fetches in flight at once, want the limit of 2", peak)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: status %d, want 200 after queueing", i, code)
+		}
+	}
+}
+
+func TestConcurrencyLimitFailsFastWhenSaturated(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int64
+	release := make(chan struct{})
+	backend := newTestBackend(t, blockingBackend(&inFlight, &maxInFlight, release))
+	t.Setenv("CONCURRENCY_CONC_FAIL", "2")
+	setForTest(t, &concurrencyWait, 0)
+	r := directTestRouter(t, "conc-fail", http.MethodGet)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(r, "/api/conc-fail")
+		}()
+	}
+	waitFor(t, func() bool { return inFlight.Load() == 2 })
+
+	w := get(r, "/api/conc-fail")
+	close(release)
+	wg.Wait()
+	if w.Code != http.StatusServiceUnavailable || errorCode(t, w) != "backend_busy" {
+		t.Errorf("saturated endpoint: status %d, body %s, want 503 backend_busy", w.Code, w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+
+	// Other endpoints are not limited
+	if sem := endpointSemaphore("conc-unlimited"); sem != nil {
+		t.Errorf("endpoint without CONCURRENCY_ has a semaphore of %d", cap(sem))
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// corsMiddleware creates the CORS middleware for both development and
+// production, allowing the origins matched by origins
+func corsMiddleware(origins *originMatcher) gin.HandlerFunc {
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOriginFunc = origins.allowed
+	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key", "X-Request-ID", "X-Bypass-Cache", "Idempotency-Key"}
+	corsConfig.ExposeHeaders = []string{
+		"Content-Length", "X-Request-ID", "X-Cache", "X-Cache-Status", "ETag", "Retry-After",
+		"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset",
+	}
+	corsConfig.AllowCredentials = true
+	corsConfig.MaxAge = 12 * time.Hour
+	return cors.New(corsConfig)
+}
+
+// originMatcher checks request origins against exact origins and wildcard
+// subdomain patterns such as *.example.com or https://*.example.com
+type originMatcher struct {
+	exact     map[string]bool
+	wildcards []originWildcard
+}
+
+// originWildcard matches any subdomain of suffix, optionally only for scheme
+type originWildcard struct {
+	scheme string
+	suffix string
+}
+
+// loadCORSOrigins reads the allowed origins from the comma-separated
+// CORS_ALLOWED_ORIGINS env var (or the older ALLOWED_ORIGINS), falling back
+// to localhost origins in debug mode
+func loadCORSOrigins() *originMatcher {
+	value := getEnv("CORS_ALLOWED_ORIGINS", getEnv("ALLOWED_ORIGINS", ""))
+	var origins []string
+	for _, o := range strings.Split(value, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			origins = append(origins, o)
+		}
+	}
+
+	switch {
+	case len(origins) > 0:
+		slog.Info("Using configured allowed origins", "origins", origins)
+	case gin.Mode() != gin.ReleaseMode:
+		origins = []string{
+			"http://localhost:3000",
+			"http://localhost:8080",
+		}
+		slog.Info("Using default development allowed origins", "origins", origins)
+	default:
+		slog.Warn("CORS_ALLOWED_ORIGINS not set - cross-origin requests disabled")
+	}
+
+	return newOriginMatcher(origins)
+}
+
+// newOriginMatcher builds a matcher from exact origins and wildcard patterns
+func newOriginMatcher(origins []string) *originMatcher {
+	m := &originMatcher{exact: map[string]bool{}}
+	for _, o := range origins {
+		scheme, host := "", o
+		if i := strings.Index(o, "://"); i >= 0 {
+			scheme, host = o[:i], o[i+3:]
+		}
+		if strings.HasPrefix(host, "*.") {
+			m.wildcards = append(m.wildcards, originWildcard{scheme: scheme, suffix: host[1:]})
+			continue
+		}
+		m.exact[o] = true
+	}
+	return m
+}
+
+// allowed reports whether origin may make cross-origin requests
+func (m *originMatcher) allowed(origin string) bool {
+	if m.exact[origin] {
+		return true
+	}
+	if len(m.wildcards) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := u.Hostname()
+	for _, w := range m.wildcards {
+		if (w.scheme == "" || w.scheme == u.Scheme) && strings.HasSuffix(host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
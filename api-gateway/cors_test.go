@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://app.example.org/, *.example.com, https://*.secure.io")
+	origins := loadCORSOrigins()
+	r := gin.New()
+	r.Use(corsMiddleware(origins))
+	r.GET("/api/prices", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tc := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.org", true},
+		{"https://dash.example.com", true},
+		{"http://a.b.example.com", true},
+		{"https://x.secure.io", true},
+		{"http://x.secure.io", false},
+		{"https://example.com.evil.org", false},
+		{"https://evilexample.com", false},
+		{"https://other.org", false},
+	} {
+		w := serve(r, http.MethodGet, "/api/prices", http.Header{"Origin": {tc.origin}}, "")
+		got := w.Header().Get("Access-Control-Allow-Origin")
+		if tc.allowed && (w.Code != http.StatusOK || got != tc.origin) {
+			t.Errorf("%s: status %d, Access-Control-Allow-Origin %q, want the origin allowed", tc.origin, w.Code, got)
+		}
+		if !tc.allowed && (w.Code != http.StatusForbidden || got != "") {
+			t.Errorf("%s: status %d, Access-Control-Allow-Origin %q, want the origin rejected", tc.origin, w.Code, got)
+		}
+	}
+
+	// Browsers only let scripts read the exposed headers
+	exposed := serve(r, http.MethodGet, "/api/prices", http.Header{"Origin": {"https://app.example.org"}}, "").
+		Header().Get("Access-Control-Expose-Headers")
+	for _, h := range []string{"X-Cache", "Etag", "Retry-After", "X-Ratelimit-Remaining"} {
+		if !strings.Contains(exposed, h) {
+			t.Errorf("Access-Control-Expose-Headers %q, want %s exposed", exposed, h)
+		}
+	}
+
+	// Test requests have Host example.com, so the apex is checked directly
+	if origins.allowed("https://example.com") {
+		t.Error("*.example.com allowed the apex domain")
+	}
+
+	preflight := serve(r, http.MethodOptions, "/api/prices", http.Header{
+		"Origin":                        {"https://dash.example.com"},
+		"Access-Control-Request-Method": {http.MethodGet},
+	}, "")
+	if preflight.Header().Get("Access-Control-Allow-Origin") != "https://dash.example.com" || preflight.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("preflight headers %v", preflight.Header())
+	}
+}
+
+func TestCORSDefaultsToLocalhostInDebugMode(t *testing.T) {
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	t.Setenv("ALLOWED_ORIGINS", "")
+	gin.SetMode(gin.DebugMode)
+	t.Cleanup(func() { gin.SetMode(gin.TestMode) })
+
+	origins := loadCORSOrigins()
+	if !origins.allowed("http://localhost:3000") || origins.allowed("https://example.com") {
+		t.Error("debug mode without CORS_ALLOWED_ORIGINS should allow only localhost")
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	if loadCORSOrigins().allowed("http://localhost:3000") {
+		t.Error("release mode without CORS_ALLOWED_ORIGINS should allow no origins")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// diffEndpoints are the cached endpoints whose bodies are kept by ETag
+	// so clients can request changes since a version they already have
+	diffEndpoints = map[string]bool{"prices": true}
+
+	// diffSnapshotTTL is how long previous bodies are kept for diffs
+	diffSnapshotTTL = getEnvDuration("DIFF_SNAPSHOT_TTL", time.Hour)
+)
+
+// snapshotKey returns the cache key of the body of endpoint with etag
+func snapshotKey(endpoint, etag string) string {
+	return namespacedKey("snapshot:" + endpoint + ":" + strings.Trim(etag, `"`))
+}
+
+// storeSnapshot keeps body under its ETag for later diffs
+func storeSnapshot(ctx context.Context, endpoint string, body []byte) {
+	key := snapshotKey(endpoint, computeETag(body))
+	if err := cacheStore.Set(ctx, key, signCacheEntry(key, body), diffSnapshotTTL); err != nil {
+		slog.Warn("Error storing diff snapshot", "key", key, "error", err)
+		checkRedisError(err)
+	}
+}
+
+// loadSnapshot returns the body of endpoint with etag, if still kept
+func loadSnapshot(ctx context.Context, endpoint, etag string) ([]byte, bool) {
+	if redisBypassed() {
+		return nil, false
+	}
+	key := snapshotKey(endpoint, etag)
+	data, _, err := cacheStore.Get(ctx, key)
+	if err != nil {
+		if err != errCacheMiss {
+			checkRedisError(err)
+		}
+		return nil, false
+	}
+	return verifyCacheEntry(key, data)
+}
+
+// diffHandler creates a handler for /api/<endpoint>/diff?since=<etag>. It
+// responds with 204 if the body is unchanged since the client's ETag, with
+// a JSON merge patch (RFC 7386) from that version if it is still kept, and
+// with the full body otherwise. The current ETag is always sent.
+func diffHandler(endpoint string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		since := c.Query("since")
+		values := c.Request.URL.Query()
+		values.Del("since")
+
+		body, status, etag, err := loadCachedBody(ctx, endpoint, normalizeQuery(values.Encode()))
+		if err != nil {
+			respondBackendError(c, err)
+			return
+		}
+		if status >= http.StatusBadRequest {
+			respondBackendStatus(c, status, body)
+			return
+		}
+		if status != http.StatusOK {
+			c.Data(status, "application/json", body)
+			return
+		}
+
+		c.Header("ETag", etag)
+		if since == "" {
+			c.Data(http.StatusOK, "application/json", body)
+			return
+		}
+		if etagMatches(since, etag) {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		previous, ok := loadSnapshot(ctx, endpoint, strings.TrimPrefix(since, "W/"))
+		if !ok {
+			c.Data(http.StatusOK, "application/json", body)
+			return
+		}
+		patch, err := jsonMergePatch(previous, body)
+		if err != nil {
+			// Not JSON, send the whole body
+			c.Data(http.StatusOK, "application/json", body)
+			return
+		}
+		c.Data(http.StatusOK, "application/merge-patch+json", patch)
+	}
+}
+
+// jsonMergePatch returns the JSON merge patch turning the JSON document
+// from into to
+func jsonMergePatch(from, to []byte) ([]byte, error) {
+	var a, b interface{}
+	if err := json.Unmarshal(from, &a); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &b); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergePatch(a, b))
+}
+
+// mergePatch returns the merge patch from a to b. Objects are diffed by
+// member, with removed members set to null; other values that differ are
+// replaced whole.
+func mergePatch(a, b interface{}) interface{} {
+	objA, okA := a.(map[string]interface{})
+	objB, okB := b.(map[string]interface{})
+	if !okA || !okB {
+		return b
+	}
+
+	patch := map[string]interface{}{}
+	for name, valueA := range objA {
+		valueB, ok := objB[name]
+		if !ok {
+			patch[name] = nil
+		} else if !jsonEqual(valueA, valueB) {
+			patch[name] = mergePatch(valueA, valueB)
+		}
+	}
+	for name, valueB := range objB {
+		if _, ok := objA[name]; !ok {
+			patch[name] = valueB
+		}
+	}
+	return patch
+}
+
+// jsonEqual reports whether two decoded JSON values are equal
+func jsonEqual(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPricesDiff(t *testing.T) {
+	var body atomic.Value
+	body.Store(`{"BTC":50000,"ETH":3000,"DOGE":0.1}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		jsonBackend(body.Load().(string))(w, r)
+	})
+	r := pricesTestRouter(t)
+
+	w := get(r, "/api/prices/diff")
+	first := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":50000,"ETH":3000,"DOGE":0.1}` || first == "" {
+		t.Fatalf("without since: status %d, ETag %q, body %s, want the full body", w.Code, first, w.Body)
+	}
+
+	if w := get(r, "/api/prices/diff?since="+url.QueryEscape(first)); w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("unchanged: status %d, body %q, want an empty 204", w.Code, w.Body)
+	}
+
+	body.Store(`{"BTC":51000,"ETH":3000,"SOL":150}`)
+	if _, err := cacheStore.Del(context.Background(), namespacedKey("cache:prices:")); err != nil {
+		t.Fatal(err)
+	}
+	w = get(r, "/api/prices/diff?since="+url.QueryEscape(first))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/merge-patch+json" {
+		t.Fatalf("changed: status %d, Content-Type %q, want a merge patch", w.Code, w.Header().Get("Content-Type"))
+	}
+	var patch map[string]any
+	decodeJSON(t, w, &patch)
+	if len(patch) != 3 || patch["BTC"] != 51000.0 || patch["SOL"] != 150.0 || patch["DOGE"] != nil {
+		t.Errorf("patch %v, want BTC and SOL set and DOGE removed", patch)
+	}
+	if _, ok := patch["DOGE"]; !ok {
+		t.Error("patch doesn't remove DOGE")
+	}
+	if w.Header().Get("ETag") == first {
+		t.Error("ETag unchanged after the prices changed")
+	}
+
+	w = get(r, `/api/prices/diff?since="unknown"`)
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":51000,"ETH":3000,"SOL":150}` {
+		t.Errorf("unknown version: status %d, body %s, want the full body", w.Code, w.Body)
+	}
+}
+
+func TestJSONMergePatch(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, want string
+	}{
+		{`{"a":1,"b":2}`, `{"a":1,"b":2}`, `{}`},
+		{`{"a":1,"b":2}`, `{"a":1,"b":3}`, `{"b":3}`},
+		{`{"a":1,"b":2}`, `{"a":1}`, `{"b":null}`},
+		{`{"a":{"x":1,"y":2}}`, `{"a":{"x":1,"y":3}}`, `{"a":{"y":3}}`},
+		{`{"a":[1,2]}`, `{"a":[1,3]}`, `{"a":[1,3]}`},
+		{`[1]`, `{"a":1}`, `{"a":1}`},
+	} {
+		patch, err := jsonMergePatch([]byte(tc.from), []byte(tc.to))
+		if err != nil {
+			t.Fatalf("%s -> %s: %v", tc.from, tc.to, err)
+		}
+		var got, want any
+		json.Unmarshal(patch, &got)
+		json.Unmarshal([]byte(tc.want), &want)
+		if !jsonEqual(got, want) {
+			t.Errorf("%s -> %s: patch %s, want %s", tc.from, tc.to, patch, tc.want)
+		}
+	}
+	if _, err := jsonMergePatch([]byte(`not json`), []byte(`{}`)); err == nil {
+		t.Error("non-JSON body: no error")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errorResponse aborts the request with the gateway's JSON error envelope,
+// {"error": {"code": ..., "message": ..., "request_id": ...}}. Every error
+// the gateway responds with, including wrapped backend errors, uses it.
+func errorResponse(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": gin.H{
+		"code":       code,
+		"message":    message,
+		"request_id": c.GetString("request_id"),
+	}})
+}
+
+// respondBackendStatus wraps a backend error response in the error
+// envelope, replacing any backend headers describing its body
+func respondBackendStatus(c *gin.Context, status int, body []byte) {
+	c.Writer.Header().Del("Content-Length")
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Encoding")
+	errorResponse(c, status, "backend_error", backendErrorMessage(status, body))
+}
+
+// backendErrorMessage returns the message of a backend error body such as
+// {"error": "..."}, or a generic one if it has none
+func backendErrorMessage(status int, body []byte) string {
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		for _, name := range []string{"error", "message", "detail"} {
+			if message, ok := fields[name].(string); ok && message != "" {
+				return message
+			}
+		}
+	}
+	return fmt.Sprintf("backend returned status %d", status)
+}
+
+// notFound responds to requests for unregistered routes
+func notFound(c *gin.Context) {
+	errorResponse(c, http.StatusNotFound, "not_found", "not found")
+}
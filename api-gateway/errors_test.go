@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// assertErrorEnvelope fails the test unless w is a status response whose
+// body is exactly the error envelope with code and the request's ID
+func assertErrorEnvelope(t *testing.T, name string, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	var envelope map[string]map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || len(envelope) != 1 {
+		t.Errorf("%s: body %s is not an error envelope", name, w.Body)
+		return
+	}
+	fields := envelope["error"]
+	if w.Code != status || fields["code"] != code {
+		t.Errorf("%s: status %d, code %q, want %d %q", name, w.Code, fields["code"], status, code)
+	}
+	if len(fields) != 3 || fields["message"] == "" || fields["request_id"] == "" || fields["request_id"] != w.Header().Get("X-Request-ID") {
+		t.Errorf("%s: error %v, want a message and the request ID %q", name, fields, w.Header().Get("X-Request-ID"))
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("%s: Content-Type %q", name, ct)
+	}
+}
+
+// errorsTestRouter returns a router with request IDs and body limits
+// proxying /api/errors-cached with cachedProxy and /api/errors-direct with
+// directProxy
+func errorsTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	directEndpoints["errors-direct"] = true
+	t.Cleanup(func() { delete(directEndpoints, "errors-direct") })
+	r := gin.New()
+	r.Use(requestID(), limitRequestBody())
+	r.GET("/api/errors-cached", cachedProxy("errors-cached", time.Minute, time.Minute, 0))
+	r.Any("/api/errors-direct", directProxy)
+	r.NoRoute(notFound)
+	return r
+}
+
+func TestErrorEnvelopeOnEveryFailurePath(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("case") {
+		case "timeout":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		case "bad-gateway":
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"error":"upstream down"}`))
+		case "limited":
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	setForTest(t, &backendClient, &http.Client{Transport: backendClient.Transport, Timeout: 50 * time.Millisecond})
+	setForTest(t, &maxRequestBytes, 4)
+	r := errorsTestRouter(t)
+
+	// Cached endpoints pause fetches while the backend rate limits them,
+	// direct ones relay its 429 wrapped like other backend errors
+	for path, limitedCode := range map[string]string{"/api/errors-cached": "backend_rate_limited", "/api/errors-direct": "backend_error"} {
+		assertErrorEnvelope(t, path+" timeout", get(r, path+"?case=timeout"), http.StatusGatewayTimeout, "backend_timeout")
+		assertErrorEnvelope(t, path+" backend 502", get(r, path+"?case=bad-gateway"), http.StatusBadGateway, "backend_error")
+		assertErrorEnvelope(t, path+" backend 429", get(r, path+"?case=limited"), http.StatusTooManyRequests, limitedCode)
+	}
+	assertErrorEnvelope(t, "oversize body", serve(r, http.MethodPost, "/api/errors-direct", nil, "too large"), http.StatusRequestEntityTooLarge, "request_too_large")
+	assertErrorEnvelope(t, "unknown route", get(r, "/api/errors-unknown"), http.StatusNotFound, "not_found")
+}
+
+func TestErrorEnvelopeWrapsBackendMessages(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"error":"upstream down"}`))
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	r := errorsTestRouter(t)
+
+	for _, path := range []string{"/api/errors-cached", "/api/errors-direct"} {
+		var envelope struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		w := get(r, path)
+		if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil || envelope.Error.Message != "upstream down" {
+			t.Errorf("%s: body %s, want the backend's message wrapped", path, w.Body)
+		}
+	}
+}
+
+func TestErrorEnvelopeWhenBackendUnreachable(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{}`))
+	backend.Close()
+	setForTest(t, &backendMaxRetries, 0)
+	r := errorsTestRouter(t)
+
+	for _, path := range []string{"/api/errors-cached", "/api/errors-direct"} {
+		w := get(r, path)
+		assertErrorEnvelope(t, path, w, http.StatusBadGateway, "backend_error")
+		if strings.Contains(w.Body.String(), backend.Listener.Addr().String()) {
+			t.Errorf("%s: error leaks the backend address: %s", path, w.Body)
+		}
+	}
+}
+
+func TestErrorEnvelopeWhenRateLimited(t *testing.T) {
+	newTestRedis(t)
+	t.Setenv("RATE_LIMIT", "1")
+	t.Setenv("RATE_WINDOW", "1m")
+	r := gin.New()
+	r.Use(requestID(), rateLimit(nil))
+	r.GET("/api/prices", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	if w := get(r, "/api/prices"); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	assertErrorEnvelope(t, "rate limited", get(r, "/api/prices"), http.StatusTooManyRequests, "rate_limited")
+}
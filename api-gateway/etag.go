@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validatorHeaders are the conditional request headers sent to the backend
+// on a cache miss
+var validatorHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// withValidators returns header with the If-Modified-Since of the client
+// request added, or header unchanged if the client sent none. The client's
+// If-None-Match holds a gateway ETag the backend can't match, so it is never
+// forwarded, and If-Modified-Since is dropped with it as RFC 7232 has it
+// ignored then.
+func withValidators(header, request http.Header) http.Header {
+	value := request.Get("If-Modified-Since")
+	if value == "" || request.Get("If-None-Match") != "" {
+		return header
+	}
+	forwarded := header.Clone()
+	if forwarded == nil {
+		forwarded = http.Header{}
+	}
+	forwarded.Set("If-Modified-Since", value)
+	return forwarded
+}
+
+// revalidationHeader returns header with an If-None-Match of the backend
+// ETag of entry, so the backend can answer 304 if entry is still current,
+// or header unchanged if entry is nil or has no backend ETag
+func revalidationHeader(header http.Header, entry *cacheEntry) http.Header {
+	if entry == nil || entry.BackendETag == "" {
+		return header
+	}
+	revalidated := header.Clone()
+	if revalidated == nil {
+		revalidated = http.Header{}
+	}
+	revalidated.Set("If-None-Match", entry.BackendETag)
+	return revalidated
+}
+
+// validatorKey returns a suffix distinguishing fetches made with different
+// validators, so a conditional fetch is not shared with unconditional ones
+func validatorKey(header http.Header) string {
+	var key string
+	for _, name := range validatorHeaders {
+		if value := header.Get(name); value != "" {
+			key += "|" + name + "=" + value
+		}
+	}
+	return key
+}
+
+// computeETag returns a strong ETag for a response body
+func computeETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" || etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModifiedSince reports whether a resource last modified at
+// lastModified is unchanged since an If-Modified-Since header value
+func notModifiedSince(ifModifiedSince string, lastModified time.Time) bool {
+	if ifModifiedSince == "" || lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have second precision
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// notModified sets the ETag and Last-Modified headers and, if the client's
+// conditional headers match, responds with 304 and reports true. As in RFC
+// 7232, If-Modified-Since is ignored when If-None-Match is present.
+func notModified(c *gin.Context, etag string, lastModified time.Time) bool {
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	matched := false
+	if ifNoneMatch := c.GetHeader("If-None-Match"); ifNoneMatch != "" {
+		matched = etagMatches(ifNoneMatch, etag)
+	} else {
+		matched = notModifiedSince(c.GetHeader("If-Modified-Since"), lastModified)
+	}
+	if matched {
+		if c.Writer.Header().Get("X-Cache-Status") != "" {
+			c.Header("X-Cache-Status", "revalidated")
+		}
+		c.Status(http.StatusNotModified)
+	}
+	return matched
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestETagNotModifiedOnHitAndMiss(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	r := cachedTestRouter("etag-prices", time.Minute, time.Minute)
+
+	w := get(r, "/api/etag-prices")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag != computeETag([]byte(`{"BTC":50000}`)) {
+		t.Fatalf("first fetch: status %d, ETag %q", w.Code, etag)
+	}
+
+	conditional := http.Header{"If-None-Match": {etag}}
+	w = serve(r, http.MethodGet, "/api/etag-prices", conditional, "")
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("hit: status %d, X-Cache %q, body %q, want an empty 304", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+
+	if _, err := cacheStore.Del(context.Background(), namespacedKey("cache:etag-prices:")); err != nil {
+		t.Fatal(err)
+	}
+	w = serve(r, http.MethodGet, "/api/etag-prices", conditional, "")
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("miss: status %d, X-Cache %q, body %q, want an empty 304", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+
+	w = serve(r, http.MethodGet, "/api/etag-prices", http.Header{"If-None-Match": {`"stale"`}}, "")
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":50000}` {
+		t.Errorf("changed ETag: status %d, body %q, want the full response", w.Code, w.Body)
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	for _, tc := range []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{`"a"`, true},
+		{`W/"a"`, true},
+		{`"b", "a"`, true},
+		{`*`, true},
+		{`"b"`, false},
+		{``, false},
+	} {
+		if got := etagMatches(tc.ifNoneMatch, `"a"`); got != tc.want {
+			t.Errorf("etagMatches(%q) = %v, want %v", tc.ifNoneMatch, got, tc.want)
+		}
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	ok := jsonBackend(`{"BTC":50000}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		ok(w, r)
+	})
+	r := cachedTestRouter("ims-prices", time.Minute, time.Minute)
+
+	w := get(r, "/api/ims-prices")
+	if got := w.Header().Get("Last-Modified"); got != modified.Format(http.TimeFormat) {
+		t.Fatalf("Last-Modified %q, want the backend's", got)
+	}
+	etag := w.Header().Get("ETag")
+
+	for _, tc := range []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"unchanged", http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{"later", http.Header{"If-Modified-Since": {modified.Add(time.Hour).Format(http.TimeFormat)}}, http.StatusNotModified},
+		{"earlier", http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusOK},
+		{"invalid date", http.Header{"If-Modified-Since": {"yesterday"}}, http.StatusOK},
+		{"changed ETag wins", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modified.Format(http.TimeFormat)}}, http.StatusOK},
+		{"matching ETag wins", http.Header{"If-None-Match": {etag}, "If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, http.StatusNotModified},
+	} {
+		w := serve(r, http.MethodGet, "/api/ims-prices", tc.header, "")
+		if w.Code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.want)
+		}
+		if tc.want == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: 304 with body %q", tc.name, w.Body)
+		}
+	}
+}
+
+func TestLastModifiedDefaultsToCacheWriteTime(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	r := cachedTestRouter("ims-written", time.Minute, time.Minute)
+
+	before := time.Now().Truncate(time.Second)
+	get(r, "/api/ims-written")
+	w := get(r, "/api/ims-written")
+	lastModified, err := http.ParseTime(w.Header().Get("Last-Modified"))
+	if err != nil || lastModified.Before(before) || lastModified.After(time.Now()) {
+		t.Fatalf("Last-Modified %q, want the time the entry was cached", w.Header().Get("Last-Modified"))
+	}
+
+	conditional := http.Header{"If-Modified-Since": {w.Header().Get("Last-Modified")}}
+	if w := serve(r, http.MethodGet, "/api/ims-written", conditional, ""); w.Code != http.StatusNotModified || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("status %d, X-Cache %q, want a 304 hit", w.Code, w.Header().Get("X-Cache"))
+	}
+}
+
+func TestNotModifiedSince(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 500, time.UTC)
+	for _, tc := range []struct {
+		ifModifiedSince string
+		lastModified    time.Time
+		want            bool
+	}{
+		{"Fri, 01 Mar 2024 12:00:00 GMT", modified, true},
+		{"Fri, 01 Mar 2024 11:59:59 GMT", modified, false},
+		{"Fri, 01 Mar 2024 12:00:00 GMT", time.Time{}, false},
+		{"", modified, false},
+		{"not a date", modified, false},
+	} {
+		if got := notModifiedSince(tc.ifModifiedSince, tc.lastModified); got != tc.want {
+			t.Errorf("notModifiedSince(%q, %v) = %v, want %v", tc.ifModifiedSince, tc.lastModified, got, tc.want)
+		}
+	}
+}
+
+// conditionalBackend returns a handler serving body with backend validators
+// etag and modified, answering 304 to requests whose validators match. The
+// validators of each request are recorded in seen.
+func conditionalBackend(body, etag string, modified time.Time, seen *atomic.Value) http.HandlerFunc {
+	ok := jsonBackend(body)
+	return func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(http.Header{
+			"If-None-Match":     r.Header.Values("If-None-Match"),
+			"If-Modified-Since": r.Header.Values("If-Modified-Since"),
+		})
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+		if r.Header.Get("If-None-Match") == etag || notModifiedSince(r.Header.Get("If-Modified-Since"), modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		ok(w, r)
+	}
+}
+
+func TestMissForwardsIfModifiedSinceAndRelaysBackend304(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var seen atomic.Value
+	backend := newTestBackend(t, conditionalBackend(`{"BTC":50000}`, `"backend-v1"`, modified, &seen))
+	r := cachedTestRouter("conditional-relay", time.Minute, time.Minute)
+
+	since := modified.Format(http.TimeFormat)
+	w := serve(r, http.MethodGet, "/api/conditional-relay", http.Header{"If-Modified-Since": {since}}, "")
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("status %d, X-Cache %q, body %q, want the backend's 304 relayed", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("relayed 304 has ETag %q, want none", w.Header().Get("ETag"))
+	}
+	if got := seen.Load().(http.Header).Get("If-Modified-Since"); got != since {
+		t.Errorf("backend got If-Modified-Since %q, want the client's", got)
+	}
+	if cacheHas(namespacedKey("cache:conditional-relay:")) {
+		t.Error("304 cached")
+	}
+
+	w = get(r, "/api/conditional-relay")
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":50000}` || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("unconditional: status %d, X-Cache %q, body %s, want the full body", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+}
+
+func TestMissDoesNotForwardGatewayETags(t *testing.T) {
+	var seen atomic.Value
+	newTestBackend(t, conditionalBackend(`{"BTC":50000}`, `"backend-v1"`, time.Now(), &seen))
+	r := cachedTestRouter("conditional-etag", time.Minute, time.Minute)
+
+	header := http.Header{"If-None-Match": {`"gateway-etag"`}, "If-Modified-Since": {time.Now().Format(http.TimeFormat)}}
+	if w := serve(r, http.MethodGet, "/api/conditional-etag", header, ""); w.Code != http.StatusOK {
+		t.Errorf("status %d, want the full body for an unknown ETag", w.Code)
+	}
+	if got := seen.Load().(http.Header); len(got.Values("If-None-Match")) != 0 || len(got.Values("If-Modified-Since")) != 0 {
+		t.Errorf("backend got validators %v, want none with a gateway If-None-Match", got)
+	}
+}
+
+func TestBackend304RevalidatesLastKnownGoodCopy(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var seen atomic.Value
+	backend := newTestBackend(t, conditionalBackend(`{"BTC":50000}`, `"backend-v1"`, modified, &seen))
+	r := cachedTestRouter("conditional-lkg", time.Minute, time.Minute)
+	cacheKey := namespacedKey("cache:conditional-lkg:")
+
+	get(r, "/api/conditional-lkg")
+	if _, err := cacheStore.Del(context.Background(), cacheKey); err != nil {
+		t.Fatal(err)
+	}
+
+	w := get(r, "/api/conditional-lkg")
+	if w.Code != http.StatusOK || w.Body.String() != `{"BTC":50000}` || w.Header().Get("X-Cache") != "REVALIDATED" {
+		t.Errorf("status %d, X-Cache %q, body %s, want the revalidated copy", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	if got := seen.Load().(http.Header).Get("If-None-Match"); got != `"backend-v1"` {
+		t.Errorf("backend got If-None-Match %q, want its own ETag", got)
+	}
+	if w := get(r, "/api/conditional-lkg"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("after revalidation: X-Cache %q, want the copy cached again", w.Header().Get("X-Cache"))
+	}
+
+	// A backend 304 to the client's validators is answered from the copy
+	cacheStore.Del(context.Background(), cacheKey)
+	header := http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}
+	w = serve(r, http.MethodGet, "/api/conditional-lkg", header, "")
+	if w.Code != http.StatusNotModified || w.Header().Get("X-Cache") != "REVALIDATED" {
+		t.Errorf("client validators: status %d, X-Cache %q, want a revalidated 304", w.Code, w.Header().Get("X-Cache"))
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+}
+
+func TestStaleEntryRevalidatedByBackend304(t *testing.T) {
+	var seen atomic.Value
+	backend := newTestBackend(t, conditionalBackend(`{"BTC":50000}`, `"backend-v1"`, time.Now(), &seen))
+	r := cachedTestRouter("revalidate-304", 50*time.Millisecond, time.Minute)
+	cacheKey := cacheKeyFor("revalidate-304", "")
+
+	get(r, "/api/revalidate-304")
+	before, _ := getCacheEntry(context.Background(), cacheKey)
+	time.Sleep(60 * time.Millisecond)
+
+	if w := get(r, "/api/revalidate-304"); w.Header().Get("X-Cache") != "STALE" || w.Body.String() != `{"BTC":50000}` {
+		t.Fatalf("stale request: X-Cache %q, body %s, want the STALE body", w.Header().Get("X-Cache"), w.Body)
+	}
+	waitForRefreshes(t)
+
+	if got := seen.Load().(http.Header).Get("If-None-Match"); got != `"backend-v1"` {
+		t.Errorf("backend got If-None-Match %q, want the stored ETag", got)
+	}
+	after, ok := getCacheEntry(context.Background(), cacheKey)
+	if !ok || !after.SoftExpiry.After(before.SoftExpiry) || time.Now().After(after.SoftExpiry) {
+		t.Fatalf("entry %+v after a 304, want its TTL extended", after)
+	}
+	if string(after.Body) != string(before.Body) || after.BackendETag != `"backend-v1"` {
+		t.Errorf("entry body %q, ETag %q after a 304, want the existing ones kept", after.Body, after.BackendETag)
+	}
+	if w := get(r, "/api/revalidate-304"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"BTC":50000}` {
+		t.Errorf("after revalidation: X-Cache %q, body %s, want a HIT on the existing body", w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+	assertMetric(t, scrapeMetrics(t), `gateway_cache_revalidations_total{endpoint="revalidate-304",result="not_modified"} 1`)
+}
+
+func TestStaleEntryRefreshedByBackend200(t *testing.T) {
+	var version atomic.Int64
+	version.Store(1)
+	var seen atomic.Value
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("If-None-Match"))
+		etag := fmt.Sprintf(`"backend-v%d"`, version.Load())
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, version.Load())
+	})
+	r := cachedTestRouter("revalidate-200", 50*time.Millisecond, time.Minute)
+	cacheKey := cacheKeyFor("revalidate-200", "")
+
+	get(r, "/api/revalidate-200")
+	time.Sleep(60 * time.Millisecond)
+	version.Store(2)
+
+	if w := get(r, "/api/revalidate-200"); w.Header().Get("X-Cache") != "STALE" || w.Body.String() != `{"version":1}` {
+		t.Fatalf("stale request: X-Cache %q, body %s, want the STALE version 1", w.Header().Get("X-Cache"), w.Body)
+	}
+	waitForRefreshes(t)
+
+	if got := seen.Load(); got != `"backend-v1"` {
+		t.Errorf("backend got If-None-Match %v, want the stored ETag", got)
+	}
+	entry, ok := getCacheEntry(context.Background(), cacheKey)
+	if !ok || entry.BackendETag != `"backend-v2"` {
+		t.Fatalf("entry %+v after a 200, want the new backend ETag", entry)
+	}
+	if w := get(r, "/api/revalidate-200"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"version":2}` {
+		t.Errorf("after refresh: X-Cache %q, body %s, want a HIT on version 2", w.Header().Get("X-Cache"), w.Body)
+	}
+	assertMetric(t, scrapeMetrics(t), `gateway_cache_revalidations_total{endpoint="revalidate-200",result="modified"} 1`)
+
+	// An expired entry is revalidated too, and a 200 replaces it
+	version.Store(3)
+	if _, err := cacheStore.Del(context.Background(), cacheKey); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(r, "/api/revalidate-200"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != `{"version":3}` {
+		t.Errorf("expired request: X-Cache %q, body %s, want a MISS with version 3", w.Header().Get("X-Cache"), w.Body)
+	}
+	if got := seen.Load(); got != `"backend-v2"` {
+		t.Errorf("backend got If-None-Match %v, want the last known good ETag", got)
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+}
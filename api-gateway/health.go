@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// readyCacheTTL is how long a readiness result is reused
+	readyCacheTTL = getEnvDuration("READY_CACHE_TTL", 2*time.Second)
+
+	// backendProbePath is requested with HEAD to check backend reachability
+	backendProbePath = getEnv("BACKEND_PROBE_PATH", "/")
+
+	// startupRetryAfter is the Retry-After sent while the gateway is starting
+	startupRetryAfter = getEnvDuration("STARTUP_RETRY_AFTER", 5*time.Second)
+
+	// backendHealthInterval is how often the backend is probed for /health,
+	// or 0 to leave the backend out of /health
+	backendHealthInterval = getEnvDuration("BACKEND_HEALTH_INTERVAL", 0)
+
+	// backendHealth is the result of the latest backend probe for /health
+	backendHealth atomic.Pointer[backendHealthResult]
+
+	// started is set once startup, including any cache warmup, completes
+	started atomic.Bool
+
+	readyMu        sync.Mutex
+	readyResult    gin.H
+	readyHealthy   bool
+	readyCheckedAt time.Time
+)
+
+// backendHealthResult is the outcome of a background backend probe
+type backendHealthResult struct {
+	err       error
+	checkedAt time.Time
+}
+
+// startBackendHealthChecks probes the backend every backendHealthInterval in
+// the background so /health can report its status without a request per
+// poll
+func startBackendHealthChecks() {
+	if backendHealthInterval <= 0 {
+		return
+	}
+	checkBackendHealth()
+	go func() {
+		ticker := time.NewTicker(backendHealthInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkBackendHealth()
+		}
+	}()
+}
+
+// checkBackendHealth probes the backend and stores the result
+func checkBackendHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), min(backendHealthInterval, 2*time.Second))
+	defer cancel()
+	backendHealth.Store(&backendHealthResult{err: probeBackend(ctx), checkedAt: time.Now()})
+}
+
+// healthCheck handles /health. It is a liveness check, so it always returns
+// 200. When BACKEND_HEALTH_INTERVAL is set it also reports the cache and the
+// last backend probe, with a degraded status while the backend is down;
+// /ready is what takes the gateway out of rotation.
+func healthCheck(c *gin.Context) {
+	result := backendHealth.Load()
+	if result == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+			"time":   time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	checks := gin.H{"cache": gin.H{"status": "ok"}}
+	if redisBypassed() {
+		checks["cache"] = gin.H{"status": "unavailable"}
+	}
+
+	status := "ok"
+	backend := gin.H{"status": "ok", "checked_at": result.checkedAt.Format(time.RFC3339)}
+	if result.err != nil {
+		status = "degraded"
+		backend["status"] = "unavailable"
+		backend["error"] = result.err.Error()
+	}
+	checks["backend"] = backend
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": status,
+		"checks": checks,
+		"time":   time.Now().Format(time.RFC3339),
+	})
+}
+
+// readinessCheck handles /ready, reporting 503 when the cache or the backend is
+// unavailable. Results are cached briefly so frequent probes don't hammer
+// the dependencies.
+func readinessCheck(c *gin.Context) {
+	if !started.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+		return
+	}
+
+	readyMu.Lock()
+	if time.Since(readyCheckedAt) > readyCacheTTL {
+		readyResult, readyHealthy = checkDependencies(c.Request.Context())
+		readyCheckedAt = time.Now()
+	}
+	result, healthy := readyResult, readyHealthy
+	readyMu.Unlock()
+
+	if !healthy {
+		c.JSON(http.StatusServiceUnavailable, result)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// startupGate creates a middleware that rejects /api requests with 503
+// until startup has completed
+func startupGate() gin.HandlerFunc {
+	retryAfter := strconv.Itoa(int(math.Ceil(startupRetryAfter.Seconds())))
+
+	return func(c *gin.Context) {
+		if !started.Load() && strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Header("Retry-After", retryAfter)
+			errorResponse(c, http.StatusServiceUnavailable, "service_starting", "service starting")
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkDependencies pings the cache and probes the backend
+func checkDependencies(ctx context.Context) (gin.H, bool) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	healthy := true
+	checks := gin.H{}
+
+	if err := cacheStore.Ping(ctx); err != nil {
+		healthy = false
+		checks["cache"] = gin.H{"status": "unavailable", "error": err.Error()}
+	} else {
+		checks["cache"] = gin.H{"status": "ok"}
+	}
+
+	if err := probeBackend(ctx); err != nil {
+		healthy = false
+		checks["backend"] = gin.H{"status": "unavailable", "error": err.Error()}
+	} else {
+		checks["backend"] = gin.H{"status": "ok"}
+	}
+
+	status := "ok"
+	if !healthy {
+		status = "unavailable"
+	}
+	return gin.H{
+		"status": status,
+		"checks": checks,
+		"time":   time.Now().Format(time.RFC3339),
+	}, healthy
+}
+
+// probeBackend sends a HEAD request to the backend, succeeding if any
+// backend answers without a 5xx status
+func probeBackend(ctx context.Context) error {
+	resp, err := tryBackends(ctx, backends, http.MethodHead, backendProbePath, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("backend returned %d", resp.StatusCode)
+	}
+	return nil
+}
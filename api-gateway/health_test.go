@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// healthTestRouter returns a router serving /health and /ready for a started
+// gateway, with no cached readiness result
+func healthTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	started.Store(true)
+	t.Cleanup(func() { started.Store(false) })
+	setForTest(t, &readyCheckedAt, time.Time{})
+	setForTest(t, &backendMaxRetries, 0)
+	r := gin.New()
+	r.GET("/health", healthCheck)
+	r.GET("/ready", readinessCheck)
+	return r
+}
+
+// readyChecks returns the status of each dependency in a /ready response
+func readyChecks(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	var resp struct {
+		Checks map[string]struct{ Status string }
+	}
+	decodeJSON(t, w, &resp)
+	statuses := map[string]string{}
+	for name, check := range resp.Checks {
+		statuses[name] = check.Status
+	}
+	return statuses
+}
+
+func TestReadinessReportsDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		redisDown   bool
+		backendCode int
+		wantCode    int
+		want        map[string]string
+	}{
+		{"healthy", false, http.StatusOK, http.StatusOK, map[string]string{"cache": "ok", "backend": "ok"}},
+		{"redis down", true, http.StatusOK, http.StatusServiceUnavailable, map[string]string{"cache": "unavailable", "backend": "ok"}},
+		{"backend down", false, http.StatusBadGateway, http.StatusServiceUnavailable, map[string]string{"cache": "ok", "backend": "unavailable"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.backendCode)
+			})
+			redis := newTestRedis(t)
+			if tc.redisDown {
+				redis.Close()
+			}
+			r := healthTestRouter(t)
+
+			w := get(r, "/ready")
+			if w.Code != tc.wantCode {
+				t.Errorf("/ready status %d, want %d", w.Code, tc.wantCode)
+			}
+			if got := readyChecks(t, w); len(got) != len(tc.want) || got["cache"] != tc.want["cache"] || got["backend"] != tc.want["backend"] {
+				t.Errorf("/ready checks %v, want %v", got, tc.want)
+			}
+			if w := get(r, "/health"); w.Code != http.StatusOK {
+				t.Errorf("/health status %d, want 200 regardless of dependencies", w.Code)
+			}
+		})
+	}
+}
+
+func TestReadinessResultIsCached(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{}`))
+	r := healthTestRouter(t)
+	setForTest(t, &readyCacheTTL, time.Minute)
+
+	for i := 0; i < 5; i++ {
+		if w := get(r, "/ready"); w.Code != http.StatusOK {
+			t.Fatalf("/ready status %d", w.Code)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend probed %d times, want 1 within the readiness cache TTL", hits)
+	}
+}
+
+func TestReadinessBeforeStartup(t *testing.T) {
+	r := gin.New()
+	r.GET("/ready", readinessCheck)
+	if w := get(r, "/ready"); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/ready before startup: status %d, want 503", w.Code)
+	}
+}
+
+func TestStartupGate(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &startupRetryAfter, 1500*time.Millisecond)
+	r := gin.New()
+	r.Use(startupGate())
+	r.GET("/health", healthCheck)
+	r.GET("/api/startup-gate", cachedProxy("startup-gate", time.Minute, time.Minute, 0))
+
+	w := get(r, "/api/startup-gate")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" || errorCode(t, w) != "service_starting" {
+		t.Errorf("before startup: status %d, Retry-After %q, body %s, want 503 with Retry-After 2", w.Code, w.Header().Get("Retry-After"), w.Body)
+	}
+	if w := get(r, "/health"); w.Code != http.StatusOK {
+		t.Errorf("/health before startup: status %d, want 200", w.Code)
+	}
+
+	started.Store(true)
+	t.Cleanup(func() { started.Store(false) })
+	if w := get(r, "/api/startup-gate"); w.Code != http.StatusOK || w.Header().Get("Retry-After") != "" {
+		t.Errorf("after startup: status %d, Retry-After %q, want 200", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+// healthChecks returns the overall status and the status of each check in a
+// /health response
+func healthChecks(t *testing.T, w *httptest.ResponseRecorder) (string, map[string]map[string]string) {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("/health status %d, want 200", w.Code)
+	}
+	var resp struct {
+		Status string
+		Checks map[string]map[string]string
+	}
+	decodeJSON(t, w, &resp)
+	return resp.Status, resp.Checks
+}
+
+func TestHealthReportsBackendProbe(t *testing.T) {
+	var down atomic.Bool
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	setForTest(t, &backendHealthInterval, time.Second)
+	t.Cleanup(func() {
+		backendHealth.Store(nil)
+		redisBypass.Store(false)
+	})
+	r := healthTestRouter(t)
+
+	if status, checks := healthChecks(t, get(r, "/health")); status != "ok" || checks != nil {
+		t.Errorf("before any probe: status %q, checks %v, want ok without checks", status, checks)
+	}
+
+	checkBackendHealth()
+	for i := 0; i < 3; i++ {
+		status, checks := healthChecks(t, get(r, "/health"))
+		if status != "ok" || checks["backend"]["status"] != "ok" || checks["cache"]["status"] != "ok" {
+			t.Errorf("backend up: status %q, checks %v", status, checks)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend probed %d times, want once however often /health is polled", hits)
+	}
+
+	down.Store(true)
+	checkBackendHealth()
+	status, checks := healthChecks(t, get(r, "/health"))
+	if status != "degraded" || checks["backend"]["status"] != "unavailable" || checks["backend"]["error"] != "backend returned 503" {
+		t.Errorf("backend down: status %q, checks %v, want degraded with the backend unavailable", status, checks)
+	}
+	if _, err := time.Parse(time.RFC3339, checks["backend"]["checked_at"]); err != nil {
+		t.Errorf("checked_at %q: %v", checks["backend"]["checked_at"], err)
+	}
+
+	redisBypass.Store(true)
+	if _, checks := healthChecks(t, get(r, "/health")); checks["cache"]["status"] != "unavailable" {
+		t.Errorf("cache bypassed: cache check %v, want unavailable", checks["cache"])
+	}
+
+	down.Store(false)
+	checkBackendHealth()
+	if status, checks := healthChecks(t, get(r, "/health")); status != "ok" || checks["backend"]["status"] != "ok" {
+		t.Errorf("backend recovered: status %q, checks %v", status, checks)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// priceHistoryEndpoint is the endpoint serving historical prices of a symbol
+// over a time range
+const priceHistoryEndpoint = "prices/history"
+
+var (
+	// historyIntervals are the candle intervals accepted by price history
+	// requests, from the comma-separated HISTORY_INTERVALS env var, e.g.
+	// 1m,1h,1d
+	historyIntervals = parseHistoryIntervals(getEnv("HISTORY_INTERVALS", "1m,5m,15m,1h,4h,1d"))
+
+	// historyDefaultInterval is the interval of price history requests that
+	// don't give one
+	historyDefaultInterval = getEnv("HISTORY_DEFAULT_INTERVAL", "1h")
+
+	// historyMaxSpan bounds the time range of a price history request
+	historyMaxSpan = getEnvDuration("HISTORY_MAX_SPAN", 365*24*time.Hour)
+
+	// historyMinTTL and historyMaxTTL bound the cache TTL of price history
+	// responses, which is a quarter of their interval
+	historyMinTTL = getEnvDuration("HISTORY_MIN_TTL", 15*time.Second)
+	historyMaxTTL = getEnvDuration("HISTORY_MAX_TTL", time.Hour)
+)
+
+// parseHistoryIntervals parses a comma-separated list of intervals,
+// exiting if one is invalid
+func parseHistoryIntervals(value string) map[string]time.Duration {
+	intervals := map[string]time.Duration{}
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		d, err := parseInterval(name)
+		if err != nil {
+			slog.Error("Error parsing HISTORY_INTERVALS", "interval", name, "error", err)
+			os.Exit(1)
+		}
+		intervals[name] = d
+	}
+	return intervals
+}
+
+// parseInterval parses an interval such as 15m or 4h, also accepting days
+// and weeks, e.g. 1d or 1w
+func parseInterval(value string) (time.Duration, error) {
+	var unit time.Duration
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(value)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("interval must be positive")
+		}
+		return d, err
+	}
+	n, err := strconv.Atoi(value[:len(value)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// registerPriceHistory registers
+// /api/prices/history?symbol=BTC&from=...&to=...&interval=1h, cached for a
+// time depending on the interval
+func registerPriceHistory(r gin.IRoutes, route routeConfig, middleware []gin.HandlerFunc) {
+	routeQueryTTLs[priceHistoryEndpoint] = historyTTL
+	cachedRoute(r, priceHistoryEndpoint, route.ttl, route.MaxCacheBytes, append(middleware, validateHistoryRange())...)
+	requireQuery(priceHistoryEndpoint)
+}
+
+// validateHistoryRange creates a middleware that rejects price history
+// requests without a valid symbol, with a from/to range that is reversed or
+// longer than historyMaxSpan, or with an interval not in historyIntervals.
+// Requests without an interval get historyDefaultInterval.
+func validateHistoryRange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		symbol := query.Get("symbol")
+		if symbol == "" {
+			errorResponse(c, http.StatusBadRequest, "invalid_symbol", "symbol is required")
+			return
+		}
+		if !symbolPattern.MatchString(symbol) {
+			errorResponse(c, http.StatusBadRequest, "invalid_symbol", fmt.Sprintf("Invalid symbol %q", symbol))
+			return
+		}
+
+		from, err := parseHistoryTime(query, "from")
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "invalid_range", err.Error())
+			return
+		}
+		to, err := parseHistoryTime(query, "to")
+		if err != nil {
+			errorResponse(c, http.StatusBadRequest, "invalid_range", err.Error())
+			return
+		}
+		if !from.Before(to) {
+			errorResponse(c, http.StatusBadRequest, "invalid_range", "from must be before to")
+			return
+		}
+		if to.Sub(from) > historyMaxSpan {
+			errorResponse(c, http.StatusBadRequest, "invalid_range", fmt.Sprintf("Range must not be longer than %s", historyMaxSpan))
+			return
+		}
+
+		if !query.Has("interval") {
+			query.Set("interval", historyDefaultInterval)
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		if _, ok := historyIntervals[query.Get("interval")]; !ok {
+			errorResponse(c, http.StatusBadRequest, "invalid_interval", fmt.Sprintf("Unsupported interval %q", query.Get("interval")))
+			return
+		}
+		c.Next()
+	}
+}
+
+// parseHistoryTime parses the required time param name, given as unix
+// seconds or RFC 3339
+func parseHistoryTime(query url.Values, name string) (time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("%s is required", name)
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s %q: expected unix seconds or RFC 3339", name, value)
+	}
+	return t, nil
+}
+
+// historyTTL returns the cache TTL of a price history response: a quarter of
+// its interval, so finer candles are refreshed sooner, bounded by
+// historyMinTTL and historyMaxTTL
+func historyTTL(query string) time.Duration {
+	values, _ := url.ParseQuery(query)
+	interval, ok := historyIntervals[values.Get("interval")]
+	if !ok {
+		interval = historyIntervals[historyDefaultInterval]
+	}
+	return min(max(interval/4, historyMinTTL), historyMaxTTL)
+}
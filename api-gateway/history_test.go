@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPriceHistoryProxiesValidRanges(t *testing.T) {
+	var query atomic.Value
+	ok := jsonBackend(`{"candles":[]}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		query.Store(r.URL.RawQuery)
+		ok(w, r)
+	})
+	r := pricesTestRouter(t)
+
+	w := get(r, "/api/prices/history?symbol=BTC&from=1700000000&to=1700086400&interval=1h")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" || w.Body.String() != `{"candles":[]}` {
+		t.Fatalf("status %d, X-Cache %q, body %q, want the backend response", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	if got := query.Load(); got != "from=1700000000&interval=1h&symbol=BTC&to=1700086400" {
+		t.Errorf("backend query %v, want the sorted history params", got)
+	}
+
+	for _, target := range []string{
+		"/api/prices/history?symbol=BTC&from=1700000000&to=1700086400&interval=1h",
+		"/api/prices/history?interval=1h&to=1700086400&from=1700000000&symbol=BTC",
+		"/api/prices/history?symbol=BTC&from=1700000000&to=1700086400",
+	} {
+		if w := get(r, target); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("%s: status %d, X-Cache %q, want a HIT on the 1h entry", target, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Fatalf("backend hit %d times, want 1", hits)
+	}
+
+	for _, target := range []string{
+		"/api/prices/history?symbol=ETH&from=1700000000&to=1700086400&interval=1h",
+		"/api/prices/history?symbol=BTC&from=1700000001&to=1700086400&interval=1h",
+		"/api/prices/history?symbol=BTC&from=1700000000&to=1700086401&interval=1h",
+		"/api/prices/history?symbol=BTC&from=1700000000&to=1700086400&interval=5m",
+		"/api/prices/history?symbol=BTC&from=2023-11-14T22:13:20Z&to=2023-11-15T22:13:20Z&interval=1d",
+	} {
+		if w := get(r, target); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("%s: status %d, X-Cache %q, want a MISS keyed on its own params", target, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+	if hits := backend.hits.Load(); hits != 6 {
+		t.Errorf("backend hit %d times, want one per distinct symbol, range and interval", hits)
+	}
+}
+
+func TestPriceHistoryRejectsInvalidRanges(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"candles":[]}`))
+	setForTest(t, &historyMaxSpan, 30*24*time.Hour)
+	r := pricesTestRouter(t)
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		code  string
+	}{
+		{"missing symbol", "from=1700000000&to=1700086400", "invalid_symbol"},
+		{"invalid symbol", "symbol=BTC!&from=1700000000&to=1700086400", "invalid_symbol"},
+		{"missing from", "symbol=BTC&to=1700086400", "invalid_range"},
+		{"missing to", "symbol=BTC&from=1700000000", "invalid_range"},
+		{"invalid from", "symbol=BTC&from=yesterday&to=1700086400", "invalid_range"},
+		{"reversed", "symbol=BTC&from=1700086400&to=1700000000", "invalid_range"},
+		{"empty", "symbol=BTC&from=1700000000&to=1700000000", "invalid_range"},
+		{"too long", "symbol=BTC&from=1700000000&to=1702678401", "invalid_range"},
+		{"unsupported interval", "symbol=BTC&from=1700000000&to=1700086400&interval=2h", "invalid_interval"},
+	} {
+		w := get(r, "/api/prices/history?"+tc.query)
+		if w.Code != http.StatusBadRequest || errorCode(t, w) != tc.code {
+			t.Errorf("%s: status %d, body %s, want 400 %s", tc.name, w.Code, w.Body, tc.code)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 0 {
+		t.Errorf("backend hit %d times, want invalid ranges rejected before proxying", hits)
+	}
+}
+
+func TestPriceHistoryTTLDependsOnInterval(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"candles":[]}`))
+	r := pricesTestRouter(t)
+
+	for _, tc := range []struct {
+		interval string
+		ttl      time.Duration
+	}{
+		{"1m", 15 * time.Second},
+		{"15m", 225 * time.Second},
+		{"1h", 15 * time.Minute},
+		{"1d", time.Hour},
+	} {
+		target := "/api/prices/history?symbol=BTC&from=1700000000&to=1700086400&interval=" + tc.interval
+		get(r, target)
+		w := get(r, target)
+		want := int((2 * tc.ttl).Seconds())
+		remaining, _ := strconv.Atoi(w.Header().Get("X-Cache-TTL-Remaining"))
+		if w.Header().Get("X-Cache") != "HIT" || remaining > want || remaining < want-2 {
+			t.Errorf("%s: X-Cache %q, X-Cache-TTL-Remaining %d, want a HIT with about %d", tc.interval,
+				w.Header().Get("X-Cache"), remaining, want)
+		}
+	}
+}
+
+func TestHistoryTTL(t *testing.T) {
+	setForTest(t, &historyMinTTL, 30*time.Second)
+	setForTest(t, &historyMaxTTL, 30*time.Minute)
+	for query, want := range map[string]time.Duration{
+		"interval=1m":  30 * time.Second,
+		"interval=5m":  75 * time.Second,
+		"interval=4h":  30 * time.Minute,
+		"interval=1d":  30 * time.Minute,
+		"symbol=BTC":   15 * time.Minute,
+		"interval=bad": 15 * time.Minute,
+	} {
+		if got := historyTTL(query); got != want {
+			t.Errorf("historyTTL(%q) = %s, want %s", query, got, want)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"1m":  time.Minute,
+		"4h":  4 * time.Hour,
+		"1d":  24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+		"0s":  0,
+		"-1h": 0,
+		"0d":  0,
+		"xd":  0,
+		"1y":  0,
+	} {
+		got, err := parseInterval(value)
+		if want == 0 && err == nil || want != 0 && (err != nil || got != want) {
+			t.Errorf("parseInterval(%q) = %s, %v, want %s", value, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// hitRatioBuckets is the number of buckets a hit ratio window is split into
+const hitRatioBuckets = 10
+
+var (
+	// hitRatioFloor is the hit ratio below which a warning is logged, or 0
+	// to disable the warning
+	hitRatioFloor = getEnvFloat("HIT_RATIO_FLOOR", 0)
+
+	// hitRatioMinRequests is the number of requests in the window needed
+	// before a low hit ratio is reported
+	hitRatioMinRequests = int64(getEnvInt("HIT_RATIO_MIN_REQUESTS", 20))
+
+	// hitRatios tracks the rolling hit ratio of each cached endpoint over
+	// HIT_RATIO_WINDOW
+	hitRatios = newHitRatioTracker(getEnvDuration("HIT_RATIO_WINDOW", 5*time.Minute))
+)
+
+// hitRatioTracker counts cache hits and misses per endpoint in time buckets
+// so the ratio covers only the most recent window
+type hitRatioTracker struct {
+	window time.Duration
+	mu     sync.Mutex
+	counts map[string]*hitRatioCounts
+}
+
+// hitRatioCounts are the bucketed counts of one endpoint
+type hitRatioCounts struct {
+	buckets  [hitRatioBuckets]hitRatioBucket
+	warnedAt time.Time
+}
+
+// hitRatioBucket counts the requests of one slice of the window
+type hitRatioBucket struct {
+	start  time.Time
+	hits   int64
+	misses int64
+}
+
+// newHitRatioTracker creates a hitRatioTracker over window
+func newHitRatioTracker(window time.Duration) *hitRatioTracker {
+	return &hitRatioTracker{window: window, counts: map[string]*hitRatioCounts{}}
+}
+
+// record counts a hit or miss for endpoint, warning if the endpoint's hit
+// ratio has dropped below hitRatioFloor. Warnings for an endpoint are
+// logged at most once per window.
+func (t *hitRatioTracker) record(endpoint string, hit bool) {
+	now := time.Now()
+	bucketSize := t.window / hitRatioBuckets
+	if bucketSize <= 0 {
+		return
+	}
+	start := now.Truncate(bucketSize)
+
+	t.mu.Lock()
+	counts := t.counts[endpoint]
+	if counts == nil {
+		counts = &hitRatioCounts{}
+		t.counts[endpoint] = counts
+	}
+	bucket := &counts.buckets[(start.UnixNano()/int64(bucketSize))%hitRatioBuckets]
+	if !bucket.start.Equal(start) {
+		*bucket = hitRatioBucket{start: start}
+	}
+	if hit {
+		bucket.hits++
+	} else {
+		bucket.misses++
+	}
+
+	hits, requests := counts.sum(now, t.window)
+	warn := hitRatioFloor > 0 && requests >= hitRatioMinRequests &&
+		float64(hits)/float64(requests) < hitRatioFloor && now.Sub(counts.warnedAt) >= t.window
+	if warn {
+		counts.warnedAt = now
+	}
+	t.mu.Unlock()
+
+	if warn {
+		slog.Warn("Cache hit ratio below floor", "endpoint", endpoint, "hit_ratio", float64(hits)/float64(requests),
+			"floor", hitRatioFloor, "requests", requests, "window", t.window.String())
+	}
+}
+
+// sum returns the hits and requests counted within window of now
+func (c *hitRatioCounts) sum(now time.Time, window time.Duration) (hits, requests int64) {
+	for _, b := range c.buckets {
+		if now.Sub(b.start) < window {
+			hits += b.hits
+			requests += b.hits + b.misses
+		}
+	}
+	return hits, requests
+}
+
+// snapshot reports the hit ratio, hits and requests of each endpoint over
+// the window
+func (t *hitRatioTracker) snapshot() map[string]gin.H {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ratios := make(map[string]gin.H, len(t.counts))
+	for endpoint, counts := range t.counts {
+		hits, requests := counts.sum(now, t.window)
+		var ratio float64
+		if requests > 0 {
+			ratio = float64(hits) / float64(requests)
+		}
+		ratios[endpoint] = gin.H{"ratio": ratio, "hits": hits, "requests": requests}
+	}
+	return ratios
+}
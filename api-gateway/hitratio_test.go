@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHitRatioTracker(t *testing.T) {
+	tracker := newHitRatioTracker(time.Minute)
+	for _, hit := range []bool{true, false, true, true} {
+		tracker.record("prices", hit)
+	}
+	tracker.record("news", false)
+
+	ratios := tracker.snapshot()
+	if got := ratios["prices"]; got["ratio"] != 0.75 || got["hits"] != int64(3) || got["requests"] != int64(4) {
+		t.Errorf("prices %v, want 3 hits in 4 requests", got)
+	}
+	if got := ratios["news"]; got["ratio"] != 0.0 || got["requests"] != int64(1) {
+		t.Errorf("news %v, want a ratio of 0", got)
+	}
+}
+
+func TestHitRatioWindowExpires(t *testing.T) {
+	tracker := newHitRatioTracker(100 * time.Millisecond)
+	tracker.record("prices", true)
+	time.Sleep(120 * time.Millisecond)
+	tracker.record("prices", false)
+
+	if got := tracker.snapshot()["prices"]; got["ratio"] != 0.0 || got["requests"] != int64(1) {
+		t.Errorf("prices %v, want only the request within the window", got)
+	}
+}
+
+func TestHitRatioBelowFloorWarnsOncePerWindow(t *testing.T) {
+	setForTest(t, &hitRatioFloor, 0.5)
+	setForTest(t, &hitRatioMinRequests, 4)
+	logs := captureLogs(t, slog.LevelInfo)
+	tracker := newHitRatioTracker(time.Minute)
+
+	tracker.record("prices", false)
+	tracker.record("prices", false)
+	tracker.record("prices", false)
+	if lines := logs.lines(t, "Cache hit ratio below floor"); len(lines) != 0 {
+		t.Fatal("warned before hitRatioMinRequests requests")
+	}
+
+	tracker.record("prices", true)
+	for i := 0; i < 10; i++ {
+		tracker.record("prices", false)
+	}
+	tracker.record("news", true)
+	tracker.record("news", true)
+	tracker.record("news", true)
+	tracker.record("news", false)
+
+	lines := logs.lines(t, "Cache hit ratio below floor")
+	if len(lines) != 1 {
+		t.Fatalf("warned %d times, want once per window", len(lines))
+	}
+	if lines[0]["level"] != "WARN" || lines[0]["endpoint"] != "prices" || lines[0]["hit_ratio"] != 0.25 || lines[0]["requests"] != 4.0 {
+		t.Errorf("warning %v, want prices with a ratio of 0.25 over 4 requests", lines[0])
+	}
+}
+
+func TestCacheStatsReportHitRatio(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &hitRatios, newHitRatioTracker(time.Minute))
+	admin := adminTestRouter(t)
+	r := cachedTestRouter("ratio-prices", time.Minute, time.Minute)
+	for i := 0; i < 4; i++ {
+		get(r, "/api/ratio-prices")
+	}
+
+	w := serve(admin, http.MethodGet, "/admin/cache/stats", adminHeader, "")
+	var stats struct {
+		HitRatio       map[string]map[string]float64 `json:"hit_ratio"`
+		HitRatioWindow string                        `json:"hit_ratio_window"`
+	}
+	decodeJSON(t, w, &stats)
+	if got := stats.HitRatio["ratio-prices"]; got["ratio"] != 0.75 || got["requests"] != 4 {
+		t.Errorf("hit_ratio %v, want 3 hits in 4 requests", got)
+	}
+	if stats.HitRatioWindow != "1m0s" {
+		t.Errorf("hit_ratio_window %q, want 1m0s", stats.HitRatioWindow)
+	}
+}
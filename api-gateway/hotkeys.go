@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxTrackedKeys bounds the number of cache keys whose accesses are counted
+// for refresh-ahead
+const maxTrackedKeys = 10000
+
+var (
+	// refreshAhead turns on refreshing hot cache keys before they expire,
+	// from the REFRESH_AHEAD env var
+	refreshAhead = getEnvBool("REFRESH_AHEAD", false)
+
+	// refreshAheadInterval is how often hot keys are checked, from the
+	// REFRESH_AHEAD_INTERVAL env var
+	refreshAheadInterval = getEnvDuration("REFRESH_AHEAD_INTERVAL", 10*time.Second)
+
+	// hotKeyThreshold is the decayed access count that makes a key hot,
+	// from the HOT_KEY_THRESHOLD env var
+	hotKeyThreshold = getEnvInt("HOT_KEY_THRESHOLD", 10)
+
+	// hotKeys counts accesses of the cache keys served by cachedProxy
+	hotKeys = &hotKeyTracker{keys: map[string]*hotKey{}}
+)
+
+// hotKey is a cache key tracked for refresh-ahead with what is needed to
+// refresh it
+type hotKey struct {
+	endpoint    string
+	query       string
+	header      http.Header
+	body        []byte
+	ttl         time.Duration
+	staleWindow time.Duration
+	hits        int
+}
+
+// hotKeyTracker counts cache key accesses. Counts halve at every check so
+// keys that stop being requested cool down.
+type hotKeyTracker struct {
+	mu   sync.Mutex
+	keys map[string]*hotKey
+}
+
+// record counts an access of cacheKey
+func (t *hotKeyTracker) record(endpoint, query string, header http.Header, body []byte, cacheKey string, ttl, staleWindow time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key, ok := t.keys[cacheKey]
+	if !ok {
+		if len(t.keys) >= maxTrackedKeys {
+			return
+		}
+		key = &hotKey{endpoint: endpoint, query: query, header: header, body: body, ttl: ttl, staleWindow: staleWindow}
+		t.keys[cacheKey] = key
+	}
+	key.hits++
+}
+
+// hot returns the keys with at least threshold accesses and decays all
+// counts, forgetting keys that are no longer accessed
+func (t *hotKeyTracker) hot(threshold int) map[string]hotKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hot := map[string]hotKey{}
+	for cacheKey, key := range t.keys {
+		if key.hits >= threshold {
+			hot[cacheKey] = *key
+		}
+		key.hits /= 2
+		if key.hits == 0 {
+			delete(t.keys, cacheKey)
+		}
+	}
+	return hot
+}
+
+// startRefreshAhead checks hot keys every refreshAheadInterval when
+// REFRESH_AHEAD is set
+func startRefreshAhead() {
+	if !refreshAhead || refreshAheadInterval <= 0 {
+		return
+	}
+	slog.Info("Refresh-ahead enabled", "interval", refreshAheadInterval.String(), "hot_key_threshold", hotKeyThreshold)
+	go func() {
+		ticker := time.NewTicker(refreshAheadInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			refreshHotKeys(context.Background())
+		}
+	}()
+}
+
+// refreshHotKeys refreshes the hot keys that are missing or whose soft TTL
+// ends within two check intervals, so they are never served stale or cold
+func refreshHotKeys(ctx context.Context) {
+	if redisBypassed() {
+		return
+	}
+	deadline := time.Now().Add(2 * refreshAheadInterval)
+	for cacheKey, key := range hotKeys.hot(hotKeyThreshold) {
+		entry, ok := getCacheEntry(ctx, cacheKey)
+		if ok && (entry.Status != 0 || entry.SoftExpiry.After(deadline)) {
+			continue
+		}
+		slog.Debug("Refreshing hot key ahead of expiry", "key", cacheKey)
+		refreshAheads.WithLabelValues(key.endpoint).Inc()
+		if !ok {
+			entry = nil
+		}
+		refreshInBackground(key.endpoint, key.query, key.header, key.body, cacheKey, entry, key.ttl, key.staleWindow)
+	}
+}
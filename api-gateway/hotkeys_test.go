@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshAheadRefreshesHotKeysBeforeExpiry(t *testing.T) {
+	var version atomic.Int64
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":` + strconv.FormatInt(version.Add(1), 10) + `}`))
+	})
+	setForTest(t, &refreshAhead, true)
+	setForTest(t, &refreshAheadInterval, time.Second)
+	setForTest(t, &hotKeyThreshold, 3)
+	setForTest(t, &hotKeys, &hotKeyTracker{keys: map[string]*hotKey{}})
+	soon := cachedTestRouter("hot-prices", time.Second, time.Second)
+	later := cachedTestRouter("hot-later", time.Minute, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		get(soon, "/api/hot-prices?symbol=BTC")
+		get(later, "/api/hot-later")
+	}
+	get(soon, "/api/hot-prices?symbol=ETH")
+	hotKey := cacheKeyFor("hot-prices", "symbol=BTC")
+	before, _ := getCacheEntry(context.Background(), hotKey)
+
+	refreshHotKeys(context.Background())
+	waitFor(t, func() bool { return backend.hits.Load() == 4 })
+	waitForRefreshes(t)
+
+	after, ok := getCacheEntry(context.Background(), hotKey)
+	if !ok || !after.SoftExpiry.After(before.SoftExpiry) {
+		t.Fatal("hot key was not refreshed ahead of expiry")
+	}
+	if time.Now().After(before.SoftExpiry) {
+		t.Fatal("hot key refreshed too late to prove it was ahead of expiry")
+	}
+	w := get(soon, "/api/hot-prices?symbol=BTC")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"version":4}` {
+		t.Errorf("after refresh-ahead: X-Cache %q, body %s, want a HIT of the refreshed body", w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want cold and far from expiry keys left alone", hits)
+	}
+	assertMetric(t, scrapeMetrics(t), `gateway_refresh_ahead_total{endpoint="hot-prices"}`)
+}
+
+func TestRefreshAheadIsOffByDefault(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	setForTest(t, &refreshAhead, false)
+	setForTest(t, &hotKeys, &hotKeyTracker{keys: map[string]*hotKey{}})
+	r := cachedTestRouter("hot-off", time.Second, time.Second)
+	for i := 0; i < 5; i++ {
+		get(r, "/api/hot-off")
+	}
+	if len(hotKeys.keys) != 0 {
+		t.Errorf("tracked %d keys with REFRESH_AHEAD off", len(hotKeys.keys))
+	}
+}
+
+func TestHotKeyTrackerDecaysCounts(t *testing.T) {
+	tracker := &hotKeyTracker{keys: map[string]*hotKey{}}
+	for i := 0; i < 4; i++ {
+		tracker.record("prices", "", nil, nil, "hot", time.Minute, time.Minute)
+	}
+	tracker.record("prices", "", nil, nil, "cold", time.Minute, time.Minute)
+
+	for i, want := range []int{1, 1, 0} {
+		hot := tracker.hot(2)
+		if _, ok := hot["hot"]; (want == 1) != ok || len(hot) > 1 {
+			t.Errorf("check %d: hot keys %v", i, hot)
+		}
+	}
+	if len(tracker.keys) != 0 {
+		t.Errorf("still tracking %d keys no longer accessed", len(tracker.keys))
+	}
+}
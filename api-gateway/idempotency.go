@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyTTL is how long the response to a request with an
+// Idempotency-Key is replayed, from the IDEMPOTENCY_TTL env var
+var idempotencyTTL = getEnvDuration("IDEMPOTENCY_TTL", 24*time.Hour)
+
+// maxIdempotencyKeyLength bounds accepted Idempotency-Key headers
+const maxIdempotencyKeyLength = 255
+
+// idempotentResponse is the stored response to a request with an
+// Idempotency-Key
+type idempotentResponse struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+
+	// Fingerprint identifies the request the response is for
+	Fingerprint string `json:"fingerprint"`
+}
+
+// idempotencyLockTTL bounds how long the in-flight marker of a request
+// with an Idempotency-Key is held in Redis, so a gateway dying mid-request
+// doesn't block retries for good. From the IDEMPOTENCY_LOCK_TTL env var.
+var idempotencyLockTTL = getEnvDuration("IDEMPOTENCY_LOCK_TTL", time.Minute)
+
+// idempotencyInFlight holds the idempotency keys of requests being proxied
+// by this gateway, with the memory cache backend
+var idempotencyInFlight sync.Map
+
+// idempotency creates a middleware that replays the stored response of the
+// first POST or PATCH with the same Idempotency-Key within idempotencyTTL,
+// instead of forwarding the retry. Keys are scoped to the client's API key
+// and path, and reusing one for a different request body is rejected.
+// Server errors are not stored so the request can be retried.
+func idempotency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		idempotencyKey := c.GetHeader("Idempotency-Key")
+		if idempotencyKey == "" || (c.Request.Method != http.MethodPost && c.Request.Method != http.MethodPatch) {
+			c.Next()
+			return
+		}
+		if len(idempotencyKey) > maxIdempotencyKeyLength {
+			errorResponse(c, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key is too long")
+			return
+		}
+
+		body, ok := readRequestBody(c)
+		if !ok {
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		key := idempotencyCacheKey(c, idempotencyKey)
+		fingerprint := hashHex([]byte(c.Request.Method), []byte(c.Request.URL.RawQuery), body)
+		if stored, ok := loadIdempotentResponse(c, key); ok {
+			if stored.Fingerprint != fingerprint {
+				errorResponse(c, http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was used for a different request")
+				return
+			}
+			slog.Debug("Replaying idempotent response", "key", key)
+			c.Header("Idempotent-Replayed", "true")
+			c.Data(stored.Status, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		unlock, ok := lockIdempotencyKey(c, key)
+		if !ok {
+			errorResponse(c, http.StatusConflict, "idempotency_key_in_progress", "A request with this Idempotency-Key is in progress")
+			return
+		}
+		defer unlock()
+
+		writer := &snippetResponseWriter{ResponseWriter: c.Writer, snippet: snippet{max: int(maxResponseBytes)}}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError || writer.total > len(writer.buf) || redisBypassed() || redisWritesPaused() {
+			return
+		}
+		data, err := json.Marshal(idempotentResponse{
+			Status:      status,
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.buf,
+			Fingerprint: fingerprint,
+		})
+		if err == nil {
+			err = cacheStore.Set(ctx, key, signCacheEntry(key, data), idempotencyTTL)
+		}
+		if err != nil {
+			slog.Warn("Error storing idempotent response", "key", key, "error", err)
+			checkRedisError(err)
+		}
+	}
+}
+
+// idempotencyCacheKey returns the cache key of the response to requests
+// with idempotencyKey from the client to the request's path
+func idempotencyCacheKey(c *gin.Context, idempotencyKey string) string {
+	client := hashHex([]byte(c.GetHeader("X-API-Key")))
+	return namespacedKey("idempotency:" + client + ":" + hashHex([]byte(c.Request.URL.Path), []byte(idempotencyKey)))
+}
+
+// lockIdempotencyKey marks the request with the idempotency key as in
+// flight, returning false if another request holds it. With Redis the
+// marker is shared by every gateway; while Redis is unavailable requests go
+// ahead unmarked, as their responses can't be stored either.
+func lockIdempotencyKey(c *gin.Context, key string) (unlock func(), ok bool) {
+	if rdb == nil {
+		if _, running := idempotencyInFlight.LoadOrStore(key, struct{}{}); running {
+			return nil, false
+		}
+		return func() { idempotencyInFlight.Delete(key) }, true
+	}
+	if redisBypassed() || redisWritesPaused() {
+		return func() {}, true
+	}
+
+	lockKey := key + ":lock"
+	locked, err := rdb.SetNX(c.Request.Context(), lockKey, 1, idempotencyLockTTL).Result()
+	if err != nil {
+		slog.Warn("Error marking idempotent request in flight", "key", key, "error", err)
+		checkRedisError(err)
+		return func() {}, true
+	}
+	if !locked {
+		return nil, false
+	}
+	return func() {
+		// The request context may be canceled by now
+		if err := rdb.Del(context.Background(), lockKey).Err(); err != nil {
+			slog.Warn("Error clearing idempotent request in flight", "key", key, "error", err)
+		}
+	}, true
+}
+
+// loadIdempotentResponse returns the response stored under key, if any
+func loadIdempotentResponse(c *gin.Context, key string) (*idempotentResponse, bool) {
+	if redisBypassed() {
+		return nil, false
+	}
+	data, _, err := cacheStore.Get(c.Request.Context(), key)
+	if err != nil {
+		if err != errCacheMiss {
+			checkRedisError(err)
+		}
+		return nil, false
+	}
+	encoded, ok := verifyCacheEntry(key, data)
+	if !ok {
+		return nil, false
+	}
+	var stored idempotentResponse
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return nil, false
+	}
+	return &stored, true
+}
+
+// hashHex returns the hex SHA-256 of parts, each length-prefixed so
+// different splits of the same bytes differ
+func hashHex(parts ...[]byte) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(part))))
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
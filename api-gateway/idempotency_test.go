@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idempotencyTestRouter returns a router proxying POSTs of
+// /api/idem-alerts with idempotency keys
+func idempotencyTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	directEndpoints["idem-alerts"] = true
+	t.Cleanup(func() { delete(directEndpoints, "idem-alerts") })
+	r := gin.New()
+	r.POST("/api/idem-alerts", idempotency(), directProxy)
+	return r
+}
+
+func TestIdempotencyKeyReplaysFirstResponse(t *testing.T) {
+	var created atomic.Int64
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":` + strconv.FormatInt(created.Add(1), 10) + `}`))
+	})
+	server := newTestRedis(t)
+	setForTest(t, &backendMaxRetries, 0)
+	setForTest(t, &idempotencyTTL, time.Minute)
+	r := idempotencyTestRouter(t)
+	post := func(idempotencyKey, apiKey, body string) (int, string, bool) {
+		header := http.Header{"Idempotency-Key": {idempotencyKey}, "X-API-Key": {apiKey}}
+		w := serve(r, http.MethodPost, "/api/idem-alerts", header, body)
+		return w.Code, w.Body.String(), w.Header().Get("Idempotent-Replayed") == "true"
+	}
+
+	if status, body, replayed := post("k1", "client-a", `{"symbol":"BTC"}`); status != http.StatusCreated || body != `{"id":1}` || replayed {
+		t.Fatalf("first request: %d %s, replayed %v", status, body, replayed)
+	}
+	if status, body, replayed := post("k1", "client-a", `{"symbol":"BTC"}`); status != http.StatusCreated || body != `{"id":1}` || !replayed {
+		t.Errorf("retry: %d %s, replayed %v, want the original response replayed", status, body, replayed)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want the retry not forwarded", hits)
+	}
+	keys := server.Keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], namespacedKey("idempotency:")) || server.TTL(keys[0]) != time.Minute {
+		t.Errorf("Redis keys %v, want the response stored for IDEMPOTENCY_TTL", keys)
+	}
+
+	if status, body, _ := post("k2", "client-a", `{"symbol":"BTC"}`); status != http.StatusCreated || body != `{"id":2}` {
+		t.Errorf("new key: %d %s, want a new response", status, body)
+	}
+	if status, body, _ := post("k1", "client-b", `{"symbol":"BTC"}`); body != `{"id":3}` {
+		t.Errorf("same key from another client: %d %s, want a new response", status, body)
+	}
+	if status, _, _ := post("k1", "client-a", `{"symbol":"ETH"}`); status != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another body: status %d, want 422", status)
+	}
+	if status, body, _ := post("", "client-a", `{"symbol":"BTC"}`); body != `{"id":4}` {
+		t.Errorf("no key: %d %s, want the request forwarded", status, body)
+	}
+
+	post("k3", "client-a", "fail")
+	if status, _, replayed := post("k3", "client-a", "fail"); status != http.StatusInternalServerError || replayed {
+		t.Errorf("retry of a server error: status %d, replayed %v, want it forwarded again", status, replayed)
+	}
+
+	server.FastForward(time.Minute)
+	if status, body, replayed := post("k1", "client-a", `{"symbol":"BTC"}`); body != `{"id":5}` || replayed {
+		t.Errorf("after IDEMPOTENCY_TTL: %d %s, replayed %v, want the request forwarded", status, body, replayed)
+	}
+}
+
+func TestIdempotencyKeyRejectsConcurrentAndInvalidKeys(t *testing.T) {
+	release := make(chan struct{})
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	})
+	server := newTestRedis(t)
+	r := idempotencyTestRouter(t)
+	header := http.Header{"Idempotency-Key": {"k1"}}
+	key := idempotencyCacheKey(&gin.Context{Request: newRequest(http.MethodPost, "/api/idem-alerts", header, "")}, "k1")
+
+	done := make(chan int)
+	go func() { done <- serve(r, http.MethodPost, "/api/idem-alerts", header, "{}").Code }()
+	waitFor(t, func() bool { return server.Exists(key + ":lock") })
+	w := serve(r, http.MethodPost, "/api/idem-alerts", header, "{}")
+	if w.Code != http.StatusConflict || errorCode(t, w) != "idempotency_key_in_progress" {
+		t.Errorf("concurrent retry: status %d, want 409", w.Code)
+	}
+	close(release)
+	if status := <-done; status != http.StatusCreated {
+		t.Errorf("first request: status %d", status)
+	}
+	if server.Exists(key + ":lock") {
+		t.Error("in-flight marker kept after the request finished")
+	}
+
+	// A request in flight on another gateway holds the marker too, until
+	// it expires
+	header = http.Header{"Idempotency-Key": {"k2"}}
+	key = idempotencyCacheKey(&gin.Context{Request: newRequest(http.MethodPost, "/api/idem-alerts", header, "")}, "k2")
+	server.Set(key+":lock", "1")
+	server.SetTTL(key+":lock", idempotencyLockTTL)
+	if w := serve(r, http.MethodPost, "/api/idem-alerts", header, "{}"); w.Code != http.StatusConflict {
+		t.Errorf("retry held by another gateway: status %d, want 409", w.Code)
+	}
+	server.FastForward(idempotencyLockTTL)
+	if w := serve(r, http.MethodPost, "/api/idem-alerts", header, "{}"); w.Code != http.StatusCreated {
+		t.Errorf("retry after the marker expired: status %d, want 201", w.Code)
+	}
+
+	long := http.Header{"Idempotency-Key": {strings.Repeat("k", maxIdempotencyKeyLength+1)}}
+	if w := serve(r, http.MethodPost, "/api/idem-alerts", long, "{}"); w.Code != http.StatusBadRequest {
+		t.Errorf("oversize key: status %d, want 400", w.Code)
+	}
+}
+
+func TestIdempotencyKeyInFlightWithMemoryBackend(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusCreated) })
+	r := idempotencyTestRouter(t)
+	header := http.Header{"Idempotency-Key": {"k1"}}
+	key := idempotencyCacheKey(&gin.Context{Request: newRequest(http.MethodPost, "/api/idem-alerts", header, "")}, "k1")
+
+	idempotencyInFlight.Store(key, struct{}{})
+	if w := serve(r, http.MethodPost, "/api/idem-alerts", header, "{}"); w.Code != http.StatusConflict {
+		t.Errorf("concurrent retry: status %d, want 409", w.Code)
+	}
+	idempotencyInFlight.Delete(key)
+	if w := serve(r, http.MethodPost, "/api/idem-alerts", header, "{}"); w.Code != http.StatusCreated {
+		t.Errorf("after the first request: status %d, want 201", w.Code)
+	}
+	if _, running := idempotencyInFlight.Load(key); running {
+		t.Error("in-flight key kept after the request finished")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseCIDRs parses a comma-separated list of CIDRs or bare IPs, skipping
+// and logging invalid entries
+func parseCIDRs(name, value string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				slog.Warn("Ignoring invalid IP", "name", name, "value", s, "error", err)
+				continue
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			slog.Warn("Ignoring invalid CIDR", "name", name, "value", s, "error", err)
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// trustedProxyCIDRs are the proxies from the comma-separated
+// TRUSTED_PROXY_CIDRS env var, whose X-Forwarded-For headers are used to
+// determine client IPs. No proxies are trusted by default.
+var trustedProxyCIDRs = parseCIDRs("TRUSTED_PROXY_CIDRS", getEnv("TRUSTED_PROXY_CIDRS", ""))
+
+// trustedProxies returns trustedProxyCIDRs in the form gin expects
+func trustedProxies() []string {
+	var proxies []string
+	for _, prefix := range trustedProxyCIDRs {
+		proxies = append(proxies, prefix.String())
+	}
+	return proxies
+}
+
+// adminIPAllowlist creates a middleware that rejects requests from client
+// IPs outside the comma-separated ADMIN_ALLOWED_CIDRS env var. All IPs are
+// allowed when it is not set.
+func adminIPAllowlist() gin.HandlerFunc {
+	allowed := parseCIDRs("ADMIN_ALLOWED_CIDRS", getEnv("ADMIN_ALLOWED_CIDRS", ""))
+
+	return func(c *gin.Context) {
+		if len(allowed) == 0 {
+			c.Next()
+			return
+		}
+		if !ipAllowed(c.ClientIP(), allowed) {
+			slog.Warn("Rejected admin request from disallowed IP", "client_ip", c.ClientIP(), "path", c.Request.URL.Path)
+			errorResponse(c, http.StatusForbidden, "forbidden", "forbidden")
+			return
+		}
+		c.Next()
+	}
+}
+
+// ipAllowed reports whether ip is within any of the allowed prefixes
+func ipAllowed(ip string, allowed []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminIPAllowlist(t *testing.T) {
+	t.Setenv("ADMIN_ALLOWED_CIDRS", "10.0.0.0/8, 192.0.2.7, not-an-ip")
+	setForTest(t, &trustedProxyCIDRs, parseCIDRs("TRUSTED_PROXY_CIDRS", "203.0.113.1"))
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		t.Fatal(err)
+	}
+	r.Group("/admin", adminIPAllowlist()).GET("/status", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, tc := range []struct {
+		name, remote, forwardedFor string
+		status                     int
+	}{
+		{"allowed range", "10.1.2.3", "", http.StatusOK},
+		{"allowed IP", "192.0.2.7", "", http.StatusOK},
+		{"denied IP", "198.51.100.1", "", http.StatusForbidden},
+		{"spoofed XFF from untrusted client", "198.51.100.1", "10.0.0.1", http.StatusForbidden},
+		{"trusted proxy for allowed client", "203.0.113.1", "10.0.0.1", http.StatusOK},
+		{"trusted proxy for denied client", "203.0.113.1", "198.51.100.1", http.StatusForbidden},
+		{"trusted proxy itself", "203.0.113.1", "", http.StatusForbidden},
+	} {
+		req := newRequest(http.MethodGet, "/admin/status", nil, "")
+		req.RemoteAddr = tc.remote + ":41000"
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		if w := serveRequest(r, req); w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+}
+
+func TestAdminIPAllowlistAllowsAllWhenUnset(t *testing.T) {
+	t.Setenv("ADMIN_ALLOWED_CIDRS", "")
+	r := gin.New()
+	r.GET("/admin/status", adminIPAllowlist(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	if w := get(r, "/admin/status"); w.Code != http.StatusOK {
+		t.Errorf("status %d without ADMIN_ALLOWED_CIDRS, want 200", w.Code)
+	}
+}
+
+func TestIPAllowedUnmapsIPv4InIPv6(t *testing.T) {
+	allowed := parseCIDRs("test", "10.0.0.0/8")
+	if !ipAllowed("::ffff:10.0.0.1", allowed) || ipAllowed("garbage", allowed) {
+		t.Error("ipAllowed should match IPv4-mapped addresses and reject invalid ones")
+	}
+}
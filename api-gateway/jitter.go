@@ -0,0 +1,21 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// ttlJitterPercent spreads cache TTLs by up to ± this percentage so entries
+// written together don't all expire together, from the CACHE_TTL_JITTER env
+// var. Jitter is disabled by default.
+var ttlJitterPercent = getEnvInt("CACHE_TTL_JITTER", 0)
+
+// jitterTTL returns ttl randomly adjusted by up to ±ttlJitterPercent
+func jitterTTL(ttl time.Duration) time.Duration {
+	percent := min(max(ttlJitterPercent, 0), 100)
+	spread := int64(ttl) * int64(percent) / 100
+	if spread <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(2*spread+1)-spread)
+}
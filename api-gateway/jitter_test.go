@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJitterTTLStaysWithinBand(t *testing.T) {
+	setForTest(t, &ttlJitterPercent, 10)
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 1000; i++ {
+		ttl := jitterTTL(5 * time.Minute)
+		if ttl < 270*time.Second || ttl > 330*time.Second {
+			t.Fatalf("jitterTTL(5m) = %s, want within ±10%%", ttl)
+		}
+		seen[ttl] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("jitterTTL gave %d distinct TTLs in 1000 calls, want them spread out", len(seen))
+	}
+
+	setForTest(t, &ttlJitterPercent, 0)
+	if ttl := jitterTTL(5 * time.Minute); ttl != 5*time.Minute {
+		t.Errorf("jitterTTL without jitter = %s, want 5m", ttl)
+	}
+}
+
+func TestCachedEntriesGetJitteredTTLs(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &ttlJitterPercent, 20)
+	r := cachedTestRouter("jitter", 100*time.Second, 0)
+
+	distinct := map[int]bool{}
+	for i := 0; i < 50; i++ {
+		query := "q=" + strconv.Itoa(i)
+		get(r, "/api/jitter?"+query)
+		_, ttl, err := cacheStore.Get(context.Background(), namespacedKey("cache:jitter:"+query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl < 79*time.Second || ttl > 120*time.Second {
+			t.Errorf("%s cached for %s, want 80s to 120s", query, ttl)
+		}
+		distinct[int(ttl.Seconds())] = true
+	}
+	if len(distinct) < 5 {
+		t.Errorf("50 entries expire in %d distinct seconds, want them spread out", len(distinct))
+	}
+}
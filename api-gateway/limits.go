@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	maxRequestBytes  = int64(getEnvInt("MAX_REQUEST_BYTES", 1<<20))
+	maxResponseBytes = int64(getEnvInt("MAX_RESPONSE_BYTES", 10<<20))
+)
+
+// errResponseTooLarge is returned when a backend response exceeds MAX_RESPONSE_BYTES
+var errResponseTooLarge = errors.New("backend response too large")
+
+// limitRequestBody creates a middleware that rejects request bodies larger
+// than MAX_REQUEST_BYTES with 413
+func limitRequestBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxRequestBytes {
+			errorResponse(c, http.StatusRequestEntityTooLarge, "request_too_large", "request body too large")
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxRequestBytes)
+		c.Next()
+	}
+}
+
+// isRequestTooLarge reports whether err came from reading an oversize request body
+func isRequestTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// readRequestBody reads the whole request body, responding with 413 if it
+// exceeds MAX_REQUEST_BYTES or 400 if it can't be read. The body is never
+// nil when ok.
+func readRequestBody(c *gin.Context) ([]byte, bool) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		if isRequestTooLarge(err) {
+			errorResponse(c, http.StatusRequestEntityTooLarge, "request_too_large", "request body too large")
+			return nil, false
+		}
+		errorResponse(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Error reading request: %v", err))
+		return nil, false
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return body, true
+}
+
+// readResponseBody reads a backend response body, failing with
+// errResponseTooLarge if it exceeds MAX_RESPONSE_BYTES
+func readResponseBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxResponseBytes {
+		return nil, fmt.Errorf("%w (limit %d bytes)", errResponseTooLarge, maxResponseBytes)
+	}
+	return body, nil
+}
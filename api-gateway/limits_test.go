@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestOversizeRequestBodyIsRejected(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &maxRequestBytes, 16)
+	directTestRouter(t, "limits-request")
+	r := gin.New()
+	r.Use(limitRequestBody())
+	r.POST("/api/limits-request", directProxy)
+
+	if w := serve(r, http.MethodPost, "/api/limits-request", nil, `{"a":1}`); w.Code != http.StatusOK {
+		t.Errorf("small body: status %d, want 200", w.Code)
+	}
+
+	oversize := strings.Repeat("x", 17)
+	if w := serve(r, http.MethodPost, "/api/limits-request", nil, oversize); w.Code != http.StatusRequestEntityTooLarge || errorCode(t, w) != "request_too_large" {
+		t.Errorf("oversize body: status %d, body %s, want 413", w.Code, w.Body)
+	}
+
+	// Without a Content-Length the limit applies while reading
+	req := newRequest(http.MethodPost, "/api/limits-request", nil, oversize)
+	req.ContentLength = -1
+	if w := serveRequest(r, req); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversize chunked body: status %d, want 413", w.Code)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1 as oversize bodies are not proxied", hits)
+	}
+}
+
+func TestOversizeBackendResponseIsNotCached(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"news":"`+strings.Repeat("x", 64)+`"}`))
+	setForTest(t, &maxResponseBytes, 32)
+	setForTest(t, &backendMaxRetries, 0)
+	r := cachedTestRouter("limits-response", time.Minute, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		if w := get(r, "/api/limits-response"); w.Code != http.StatusBadGateway {
+			t.Errorf("request %d: status %d, want 502", i+1, w.Code)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2 as the oversize response is not cached", hits)
+	}
+}
+
+func TestMaxCacheBytesPerEndpoint(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		jsonBackend(`{"news":"`+strings.Repeat("x", len(r.URL.Query().Get("size")))+`"}`)(w, r)
+	})
+	t.Setenv("MAX_CACHE_BYTES_MAXBYTES_ENV", "20")
+	routes, err := validateRoutes([]routeConfig{
+		{Endpoint: "maxbytes-news", TTL: "1m", Cache: true, MaxCacheBytes: 20},
+		{Endpoint: "maxbytes-env", TTL: "1m", Cache: true},
+		{Endpoint: "maxbytes-none", TTL: "1m", Cache: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	registerRoutes(r, routes)
+
+	for _, tc := range []struct {
+		target string
+		cache  []string
+	}{
+		{"/api/maxbytes-news?size=small", []string{"MISS", "HIT"}},
+		{"/api/maxbytes-news?size=muchtoolargetocache", []string{"TOO-LARGE", "TOO-LARGE"}},
+		{"/api/maxbytes-env?size=small", []string{"MISS", "HIT"}},
+		{"/api/maxbytes-env?size=muchtoolargetocache", []string{"TOO-LARGE", "TOO-LARGE"}},
+		{"/api/maxbytes-none?size=muchtoolargetocache", []string{"MISS", "HIT"}},
+	} {
+		for i, want := range tc.cache {
+			w := get(r, tc.target)
+			if w.Code != http.StatusOK || w.Header().Get("X-Cache") != want {
+				t.Errorf("%s request %d: status %d, X-Cache %q, want 200 %s", tc.target, i+1, w.Code, w.Header().Get("X-Cache"), want)
+			}
+		}
+	}
+	if cacheHas(namespacedKey("cache:maxbytes-news:size=muchtoolargetocache")) {
+		t.Error("oversize body cached")
+	}
+	if hits := backend.hits.Load(); hits != 7 {
+		t.Errorf("backend hit %d times, want 7", hits)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logLevelVar holds the active slog level
+var logLevelVar = new(slog.LevelVar)
+
+// validRequestID matches client-supplied request IDs that are safe to echo
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// sampledLog collapses repeated identical log lines, such as one per request
+// while the backend is down, into one line per LOG_SAMPLE_INTERVAL
+var sampledLog = newSampledLogger(getEnvDuration("LOG_SAMPLE_INTERVAL", 10*time.Second))
+
+// maxSampledLines bounds the distinct lines tracked by a sampledLogger
+const maxSampledLines = 1000
+
+// sampledLogger logs the first occurrence of a line, then drops identical
+// lines for interval and logs the next one with the number dropped. An
+// interval of zero logs every line.
+type sampledLogger struct {
+	interval time.Duration
+	mu       sync.Mutex
+	lines    map[string]*sampledLine
+}
+
+// sampledLine tracks when a line was last logged and how many repeats were
+// dropped since
+type sampledLine struct {
+	loggedAt   time.Time
+	suppressed int
+}
+
+// newSampledLogger creates a sampledLogger for interval
+func newSampledLogger(interval time.Duration) *sampledLogger {
+	return &sampledLogger{interval: interval, lines: map[string]*sampledLine{}}
+}
+
+// Warn logs a sampled line at warn level
+func (l *sampledLogger) Warn(msg string, args ...any) {
+	l.log(slog.LevelWarn, msg, args...)
+}
+
+// Error logs a sampled line at error level
+func (l *sampledLogger) Error(msg string, args ...any) {
+	l.log(slog.LevelError, msg, args...)
+}
+
+// log logs msg unless an identical line was logged within the interval,
+// adding a suppressed count when repeats were dropped
+func (l *sampledLogger) log(level slog.Level, msg string, args ...any) {
+	if l.interval <= 0 {
+		slog.Log(context.Background(), level, msg, args...)
+		return
+	}
+
+	key := level.String() + " " + msg + " " + fmt.Sprint(args...)
+	now := time.Now()
+
+	l.mu.Lock()
+	line, ok := l.lines[key]
+	if ok && now.Sub(line.loggedAt) < l.interval {
+		line.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := 0
+	if ok {
+		suppressed = line.suppressed
+	} else if len(l.lines) >= maxSampledLines {
+		l.prune(now)
+	}
+	l.lines[key] = &sampledLine{loggedAt: now}
+	l.mu.Unlock()
+
+	if suppressed > 0 {
+		args = append(args, "suppressed", suppressed)
+	}
+	slog.Log(context.Background(), level, msg, args...)
+}
+
+// prune forgets lines last logged over an interval ago, or all of them if
+// none are that old; the caller must hold mu
+func (l *sampledLogger) prune(now time.Time) {
+	for key, line := range l.lines {
+		if now.Sub(line.loggedAt) >= l.interval {
+			delete(l.lines, key)
+		}
+	}
+	if len(l.lines) >= maxSampledLines {
+		l.lines = map[string]*sampledLine{}
+	}
+}
+
+// setupLogger installs a JSON slog logger at the level named by level
+func setupLogger(level string) {
+	logLevelVar.Set(parseLogLevel(level))
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: logLevelVar})))
+}
+
+// logLevels maps the accepted LOG_LEVEL values to slog levels
+var logLevels = map[string]slog.Level{
+	"DEBUG":    slog.LevelDebug,
+	"INFO":     slog.LevelInfo,
+	"WARN":     slog.LevelWarn,
+	"WARNING":  slog.LevelWarn,
+	"ERROR":    slog.LevelError,
+	"CRITICAL": slog.LevelError,
+}
+
+// parseLogLevel maps a LOG_LEVEL value to a slog level, defaulting to info
+func parseLogLevel(level string) slog.Level {
+	if l, ok := logLevels[strings.ToUpper(level)]; ok {
+		return l
+	}
+	return slog.LevelInfo
+}
+
+// setLogLevel changes the slog level at runtime, and silences gin's debug
+// output above info like at startup
+func setLogLevel(level slog.Level) {
+	logLevelVar.Set(level)
+	if level > slog.LevelInfo {
+		gin.DefaultWriter = io.Discard
+	} else {
+		gin.DefaultWriter = os.Stdout
+	}
+}
+
+// requestID creates a middleware that assigns each request an ID, reusing a
+// valid incoming X-Request-ID, and echoes it in the response
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader("X-Request-ID")
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header("X-Request-ID", id)
+		c.Next()
+	}
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger creates a middleware that writes one structured log line per
+// request with its ID, endpoint, cache status, backend latency and status
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		attrs := []any{
+			"request_id", c.GetString("request_id"),
+			"method", c.Request.Method,
+			"endpoint", c.Request.URL.Path,
+			"query", c.Request.URL.RawQuery,
+			"status", c.Writer.Status(),
+			"duration_ms", msSince(start),
+		}
+		if cacheStatus := c.Writer.Header().Get("X-Cache"); cacheStatus != "" {
+			attrs = append(attrs, "cache", cacheStatus)
+		}
+		if tenantID := c.GetString("tenant_id"); tenantID != "" {
+			attrs = append(attrs, "tenant", tenantID)
+		}
+		if latency, ok := c.Get("backend_latency"); ok {
+			attrs = append(attrs, "backend_ms", float64(latency.(time.Duration).Microseconds())/1000)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		slog.Info("request", attrs...)
+	}
+}
+
+// msSince returns the milliseconds elapsed since start
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
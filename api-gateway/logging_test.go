@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logCapture collects the JSON log lines written while a test runs
+type logCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write implements io.Writer for the capturing slog handler
+func (l *logCapture) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+// lines returns the captured log lines with message msg
+func (l *logCapture) lines(t *testing.T, msg string) []map[string]any {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var lines []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(l.buf.String()), "\n") {
+		if raw == "" {
+			continue
+		}
+		var line map[string]any
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", raw, err)
+		}
+		if line["msg"] == msg {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// captureLogs sends the default slog logger's output at level and above to
+// the returned logCapture for the rest of the test
+func captureLogs(t *testing.T, level slog.Leveler) *logCapture {
+	t.Helper()
+	logs := &logCapture{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: level})))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return logs
+}
+
+func TestRequestLogIncludesRequestID(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	logs := captureLogs(t, slog.LevelInfo)
+	r := gin.New()
+	r.Use(requestID(), requestLogger())
+	r.GET("/api/logging-cached", cachedProxy("logging-cached", time.Minute, time.Minute, 0))
+
+	w := get(r, "/api/logging-cached")
+	id := w.Header().Get("X-Request-ID")
+	if !validRequestID.MatchString(id) {
+		t.Fatalf("X-Request-ID %q", id)
+	}
+	lines := logs.lines(t, "request")
+	if len(lines) != 1 {
+		t.Fatalf("got %d request log lines, want 1", len(lines))
+	}
+	line := lines[0]
+	if line["request_id"] != id || line["endpoint"] != "/api/logging-cached" || line["cache"] != "MISS" || line["status"] != float64(http.StatusOK) {
+		t.Errorf("request log line %v, want request_id %s, the endpoint, cache MISS and status 200", line, id)
+	}
+	if _, ok := line["backend_ms"]; !ok {
+		t.Errorf("request log line %v has no backend latency", line)
+	}
+
+	w = serve(r, http.MethodGet, "/api/logging-cached", http.Header{"X-Request-ID": {"client-id-1"}}, "")
+	if got := w.Header().Get("X-Request-ID"); got != "client-id-1" {
+		t.Errorf("X-Request-ID %q, want the client's", got)
+	}
+	if lines = logs.lines(t, "request"); len(lines) != 2 || lines[1]["request_id"] != "client-id-1" {
+		t.Errorf("request log lines %v, want the second with the client's request ID", lines)
+	}
+}
+
+func TestRequestIDRejectsUnsafeIDs(t *testing.T) {
+	r := gin.New()
+	r.Use(requestID())
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := serve(r, http.MethodGet, "/", http.Header{"X-Request-ID": {"bad id\n"}}, "")
+	if id := w.Header().Get("X-Request-ID"); id == "bad id\n" || !validRequestID.MatchString(id) {
+		t.Errorf("X-Request-ID %q, want a generated ID", id)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for level, want := range map[string]slog.Level{"debug": slog.LevelDebug, "WARNING": slog.LevelWarn, "critical": slog.LevelError, "bogus": slog.LevelInfo} {
+		if got := parseLogLevel(level); got != want {
+			t.Errorf("parseLogLevel(%q) = %v, want %v", level, got, want)
+		}
+	}
+}
+
+func TestSampledLoggerCollapsesRepeats(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	logger := newSampledLogger(50 * time.Millisecond)
+
+	for i := 0; i < 100; i++ {
+		logger.Warn("Backend failed", "backend", "http://a")
+	}
+	logger.Warn("Backend failed", "backend", "http://b")
+	logger.Error("Backend failed", "backend", "http://a")
+	if lines := logs.lines(t, "Backend failed"); len(lines) != 3 {
+		t.Fatalf("logged %d lines, want one per distinct line", len(lines))
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	logger.Warn("Backend failed", "backend", "http://a")
+	lines := logs.lines(t, "Backend failed")
+	if len(lines) != 4 || lines[3]["backend"] != "http://a" || lines[3]["suppressed"] != 99.0 {
+		t.Errorf("after the interval logged %v, want a line with 99 suppressed", lines[len(lines)-1])
+	}
+}
+
+func TestSampledLoggerWithoutInterval(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	logger := newSampledLogger(0)
+	for i := 0; i < 5; i++ {
+		logger.Warn("Backend failed", "backend", "http://a")
+	}
+	if lines := logs.lines(t, "Backend failed"); len(lines) != 5 {
+		t.Errorf("logged %d lines, want every line without an interval", len(lines))
+	}
+}
+
+func TestBackendOutageLogsAreSampled(t *testing.T) {
+	down := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	up := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &backends, parseBackends(down.URL+","+up.URL))
+	setForTest(t, &backendFailureThreshold, 1000)
+	setForTest(t, &sampledLog, newSampledLogger(time.Minute))
+	logs := captureLogs(t, slog.LevelInfo)
+	r := directTestRouter(t, "sampled-direct", http.MethodGet)
+
+	for i := 0; i < 20; i++ {
+		if w := get(r, "/api/sampled-direct"); w.Code != http.StatusOK {
+			t.Fatalf("status %d, want the next backend's response", w.Code)
+		}
+	}
+	if lines := logs.lines(t, "Backend returned error status, trying next"); len(lines) != 1 {
+		t.Errorf("logged the outage %d times over 20 requests, want once", len(lines))
+	}
+	if hits := down.hits.Load(); hits != 20 {
+		t.Errorf("failing backend got %d requests, want 20", hits)
+	}
+}
@@ -2,47 +2,71 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
 var (
 	backendURL = getEnv("BACKEND_URL", "http://backend:5000")
 	redisURL   = getEnv("REDIS_URL", "redis://redis:6379/0")
 	logLevel   = getEnv("LOG_LEVEL", "INFO")
-	ctx        = context.Background()
-	rdb        *redis.Client
+	rdb        redis.UniversalClient // nil unless CACHE_BACKEND is redis
+
+	// corsOrigins are the CORS origins, also used for websocket origin checks
+	corsOrigins = newOriginMatcher(nil)
+
+	// backendClient is shared by all proxied backend calls
+	backendClient = &http.Client{
+		Transport: newBackendTransport(),
+		Timeout:   getEnvDuration("BACKEND_TIMEOUT", 30*time.Second),
+	}
 )
 
 func init() {
 	// Configure logging based on LOG_LEVEL
 	configureLogging()
+	setupTracing()
+}
 
-	// Parse Redis URL and create client
-	opt, err := redis.ParseURL(redisURL)
+// setupCache creates the cache for CACHE_BACKEND and checks that it is
+// reachable, exiting if it isn't. It runs at startup rather than in init so
+// tests can use a cache of their own.
+func setupCache() {
+	backend := getEnv("CACHE_BACKEND", "redis")
+	store, err := newCache(backend)
 	if err != nil {
-		log.Fatalf("Error parsing Redis URL: %v", err)
+		slog.Error("Error configuring cache", "backend", backend, "error", err)
+		os.Exit(1)
 	}
-	rdb = redis.NewClient(opt)
+	cacheStore = store
 
-	// Test Redis connection
-	_, err = rdb.Ping(ctx).Result()
-	if err != nil {
-		log.Fatalf("Error connecting to Redis: %v", err)
+	// Test the cache connection
+	if err := cacheStore.Ping(context.Background()); err != nil {
+		slog.Error("Error connecting to cache", "backend", backend, "error", err)
+		os.Exit(1)
 	}
-	log.Println("Connected to Redis successfully")
+	slog.Info("Connected to cache successfully", "backend", backend)
 }
 
-// configureLogging sets up logging based on LOG_LEVEL
+// configureLogging sets up structured logging based on LOG_LEVEL
 func configureLogging() {
 	// Set Gin mode based on GIN_MODE env var
 	ginMode := getEnv("GIN_MODE", "debug")
@@ -50,153 +74,891 @@ func configureLogging() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Default to minimal logging for production
-	if strings.ToUpper(logLevel) == "ERROR" || strings.ToUpper(logLevel) == "WARN" ||
-		strings.ToUpper(logLevel) == "WARNING" || strings.ToUpper(logLevel) == "CRITICAL" {
-		// Disable debug logging for production
-		gin.DefaultWriter = io.Discard
-		log.Printf("Log level set to %s - detailed logs disabled", logLevel)
+	setupLogger(logLevel)
+
+	// Default to minimal logging for production, without gin debug output
+	setLogLevel(logLevelVar.Level())
+	if logLevelVar.Level() > slog.LevelInfo {
+		slog.Warn("Detailed logs disabled", "log_level", logLevel)
 	} else {
-		log.Printf("Log level set to %s - detailed logs enabled", logLevel)
+		slog.Info("Detailed logs enabled", "log_level", logLevel)
 	}
 }
 
 func main() {
-	r := gin.Default()
+	setupCache()
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		slog.Error("Error setting trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	r.Use(recoverPanics(), requestID(), requestLogger(), serverTiming(), tracingMiddleware(), limitRequestBody())
+	r.NoRoute(notFound)
 
-	// Configure CORS for both development and production
-	corsConfig := cors.DefaultConfig()
+	// Prometheus metrics, registered before CORS so it is not applied
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Get allowed origins from environment variable or use defaults
-	allowedOriginsStr := getEnv("ALLOWED_ORIGINS", "")
-	var allowedOrigins []string
+	corsOrigins = loadCORSOrigins()
+	r.Use(corsMiddleware(corsOrigins))
+	r.Use(compressResponses())
+	r.Use(prettyJSON())
+	r.Use(logBodies())
+	r.Use(startupGate())
+	r.Use(maintenanceGate())
+	tenants, err := loadTenants()
+	if err != nil {
+		slog.Error("Error loading tenants", "error", err)
+		os.Exit(1)
+	}
+	r.Use(apiKeyAuth(tenants))
+	r.Use(rateLimit(tenants))
 
-	if allowedOriginsStr != "" {
-		// Split comma-separated list of allowed origins
-		allowedOrigins = strings.Split(allowedOriginsStr, ",")
-		log.Printf("Using configured allowed origins: %v", allowedOrigins)
-	} else {
-		// Use development defaults
-		allowedOrigins = []string{
-			"http://localhost:3000",
-			"http://localhost:8080",
-		}
-		log.Printf("Using default development allowed origins: %v", allowedOrigins)
+	// Set up routes
+	routes, err := loadRoutes()
+	if err != nil {
+		slog.Error("Error loading routes", "error", err)
+		os.Exit(1)
 	}
+	registerRoutes(r, routes)
+	registerV2Routes(r)
+	r.GET("/api/batch", batchHandler) // Sub-resources are cached individually
 
-	corsConfig.AllowOrigins = allowedOrigins
-	corsConfig.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	corsConfig.AllowHeaders = []string{"Origin", "Content-Type", "Accept", "Authorization"}
-	corsConfig.ExposeHeaders = []string{"Content-Length"}
-	corsConfig.AllowCredentials = true
-	corsConfig.MaxAge = 12 * time.Hour
+	// Live streaming of cached payloads
+	r.GET("/ws/prices", cacheStream("prices"))
+	r.GET("/ws/predictions", cacheStream("predictions"))
 
-	r.Use(cors.New(corsConfig))
+	// Admin endpoints
+	registerAdminRoutes(r)
 
-	// Set up routes
-	r.GET("/api/prices", cachedProxy("prices", 5*time.Minute))
-	r.GET("/api/news", cachedProxy("news", 5*time.Minute))
-	r.GET("/api/predictions", cachedProxy("predictions", 15*time.Minute))
-	r.GET("/api/accuracy", cachedProxy("accuracy", 1*time.Hour))
-	r.GET("/api/advanced-insights", cachedProxy("advanced-insights", 10*time.Minute))
-	r.GET("/api/test-connectivity", directProxy) // Don't cache test endpoints
-	r.GET("/api/test-eventregistry", directProxy)
-	r.GET("/api/test-openai", directProxy)
-
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"time":   time.Now().Format(time.RFC3339),
-		})
-	})
+	// Refresh hot cache keys before they expire
+	startRefreshAhead()
+
+	// Health check endpoint, optionally reporting the probed backend status
+	startBackendHealthChecks()
+	r.GET("/health", healthCheck)
+
+	// Readiness check endpoint, verifies the cache and the backend
+	r.GET("/ready", readinessCheck)
+
+	// Optionally restore the cache persisted before the last restart and
+	// keep persisting it
+	startCacheSnapshots()
+
+	// Optionally pre-fetch cached endpoints while the server starts, only
+	// serving /api requests once warmup is done
+	if getEnvBool("CACHE_WARM", false) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), getEnvDuration("CACHE_WARM_TIMEOUT", 30*time.Second))
+			defer cancel()
+			warmCache(ctx)
+			started.Store(true)
+		}()
+	} else {
+		started.Store(true)
+	}
 
 	// Start server
 	port := getEnv("PORT", "8080")
-	log.Printf("Starting API gateway on port %s", port)
-	if err := r.Run(fmt.Sprintf(":%s", port)); err != nil {
-		log.Fatalf("Error starting server: %v", err)
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: r,
+	}
+
+	// Serve HTTPS when both TLS_CERT_FILE and TLS_KEY_FILE are set,
+	// reloading the certificate when the files are renewed
+	certFile, keyFile := getEnv("TLS_CERT_FILE", ""), getEnv("TLS_KEY_FILE", "")
+	useTLS := certFile != "" && keyFile != ""
+	if useTLS {
+		certs, err := newCertReloader(certFile, keyFile)
+		if err != nil {
+			slog.Error("Error loading TLS certificate", "error", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig = certs.tlsConfig()
+		go certs.watch(tlsReloadInterval, nil)
 	}
+
+	go func() {
+		slog.Info("Starting API gateway", "port", port, "tls", useTLS)
+		var err error
+		if useTLS {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			slog.Error("Error starting server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Wait for a termination signal, then drain in-flight requests
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	shutdown(srv, getEnvDuration("SHUTDOWN_TIMEOUT", 15*time.Second))
+}
+
+// shutdown stops the server, giving in-flight requests up to timeout to
+// complete, persists the cache if CACHE_SNAPSHOT is set and closes it
+func shutdown(srv *http.Server, timeout time.Duration) {
+	slog.Info("Shutting down API gateway", "grace_period", timeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error during server shutdown", "error", err)
+		srv.Close()
+	}
+
+	if cacheSnapshotEnabled {
+		saveCacheSnapshot()
+	}
+	if err := cacheStore.Close(); err != nil {
+		slog.Error("Error closing cache", "error", err)
+	}
+	shutdownTracing(shutdownCtx)
+	slog.Info("API gateway stopped")
+}
+
+// cachedRoute registers a cached GET route for endpoint. The TTL can be
+// overridden with a TTL_<ENDPOINT> env var (e.g. TTL_PRICES=2m), and stale
+// entries are served for up to one more TTL while refreshing. Request
+// headers listed in VARY_<ENDPOINT> are part of the cache key, and bodies
+// over MAX_CACHE_BYTES_<ENDPOINT> (default defaultMaxBytes) are not cached. Any
+// middleware runs before the cache is consulted. The caching handler is
+// returned so other routes can share the endpoint's cache.
+func cachedRoute(r gin.IRoutes, endpoint string, defaultTTL time.Duration, defaultMaxBytes int64, middleware ...gin.HandlerFunc) gin.HandlerFunc {
+	ttl := routeTTL(endpoint, defaultTTL)
+	maxBytes := int64(getEnvInt(endpointEnvKey("MAX_CACHE_BYTES_", endpoint), int(defaultMaxBytes)))
+	handler := cachedProxy(endpoint, ttl, ttl, maxBytes, routeVary(endpoint)...)
+	r.GET("/api/"+endpoint, append(middleware, handler)...)
+	return handler
+}
+
+// routeTTL returns the TTL for endpoint from its TTL_<ENDPOINT> env var
+func routeTTL(endpoint string, defaultTTL time.Duration) time.Duration {
+	return getEnvDuration(endpointEnvKey("TTL_", endpoint), defaultTTL)
+}
+
+// routeStaleIfError returns how long past its TTL the last known good
+// response of endpoint may be served when the backend fails, from its
+// STALE_IF_ERROR_<ENDPOINT> env var, falling back to STALE_IF_ERROR. Zero
+// serves it however old it is.
+func routeStaleIfError(endpoint string) time.Duration {
+	return getEnvDuration(endpointEnvKey("STALE_IF_ERROR_", endpoint), getEnvDuration("STALE_IF_ERROR", 0))
+}
+
+// queryTTL returns the TTL and stale window of a request to endpoint with a
+// keyed query, which are ttl and staleWindow unless the endpoint's TTL
+// depends on its query. Both are then the TTL of routeQueryTTLs.
+func queryTTL(endpoint, query string, ttl, staleWindow time.Duration) (time.Duration, time.Duration) {
+	if ttlFor, ok := routeQueryTTLs[endpoint]; ok {
+		ttl = ttlFor(query)
+		return ttl, ttl
+	}
+	return ttl, staleWindow
+}
+
+// endpointEnvKey returns the name of a per-endpoint env var, e.g.
+// TTL_ADVANCED_INSIGHTS for prefix TTL_ and endpoint advanced-insights, or
+// TTL_V2_PRICES for v2/prices
+func endpointEnvKey(prefix, endpoint string) string {
+	return prefix + strings.ToUpper(envKeyReplacer.Replace(endpoint))
+}
+
+// envKeyReplacer maps endpoint names to env var name characters
+var envKeyReplacer = strings.NewReplacer("-", "_", "/", "_")
+
+// cacheEntry is the envelope stored in the cache for each cached response
+type cacheEntry struct {
+	Body    []byte `json:"body"`
+	Gzipped bool   `json:"gzipped"`
+
+	// Encoding is the algorithm Body is compressed with, gzip or zstd, or
+	// empty if it is stored as-is. Gzipped is set too for gzip so older
+	// gateways can still read the entry.
+	Encoding string `json:"encoding,omitempty"`
+
+	ContentType string    `json:"content_type"`
+	ETag        string    `json:"etag"`
+	CachedAt    time.Time `json:"cached_at"`
+
+	// BackendETag is the ETag the backend sent with the body, used to
+	// revalidate the entry instead of refetching it once it is stale
+	BackendETag string `json:"backend_etag,omitempty"`
+
+	// SoftExpiry is when the entry's TTL ends. The stale window and the
+	// stale-if-error window are measured from it.
+	SoftExpiry time.Time `json:"soft_expiry"`
+
+	// LastModified is the backend's Last-Modified time, or when the body
+	// was fetched
+	LastModified time.Time `json:"last_modified"`
+
+	// Status is the backend status of a negatively cached error response,
+	// zero for successful responses
+	Status int `json:"status,omitempty"`
+
+	// ttl is the remaining cache TTL when the entry was read
+	ttl time.Duration
+}
+
+// cacheSettings are the caching parameters of a cached endpoint
+type cacheSettings struct {
+	ttl         time.Duration
+	staleWindow time.Duration
+	vary        []string
+	maxBytes    int64 // largest cacheable body, or 0 for no limit
+
+	// warmable is set unless the endpoint needs query params, in which case
+	// its default variant is neither warmed, batched nor streamed
+	warmable bool
+}
+
+// cachedEndpoints holds the settings of endpoints registered with cachedProxy
+var cachedEndpoints = map[string]cacheSettings{}
+
+// isCachedEndpoint reports whether endpoint is registered with cachedProxy
+func isCachedEndpoint(endpoint string) bool {
+	_, ok := cachedEndpoints[endpoint]
+	return ok
 }
 
-// cachedProxy creates a gin handler that caches responses in Redis
-func cachedProxy(endpoint string, ttl time.Duration) gin.HandlerFunc {
+// isWarmableEndpoint reports whether the default variant of endpoint, with
+// no query params, can be loaded with loadCachedBody
+func isWarmableEndpoint(endpoint string) bool {
+	return cachedEndpoints[endpoint].warmable
+}
+
+// requireQuery marks a cached endpoint as needing query params, so warmup,
+// batches and websocket streams leave it out
+func requireQuery(endpoint string) {
+	settings := cachedEndpoints[endpoint]
+	settings.warmable = false
+	cachedEndpoints[endpoint] = settings
+}
+
+// refreshing tracks cache keys with a background refresh in progress
+var refreshing sync.Map
+
+// fetchGroup collapses concurrent backend fetches for the same cache key
+var fetchGroup singleflight.Group
+
+// cachedProxy creates a gin handler that caches responses in cacheStore.
+// Entries are fresh for ttl and then served stale for up to staleWindow
+// while a single background refresh fetches a new copy. Responses are
+// cached separately for each combination of the vary request headers, and
+// for each tenant cache namespace.
+func cachedProxy(endpoint string, ttl, staleWindow time.Duration, maxBytes int64, vary ...string) gin.HandlerFunc {
+	cachedEndpoints[endpoint] = cacheSettings{ttl: ttl, staleWindow: staleWindow, vary: vary, maxBytes: maxBytes, warmable: true}
+	varyHeader := varyResponseHeader(vary)
+	unkeyed := routeUnkeyedHeaders(endpoint, vary)
+	staleIfError := routeStaleIfError(endpoint)
+
 	return func(c *gin.Context) {
-		// Build cache key from endpoint and query parameters
-		cacheKey := fmt.Sprintf("cache:%s:%s", endpoint, c.Request.URL.RawQuery)
-
-		// Try to get from cache
-		cachedData, err := rdb.Get(ctx, cacheKey).Result()
-		if err == nil {
-			// Cache hit
-			log.Printf("Cache hit for %s", cacheKey)
-			c.Header("X-Cache", "HIT")
-			c.Data(http.StatusOK, "application/json", []byte(cachedData))
+		// Redis and backend calls are cancelled when the client goes away
+		ctx := c.Request.Context()
+
+		// POSTs are fetched with their body and cached by its hash
+		var body []byte
+		header := varyRequestHeader(c.Request.Header, vary)
+		if c.Request.Method == http.MethodPost {
+			var ok bool
+			if body, ok = readRequestBody(c); !ok {
+				return
+			}
+			header = header.Clone()
+			if header == nil {
+				header = http.Header{}
+			}
+			header.Set("Content-Type", c.ContentType())
+		}
+
+		// Build cache key from endpoint and normalized query parameters
+		rawQuery, refresh := cacheRefresh(c)
+		query := keyedQuery(endpoint, normalizeQuery(rawQuery))
+		ttl, staleWindow := queryTTL(endpoint, query, ttl, staleWindow)
+		c.Header("Vary", varyHeader)
+
+		// Requests with a header the backend reads but the key ignores
+		// bypass the cache entirely so they can't poison it
+		if names := presentHeaders(c.Request.Header, unkeyed); len(names) > 0 {
+			proxyUnkeyed(c, endpoint, query, header, body, names, varyHeader)
 			return
 		}
+		cacheKey := cacheKeyFor(endpoint, query) + varyKey(c.Request.Header, vary) + tenantKey(ctx) + bodyKey(header, body)
+		if refreshAhead {
+			hotKeys.record(endpoint, query, header, body, cacheKey, ttl, staleWindow)
+		}
 
-		// Cache miss, proxy the request to the backend
-		targetURL := fmt.Sprintf("%s/api/%s?%s", backendURL, endpoint, c.Request.URL.RawQuery)
-		resp, err := http.Get(targetURL)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error proxying request: %v", err)})
+		// Try to get from cache unless Redis is unavailable or an admin
+		// forced a refresh
+		var entry *cacheEntry
+		found := false
+		if refresh {
+			slog.Info("Forced cache refresh", "key", cacheKey, "request_id", c.GetString("request_id"))
+		} else {
+			lookupStart := time.Now()
+			entry, found = getCacheEntry(ctx, cacheKey)
+			c.Set("cache_latency", time.Since(lookupStart))
+		}
+		if found {
+			cacheHits.WithLabelValues(endpoint).Inc()
+			if entry.Status != 0 {
+				// Negatively cached error, expires without a stale window
+				slog.Debug("Negative cache hit", "key", cacheKey, "status", entry.Status)
+				setCacheStatus(c, endpoint, "HIT-NEGATIVE")
+				serveCacheEntry(c, entry)
+				return
+			}
+			if time.Now().Before(entry.SoftExpiry) {
+				// Cache hit
+				slog.Debug("Cache hit", "key", cacheKey)
+				setCacheStatus(c, endpoint, "HIT")
+				serveCacheEntry(c, entry)
+				return
+			}
+
+			// Soft TTL passed, serve stale and refresh in the background
+			slog.Debug("Serving stale response", "key", cacheKey)
+			refreshInBackground(endpoint, query, header, body, cacheKey, entry, ttl, staleWindow)
+			setCacheStatus(c, endpoint, "STALE")
+			serveCacheEntry(c, entry)
+			return
+		}
+
+		// Cache miss, proxy the request to the backend with the client's
+		// If-Modified-Since so it can answer 304, and its address. Without
+		// it, the last known good copy is revalidated if the backend gave it
+		// an ETag.
+		cacheMisses.WithLabelValues(endpoint).Inc()
+		fetchHeader := withValidators(header, c.Request.Header)
+		var lkg *cacheEntry
+		if validatorKey(fetchHeader) == "" {
+			if entry, ok := getCacheEntry(ctx, lkgKey(cacheKey)); ok {
+				lkg = entry
+				fetchHeader = revalidationHeader(fetchHeader, lkg)
+			}
+		}
+		start := time.Now()
+		resp, err := fetchAndCache(ctx, endpoint, query, withClientIP(c, fetchHeader), body, cacheKey, ttl, staleWindow)
+		c.Set("backend_latency", time.Since(start))
+		if err != nil && ctx.Err() != nil {
+			slog.Info("Client cancelled request", "key", cacheKey, "request_id", c.GetString("request_id"))
+			c.Abort()
 			return
 		}
-		defer resp.Body.Close()
 
-		// Read the response body
-		body, err := io.ReadAll(resp.Body)
+		// The revalidated copy, the client's or the last known good one,
+		// came from the cache, so on a backend 304 the last known good copy
+		// is at least as new and still current
+		if err == nil && resp.status == http.StatusNotModified {
+			if lkg == nil {
+				lkg, _ = getCacheEntry(ctx, lkgKey(cacheKey))
+			}
+			if lkg != nil {
+				slog.Debug("Backend revalidated last known good response", "key", cacheKey)
+				recacheEntry(ctx, cacheKey, lkg, ttl, staleWindow)
+				setCacheStatus(c, endpoint, "REVALIDATED")
+				serveCacheEntry(c, lkg)
+				return
+			}
+		}
+
+		// Fall back to the last known good copy if the backend failed, or
+		// with stale-if-error only while it is within the window past its TTL
+		if err != nil || resp.status >= http.StatusInternalServerError {
+			if entry, ok := getCacheEntry(ctx, lkgKey(cacheKey)); ok {
+				switch {
+				case staleIfError <= 0:
+					sampledLog.Warn("Backend failed, serving last known good response", "key", cacheKey)
+					setCacheStatus(c, endpoint, "STALE-FALLBACK")
+					serveCacheEntry(c, entry)
+					return
+				case time.Now().Before(entry.SoftExpiry.Add(staleIfError)):
+					sampledLog.Warn("Backend failed, serving stale-if-error response", "key", cacheKey)
+					setCacheStatus(c, endpoint, "STALE-IF-ERROR")
+					serveCacheEntry(c, entry)
+					return
+				default:
+					slog.Debug("Last known good response is past its stale-if-error window", "key", cacheKey,
+						"soft_expiry", entry.SoftExpiry)
+				}
+			}
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error reading response: %v", err)})
+			respondBackendError(c, err)
+			return
+		}
+
+		// Set original headers. A relayed 304 keeps the client's validator,
+		// not the backend's.
+		resp.copyHeaders(c)
+		if resp.status == http.StatusNotModified {
+			c.Writer.Header().Del("ETag")
+		}
+		switch {
+		case redisBypassed():
+			setCacheStatus(c, endpoint, "BYPASS")
+		case tooLargeToCache(endpoint, resp.body):
+			setCacheStatus(c, endpoint, "TOO-LARGE")
+		case refresh:
+			setCacheStatus(c, endpoint, "REFRESH")
+		default:
+			setCacheStatus(c, endpoint, "MISS")
+		}
+		c.Header("Vary", varyHeader)
+		if resp.status >= http.StatusBadRequest {
+			respondBackendStatus(c, resp.status, resp.body)
+			return
+		}
+		if resp.status == http.StatusOK && notModified(c, computeETag(resp.body), resp.lastModified()) {
 			return
 		}
+		resp.write(c)
+	}
+}
+
+// cacheKeyFor returns the cache key caching endpoint for a normalized query
+func cacheKeyFor(endpoint, query string) string {
+	return namespacedKey(fmt.Sprintf("cache:%s:%s", endpoint, keyedQuery(endpoint, query)))
+}
+
+// keyedQuery returns the part of a normalized query that identifies a
+// cached response of endpoint, built by its routeKeyBuilders entry if it
+// has one, with the endpoint's default params. Only this part is sent to
+// the backend on cached fetches, so params left out of the key can't change
+// what gets cached.
+func keyedQuery(endpoint, query string) string {
+	if build, ok := routeKeyBuilders[endpoint]; ok {
+		query = build(query)
+	}
+	return withDefaultCurrency(endpoint, query)
+}
+
+// loadCachedBody returns the body cached for endpoint and a normalized
+// query along with its status and ETag, fetching it from the backend on a
+// miss and refreshing stale entries in the background like cachedProxy.
+// The cache of ctx's tenant is used.
+func loadCachedBody(ctx context.Context, endpoint, query string) ([]byte, int, string, error) {
+	settings, ok := cachedEndpoints[endpoint]
+	if !ok {
+		return nil, 0, "", fmt.Errorf("unknown endpoint %q", endpoint)
+	}
+
+	query = keyedQuery(endpoint, query)
+	settings.ttl, settings.staleWindow = queryTTL(endpoint, query, settings.ttl, settings.staleWindow)
+	cacheKey := defaultCacheKey(ctx, endpoint, query)
+	if entry, ok := getCacheEntry(ctx, cacheKey); ok {
+		return cachedEntryBody(endpoint, query, cacheKey, entry, settings)
+	}
+	return fetchCachedBody(ctx, endpoint, query, cacheKey, settings)
+}
+
+// defaultCacheKey returns the cache key of endpoint and a keyed query for
+// ctx's tenant, as if the client sent none of the vary headers
+func defaultCacheKey(ctx context.Context, endpoint, query string) string {
+	return cacheKeyFor(endpoint, query) + varyKey(nil, cachedEndpoints[endpoint].vary) + tenantKey(ctx)
+}
+
+// cachedEntryBody returns the body, status and ETag of an entry found under
+// cacheKey for loadCachedBody, refreshing it in the background if stale
+func cachedEntryBody(endpoint, query, cacheKey string, entry *cacheEntry, settings cacheSettings) ([]byte, int, string, error) {
+	if !time.Now().Before(entry.SoftExpiry) {
+		refreshInBackground(endpoint, query, nil, nil, cacheKey, entry, settings.ttl, settings.staleWindow)
+	}
+	body, err := entry.plainBody()
+	return body, entry.statusCode(), entry.ETag, err
+}
+
+// fetchCachedBody fetches the body missing from cacheKey for loadCachedBody
+func fetchCachedBody(ctx context.Context, endpoint, query, cacheKey string, settings cacheSettings) ([]byte, int, string, error) {
+	resp, err := fetchAndCache(ctx, endpoint, query, nil, nil, cacheKey, settings.ttl, settings.staleWindow)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return resp.body, resp.status, computeETag(resp.body), nil
+}
+
+// getCacheEntry loads and decodes a cache envelope from the cache
+func getCacheEntry(ctx context.Context, cacheKey string) (*cacheEntry, bool) {
+	if redisBypassed() {
+		return nil, false
+	}
 
-		// Cache the response if it was successful
-		if resp.StatusCode == http.StatusOK {
-			if err := rdb.Set(ctx, cacheKey, body, ttl).Err(); err != nil {
-				log.Printf("Error caching response: %v", err)
-			} else {
-				log.Printf("Cached response for %s with TTL %v", cacheKey, ttl)
+	ctx, span := tracer.Start(ctx, "cache.get", trace.WithAttributes(attribute.String("cache.key", cacheKey)))
+	defer span.End()
+
+	value, ttl, err := cacheStore.Get(ctx, cacheKey)
+	if err != nil {
+		if err != errCacheMiss {
+			span.RecordError(err)
+			checkRedisError(err)
+		}
+		return nil, false
+	}
+	return decodeCacheEntry(cacheKey, value, ttl)
+}
+
+// getCacheEntries loads the cache envelopes of several keys in one round
+// trip, with nil entries for keys that are missing or can't be decoded
+func getCacheEntries(ctx context.Context, cacheKeys []string) []*cacheEntry {
+	entries := make([]*cacheEntry, len(cacheKeys))
+	if redisBypassed() || len(cacheKeys) == 0 {
+		return entries
+	}
+
+	ctx, span := tracer.Start(ctx, "cache.get_many", trace.WithAttributes(attribute.Int("cache.keys", len(cacheKeys))))
+	defer span.End()
+
+	values, ttls, err := cacheStore.GetMany(ctx, cacheKeys)
+	if err != nil {
+		span.RecordError(err)
+		checkRedisError(err)
+		return entries
+	}
+	for i, value := range values {
+		if value != nil {
+			entries[i], _ = decodeCacheEntry(cacheKeys[i], value, ttls[i])
+		}
+	}
+	return entries
+}
+
+// decodeCacheEntry verifies and decodes a cache envelope read from cacheKey
+func decodeCacheEntry(cacheKey string, value []byte, ttl time.Duration) (*cacheEntry, bool) {
+	data, ok := verifyCacheEntry(cacheKey, value)
+	if !ok {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		slog.Warn("Error decoding cache entry", "key", cacheKey, "error", err)
+		return nil, false
+	}
+	entry.ttl = ttl
+	return &entry, true
+}
+
+// fetchAndCache fetches an endpoint from the backend and caches the
+// response if it was successful. The endpoint is fetched with a GET, or a
+// POST of body if it is not nil. Concurrent calls for the same cache key
+// share a single backend request, which runs under the context of the
+// caller that started it.
+func fetchAndCache(ctx context.Context, endpoint, rawQuery string, header http.Header, body []byte, cacheKey string, ttl, staleWindow time.Duration) (*backendResponse, error) {
+	ch := fetchGroup.DoChan(cacheKey+validatorKey(header), func() (interface{}, error) {
+		return fetchAndCacheOnce(ctx, endpoint, rawQuery, header, body, cacheKey, ttl, staleWindow)
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			// The shared fetch was cancelled by another client, try again
+			if errors.Is(res.Err, context.Canceled) && ctx.Err() == nil {
+				return fetchAndCache(ctx, endpoint, rawQuery, header, body, cacheKey, ttl, staleWindow)
+			}
+			return nil, res.Err
+		}
+		if res.Shared {
+			coalescedRequests.WithLabelValues(endpoint).Inc()
+			slog.Debug("Shared backend response", "key", cacheKey)
+		}
+
+		return res.Val.(*backendResponse), nil
+	}
+}
+
+// fetchAndCacheOnce performs a single backend fetch for fetchAndCache
+func fetchAndCacheOnce(ctx context.Context, endpoint, rawQuery string, header http.Header, reqBody []byte, cacheKey string, ttl, staleWindow time.Duration) (*backendResponse, error) {
+	uri, err := backendURI(endpoint, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRateLimited(ctx, cacheKey); err != nil {
+		return nil, err
+	}
+	resp, err := fetchBackend(ctx, endpoint, uri, rawQuery, header, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if resp.status == http.StatusTooManyRequests {
+		if err := recordRateLimited(ctx, cacheKey, resp.header); err != nil {
+			return nil, err
+		}
+	}
+	body := resp.body
+
+	// Cache the response if it was successful, the backend allows it and
+	// the client is still there
+	ttl, cacheable := backendCacheTTL(resp.header, ttl)
+	if !cacheable {
+		slog.Debug("Backend disallowed caching", "key", cacheKey, "cache_control", resp.header.Get("Cache-Control"))
+	}
+	negative := isNegativelyCached(resp.status)
+	if negative {
+		// Errors are cached briefly, with no stale window or LKG copy
+		ttl, staleWindow = negativeTTL, 0
+	}
+	ttl = jitterTTL(ttl)
+	if tooLargeToCache(endpoint, body) {
+		slog.Debug("Response too large to cache", "key", cacheKey, "bytes", len(body))
+		cacheable = false
+	}
+	if cacheable && (resp.status == http.StatusOK || negative) && ctx.Err() == nil && !redisBypassed() && !redisWritesPaused() {
+		entry := cacheEntry{
+			Body:        body,
+			ContentType: resp.header.Get("Content-Type"),
+			ETag:        computeETag(body),
+			BackendETag: resp.header.Get("ETag"),
+			CachedAt:    time.Now(),
+			SoftExpiry:  time.Now().Add(ttl),
+		}
+		entry.LastModified = resp.lastModified()
+		if negative {
+			entry.Status = resp.status
+		}
+		if compressed, encoding, err := compressCacheBody(body); err != nil {
+			slog.Warn("Error compressing response", "key", cacheKey, "error", err)
+		} else if encoding != "" {
+			entry.Body = compressed
+			entry.Encoding = encoding
+			entry.Gzipped = encoding == "gzip"
+			slog.Debug("Compressed response", "key", cacheKey, "encoding", encoding, "bytes", len(body),
+				"compressed_bytes", len(compressed), "ratio", float64(len(compressed))/float64(len(body)))
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			slog.Error("Error encoding cache entry", "key", cacheKey, "error", err)
+		} else if err := storeCacheEntry(ctx, cacheKey, data, ttl+staleWindow, !negative); err != nil {
+			slog.Warn("Error caching response", "key", cacheKey, "error", err)
+			checkRedisError(err)
+		} else {
+			slog.Debug("Cached response", "key", cacheKey, "ttl", ttl.String(), "stale_window", staleWindow.String())
+			if diffEndpoints[endpoint] && !negative {
+				storeSnapshot(ctx, endpoint, body)
 			}
 		}
+	}
 
-		// Set original status code and headers
-		c.Status(resp.StatusCode)
-		for k, v := range resp.Header {
-			for _, vv := range v {
-				c.Header(k, vv)
+	return resp, nil
+}
+
+// fetchBackend fetches a GET, or a POST of reqBody if it is not nil, for
+// uri from the backend or the endpoint's fetcher. The endpoint's transform
+// is applied, other JSON bodies are minified and empty bodies are served as
+// 204s.
+func fetchBackend(ctx context.Context, endpoint, uri, rawQuery string, header http.Header, reqBody []byte) (*backendResponse, error) {
+	var resp *backendResponse
+	var err error
+	if fetch, ok := endpointFetchers[endpoint]; ok {
+		resp, err = fetch(ctx, rawQuery, header)
+	} else {
+		method := http.MethodGet
+		if reqBody != nil {
+			method = http.MethodPost
+		}
+		resp, err = proxyRequest(ctx, endpoint, method, uri, header, reqBody)
+		// Conditional reads would be compared against an unconditional
+		// shadow response
+		if err == nil && reqBody == nil && validatorKey(header) == "" {
+			shadowRead(endpoint, uri, header, resp)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.status == http.StatusOK && isJSONContentType(resp.header.Get("Content-Type")) {
+		if transformed, ok := transformBody(endpoint, resp.body); ok {
+			resp.body = transformed
+			resp.header.Del("Content-Length")
+		} else if minifyJSON {
+			if minified, ok := minifyBody(resp.body); ok {
+				resp.body = minified
+				resp.header.Del("Content-Length")
 			}
 		}
-		c.Header("X-Cache", "MISS")
-		c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
 	}
+	if resp.status == http.StatusOK && len(resp.body) == 0 {
+		// Empty bodies are not cached and are served as 204s, which
+		// clients handle better than empty 200s
+		resp.status = http.StatusNoContent
+		resp.header.Del("Content-Type")
+		resp.header.Del("Content-Length")
+	}
+	return resp, nil
 }
 
-// directProxy creates a gin handler that directly proxies requests without caching
-func directProxy(c *gin.Context) {
-	targetURL := fmt.Sprintf("%s%s?%s", backendURL, c.Request.URL.Path, c.Request.URL.RawQuery)
-	resp, err := http.Get(targetURL)
+// recacheEntry stores an entry the backend confirmed is current under
+// cacheKey as freshly cached
+func recacheEntry(ctx context.Context, cacheKey string, entry *cacheEntry, ttl, staleWindow time.Duration) {
+	if redisBypassed() || redisWritesPaused() {
+		return
+	}
+	ttl = jitterTTL(ttl)
+	refreshed := *entry
+	refreshed.CachedAt = time.Now()
+	refreshed.SoftExpiry = refreshed.CachedAt.Add(ttl)
+	data, err := json.Marshal(refreshed)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error proxying request: %v", err)})
+		slog.Error("Error encoding cache entry", "key", cacheKey, "error", err)
 		return
 	}
-	defer resp.Body.Close()
+	if err := storeCacheEntry(ctx, cacheKey, data, ttl+staleWindow, false); err != nil {
+		slog.Warn("Error caching response", "key", cacheKey, "error", err)
+		checkRedisError(err)
+	}
+}
 
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error reading response: %v", err)})
+// tooLargeToCache reports whether body exceeds the largest cacheable body of
+// endpoint
+func tooLargeToCache(endpoint string, body []byte) bool {
+	maxBytes := cachedEndpoints[endpoint].maxBytes
+	return maxBytes > 0 && int64(len(body)) > maxBytes
+}
+
+// storeCacheEntry writes an encoded cache entry under cacheKey with the given
+// expiry, and if lkg is set under its never-expiring last known good key.
+// Entries are signed for their key when CACHE_HMAC_SECRET is set.
+func storeCacheEntry(ctx context.Context, cacheKey string, data []byte, expiry time.Duration, lkg bool) error {
+	if err := cacheStore.Set(ctx, cacheKey, signCacheEntry(cacheKey, data), expiry); err != nil {
+		return err
+	}
+	if lkg {
+		return cacheStore.Set(ctx, lkgKey(cacheKey), signCacheEntry(lkgKey(cacheKey), data), 0)
+	}
+	return nil
+}
+
+// lkgKey returns the last known good key for a cache key
+func lkgKey(cacheKey string) string {
+	return namespacedKey("lkg:" + strings.TrimPrefix(cacheKey, namespacedKey("cache:")))
+}
+
+// refreshInBackground starts a background refresh of a cache key unless one
+// is already running. A current entry with a backend ETag is revalidated:
+// on a 304 it is kept and only its TTL extended, and its body is refetched
+// only on a 200.
+func refreshInBackground(endpoint, rawQuery string, header http.Header, body []byte, cacheKey string, entry *cacheEntry, ttl, staleWindow time.Duration) {
+	if _, running := refreshing.LoadOrStore(cacheKey, struct{}{}); running {
 		return
 	}
 
-	// Set original status code and headers
-	c.Status(resp.StatusCode)
-	for k, v := range resp.Header {
-		for _, vv := range v {
-			c.Header(k, vv)
+	go func() {
+		defer refreshing.Delete(cacheKey)
+		// Not tied to the request that triggered the refresh
+		ctx := context.Background()
+		resp, err := fetchAndCache(ctx, endpoint, rawQuery, revalidationHeader(header, entry), body, cacheKey, ttl, staleWindow)
+		if err != nil {
+			sampledLog.Warn("Error refreshing cache entry", "key", cacheKey, "error", err)
+			return
+		}
+		if entry == nil || entry.BackendETag == "" {
+			return
+		}
+		if resp.status == http.StatusNotModified {
+			slog.Debug("Backend revalidated cache entry", "key", cacheKey)
+			recacheEntry(ctx, cacheKey, entry, ttl, staleWindow)
+			cacheRevalidations.WithLabelValues(endpoint, "not_modified").Inc()
+		} else {
+			cacheRevalidations.WithLabelValues(endpoint, "modified").Inc()
 		}
+	}()
+}
+
+// hopHeaders are connection-specific headers that must not be forwarded
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// directProxy creates a gin handler that directly proxies requests without caching.
+// The original method, body and headers are forwarded to the backend, and the
+// response is streamed back.
+func directProxy(c *gin.Context) {
+	endpoint := strings.TrimPrefix(c.FullPath(), "/api/")
+	uri, err := backendURI(endpoint, c.Request.URL.RawQuery)
+	if err != nil {
+		respondBackendError(c, err)
+		return
+	}
+
+	// Buffer the body so it can be replayed against another backend
+	reqBody, ok := readRequestBody(c)
+	if !ok {
+		return
+	}
+	header := withClientIP(c, c.Request.Header)
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+
+	start := time.Now()
+	err = streamRequest(c, endpoint, c.Request.Method, uri, header, reqBody)
+	c.Set("backend_latency", time.Since(start))
+	if err != nil {
+		respondBackendError(c, err)
+	}
+}
+
+// respondBackendError writes the error envelope for a failed backend call,
+// using 503 while the circuit breaker is open or the endpoint is saturated,
+// 504 for timeouts, 404 for unregistered endpoints, 429 with the remaining
+// Retry-After while the backend rate limits the request and 502 otherwise.
+// Details of unclassified errors, such as backend addresses, are only
+// logged.
+func respondBackendError(c *gin.Context, err error) {
+	status, code, message := backendErrorStatus(err)
+	if code == "backend_error" {
+		sampledLog.Warn("Backend request failed", "path", c.Request.URL.Path, "error", err,
+			"request_id", c.GetString("request_id"))
+	}
+	var limited *backendRateLimitedError
+	if errors.As(err, &limited) {
+		c.Header("Retry-After", retryAfterSeconds(limited.retryAfter))
+	}
+	errorResponse(c, status, code, message)
+}
+
+// backendErrorStatus maps a backend request error to a response status,
+// error code and client-facing message
+func backendErrorStatus(err error) (int, string, string) {
+	switch {
+	case errors.Is(err, errUnknownEndpoint):
+		return http.StatusNotFound, "unknown_endpoint", "unknown endpoint"
+	case errors.Is(err, errResponseTooLarge):
+		return http.StatusBadGateway, "backend_response_too_large", "backend response too large"
+	case errors.Is(err, errCircuitOpen):
+		return http.StatusServiceUnavailable, "backend_unavailable", "backend unavailable"
+	case errors.Is(err, errEndpointSaturated):
+		return http.StatusServiceUnavailable, "backend_busy", "backend busy"
+	case errors.As(err, new(*backendRateLimitedError)):
+		return http.StatusTooManyRequests, "backend_rate_limited", "backend rate limited, retry later"
+	case isTimeout(err):
+		return http.StatusGatewayTimeout, "backend_timeout", "backend timeout"
+	default:
+		return http.StatusBadGateway, "backend_error", "backend request failed"
+	}
+}
+
+// isTimeout reports whether err was caused by a timeout
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
 	}
-	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
 }
 
 // getEnv gets an environment variable or returns a default value
@@ -207,3 +969,63 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvDuration gets a duration from an environment variable or returns a
+// default value if it is unset or invalid
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "key", key, "value", value, "default", defaultValue.String())
+		return defaultValue
+	}
+	return d
+}
+
+// getEnvInt gets an integer from an environment variable or returns a
+// default value if it is unset or invalid
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvFloat gets a float from an environment variable or returns a
+// default value if it is unset or invalid
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("Invalid number, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+// getEnvBool gets a boolean from an environment variable or returns a
+// default value if it is unset or invalid
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("Invalid boolean, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return b
+}
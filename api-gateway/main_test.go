@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	setLogLevel(slog.LevelError)
+	cacheStore = newMemoryCache(0)
+	backendRetryBaseDelay = time.Millisecond
+	backendRetryMaxDelay = 5 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// setForTest sets *p to value for the rest of the test
+func setForTest[T any](t *testing.T, p *T, value T) {
+	t.Helper()
+	old := *p
+	*p = value
+	t.Cleanup(func() { *p = old })
+}
+
+// testBackend is a mock backend counting the requests it receives
+type testBackend struct {
+	*httptest.Server
+	hits atomic.Int64
+}
+
+// newTestBackend starts a mock backend serving handler and points the
+// gateway at it, with an empty cache and a closed circuit breaker
+func newTestBackend(t *testing.T, handler http.HandlerFunc) *testBackend {
+	t.Helper()
+	b := &testBackend{}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(b.Close)
+	setForTest(t, &backends, parseBackends(b.URL))
+	setForTest[Cache](t, &cacheStore, newMemoryCache(0))
+	setForTest(t, &backendBreaker, newBackendBreaker(5, 30*time.Second))
+	return b
+}
+
+// recordingCache wraps a Cache, counting writes and recording whether it
+// was closed
+type recordingCache struct {
+	Cache
+	sets   atomic.Int64
+	closed atomic.Bool
+}
+
+// newRecordingCache returns a recordingCache around an empty memory cache
+// and makes it the gateway's cache for the rest of the test
+func newRecordingCache(t *testing.T) *recordingCache {
+	t.Helper()
+	cache := &recordingCache{Cache: newMemoryCache(0)}
+	setForTest[Cache](t, &cacheStore, cache)
+	return cache
+}
+
+func (c *recordingCache) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	c.sets.Add(1)
+	return c.Cache.Set(ctx, key, value, expiry)
+}
+
+func (c *recordingCache) Close() error {
+	c.closed.Store(true)
+	return c.Cache.Close()
+}
+
+// newTestRedis starts an in-process Redis server and makes it the gateway's
+// cache and Redis client for the rest of the test
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	setForTest[redis.UniversalClient](t, &rdb, client)
+	setForTest[Cache](t, &cacheStore, &redisCache{client: client})
+	return server
+}
+
+// jsonBackend returns a handler answering every request with body as JSON
+func jsonBackend(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+// newRequest returns a request for target with header and body
+func newRequest(method, target string, header http.Header, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	return req
+}
+
+// serveRequest sends req through r and returns the recorded response
+func serveRequest(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// serve sends a request through r and returns the recorded response
+func serve(r http.Handler, method, target string, header http.Header, body string) *httptest.ResponseRecorder {
+	return serveRequest(r, newRequest(method, target, header, body))
+}
+
+// get sends a GET for target through r
+func get(r http.Handler, target string) *httptest.ResponseRecorder {
+	return serve(r, http.MethodGet, target, nil, "")
+}
+
+// cachedTestRouter returns a router serving /api/<endpoint> with cachedProxy
+func cachedTestRouter(endpoint string, ttl, staleWindow time.Duration) *gin.Engine {
+	r := gin.New()
+	r.GET("/api/"+endpoint, cachedProxy(endpoint, ttl, staleWindow, 0))
+	return r
+}
+
+// directTestRouter returns a router proxying methods of /api/<endpoint>
+// with directProxy
+func directTestRouter(t *testing.T, endpoint string, methods ...string) *gin.Engine {
+	t.Helper()
+	directEndpoints[endpoint] = true
+	t.Cleanup(func() { delete(directEndpoints, endpoint) })
+	r := gin.New()
+	for _, method := range methods {
+		r.Handle(method, "/api/"+endpoint, directProxy)
+	}
+	return r
+}
+
+// errorCode returns the code of an error envelope response
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decoding error response %q: %v", w.Body, err)
+	}
+	return envelope.Error.Code
+}
+
+// waitFor polls cond until it holds, failing the test after a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// waitForRefreshes waits until no background cache refresh is running
+func waitForRefreshes(t *testing.T) {
+	t.Helper()
+	waitFor(t, func() bool {
+		idle := true
+		refreshing.Range(func(any, any) bool {
+			idle = false
+			return false
+		})
+		return idle
+	})
+}
+
+func TestCachedProxyServesStaleAndRefreshesInBackground(t *testing.T) {
+	var version atomic.Int64
+	version.Store(1)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, version.Load())
+	})
+	r := cachedTestRouter("swr", 50*time.Millisecond, time.Minute)
+
+	if w := get(r, "/api/swr"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != `{"version":1}` {
+		t.Fatalf("first request: X-Cache %q, body %s", w.Header().Get("X-Cache"), w.Body)
+	}
+
+	// Past the soft TTL but within the stale window
+	time.Sleep(60 * time.Millisecond)
+	version.Store(2)
+	w := get(r, "/api/swr")
+	if w.Header().Get("X-Cache") != "STALE" || w.Body.String() != `{"version":1}` {
+		t.Fatalf("expired request: X-Cache %q, body %s, want STALE version 1", w.Header().Get("X-Cache"), w.Body)
+	}
+
+	// The background refresh replaces the entry
+	waitFor(t, func() bool { return backend.hits.Load() == 2 })
+	waitFor(t, func() bool {
+		w := get(r, "/api/swr")
+		return w.Header().Get("X-Cache") == "HIT" && w.Body.String() == `{"version":2}`
+	})
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+}
+
+func TestCachedProxyEvictsAfterStaleWindow(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	r := cachedTestRouter("swr-evict", 20*time.Millisecond, 20*time.Millisecond)
+
+	get(r, "/api/swr-evict")
+	time.Sleep(50 * time.Millisecond)
+	if w := get(r, "/api/swr-evict"); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("X-Cache %q past the stale window, want MISS", w.Header().Get("X-Cache"))
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+}
+
+func TestCachedProxySharesConcurrentBackendFetch(t *testing.T) {
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		jsonBackend(`{"price":1}`)(w, r)
+	})
+	r := cachedTestRouter("stampede", time.Minute, time.Minute)
+
+	const clients = 50
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, clients)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = get(r, "/api/stampede")
+		}(i)
+	}
+	// Let every client reach the shared fetch before the backend answers
+	waitFor(t, func() bool { return backend.hits.Load() == 1 })
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1", hits)
+	}
+	for i, w := range responses {
+		if w.Code != http.StatusOK || w.Body.String() != `{"price":1}` {
+			t.Errorf("client %d: status %d, body %s", i, w.Code, w.Body)
+		}
+	}
+	if w := get(r, "/api/stampede"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache %q after the shared fetch, want HIT", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestCachedProxySharesBackendErrorStatus(t *testing.T) {
+	release := make(chan struct{})
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r := cachedTestRouter("stampede-404", time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = get(r, "/api/stampede-404").Code
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusNotFound {
+			t.Errorf("client %d: status %d, want 404", i, code)
+		}
+	}
+}
+
+func TestDirectProxyForwardsMethodBodyAndContentType(t *testing.T) {
+	type received struct {
+		method, contentType, body string
+	}
+	requests := make(chan received, 1)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- received{r.Method, r.Header.Get("Content-Type"), string(body)}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":1}`)
+	})
+	r := directTestRouter(t, "portfolio", http.MethodPost, http.MethodPut)
+
+	for _, method := range []string{http.MethodPost, http.MethodPut} {
+		header := http.Header{"Content-Type": {"application/json; charset=utf-8"}}
+		w := serve(r, method, "/api/portfolio", header, `{"coins":["BTC","ETH"]}`)
+		if w.Code != http.StatusCreated || w.Body.String() != `{"id":1}` {
+			t.Errorf("%s: status %d, body %s", method, w.Code, w.Body)
+		}
+		got := <-requests
+		want := received{method, "application/json; charset=utf-8", `{"coins":["BTC","ETH"]}`}
+		if got != want {
+			t.Errorf("%s: backend received %+v, want %+v", method, got, want)
+		}
+	}
+}
+
+func TestBackendTimeoutReturns504(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	setForTest(t, &backendClient.Timeout, 20*time.Millisecond)
+	setForTest(t, &backendMaxRetries, 0)
+
+	cached := cachedTestRouter("slow", time.Minute, time.Minute)
+	direct := directTestRouter(t, "slow-direct", http.MethodGet)
+	for _, tc := range []struct {
+		r      http.Handler
+		target string
+	}{
+		{cached, "/api/slow"},
+		{direct, "/api/slow-direct"},
+	} {
+		w := get(tc.r, tc.target)
+		if w.Code != http.StatusGatewayTimeout || errorCode(t, w) != "backend_timeout" {
+			t.Errorf("%s: status %d, body %s, want 504 backend_timeout", tc.target, w.Code, w.Body)
+		}
+	}
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	cache := newRecordingCache(t)
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(w, "done")
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{string(body), err}
+	}()
+
+	<-started
+	shutdown(srv, time.Second)
+	res := <-results
+	if res.err != nil || res.body != "done" {
+		t.Errorf("in-flight request got %q, %v, want it to complete", res.body, res.err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("server still accepting requests after shutdown")
+	}
+	if !cache.closed.Load() {
+		t.Error("cache not closed on shutdown")
+	}
+}
+
+func TestCachedProxyCancelledRequestIsNotCached(t *testing.T) {
+	release := make(chan struct{})
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		jsonBackend(`{"ok":true}`)(w, r)
+	})
+	cache := newRecordingCache(t)
+	r := cachedTestRouter("cancelled", time.Minute, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/cancelled", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	waitFor(t, func() bool { return backend.hits.Load() == 1 })
+	cancel()
+	<-done
+	close(release)
+
+	// Give an uncancelled fetch time to finish and write
+	time.Sleep(50 * time.Millisecond)
+	if sets := cache.sets.Load(); sets != 0 {
+		t.Errorf("cancelled request made %d cache writes, want 0", sets)
+	}
+}
+
+func TestRouteTTLFromEnv(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	t.Setenv("TTL_TTL_ENV", "50ms")
+	r := gin.New()
+	cachedRoute(r, "ttl-env", time.Minute, 0)
+
+	get(r, "/api/ttl-env")
+	if w := get(r, "/api/ttl-env"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("X-Cache %q within the TTL, want HIT", w.Header().Get("X-Cache"))
+	}
+	time.Sleep(70 * time.Millisecond)
+	if w := get(r, "/api/ttl-env"); w.Header().Get("X-Cache") != "STALE" {
+		t.Errorf("X-Cache %q past TTL_TTL_ENV, want STALE", w.Header().Get("X-Cache"))
+	}
+	waitForRefreshes(t)
+}
+
+func TestRouteTTLFallsBackToDefault(t *testing.T) {
+	t.Setenv("TTL_TTL_DEFAULT", "soon")
+	if ttl := routeTTL("ttl-default", time.Minute); ttl != time.Minute {
+		t.Errorf("routeTTL with an invalid env var = %s, want the 1m default", ttl)
+	}
+	if ttl := routeTTL("ttl-unset", 5*time.Minute); ttl != 5*time.Minute {
+		t.Errorf("routeTTL without an env var = %s, want the 5m default", ttl)
+	}
+	t.Setenv("TTL_ADVANCED_INSIGHTS", "2m")
+	if ttl := routeTTL("advanced-insights", time.Minute); ttl != 2*time.Minute {
+		t.Errorf("routeTTL(advanced-insights) = %s, want 2m from TTL_ADVANCED_INSIGHTS", ttl)
+	}
+}
+
+func TestCachedProxyFallsBackToLastKnownGood(t *testing.T) {
+	var down atomic.Bool
+	ok := jsonBackend(`{"BTC":50000}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok(w, r)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	r := cachedTestRouter("lkg-fallback", time.Minute, time.Minute)
+
+	get(r, "/api/lkg-fallback")
+	if _, err := cacheStore.Del(context.Background(), namespacedKey("cache:lkg-fallback:")); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+	w := get(r, "/api/lkg-fallback")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE-FALLBACK" || w.Body.String() != `{"BTC":50000}` {
+		t.Errorf("backend 500: status %d, X-Cache %q, body %s, want the last known good copy", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+
+	backend.Close()
+	if w := get(r, "/api/lkg-fallback"); w.Header().Get("X-Cache") != "STALE-FALLBACK" {
+		t.Errorf("backend down: X-Cache %q, want STALE-FALLBACK", w.Header().Get("X-Cache"))
+	}
+	w = get(r, "/api/lkg-fallback?symbol=ETH")
+	if w.Code != http.StatusBadGateway || errorCode(t, w) != "backend_error" {
+		t.Errorf("without a last known good copy: status %d, body %s, want 502", w.Code, w.Body)
+	}
+}
+
+func TestCachedProxyReportsAgeAndRemainingTTL(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	r := cachedTestRouter("age-ttl", time.Minute, time.Minute)
+
+	get(r, "/api/age-ttl")
+	first := get(r, "/api/age-ttl")
+	time.Sleep(1100 * time.Millisecond)
+	second := get(r, "/api/age-ttl")
+
+	header := func(w *httptest.ResponseRecorder, name string) int {
+		t.Helper()
+		value, err := strconv.Atoi(w.Header().Get(name))
+		if err != nil {
+			t.Fatalf("%s %q: %v", name, w.Header().Get(name), err)
+		}
+		return value
+	}
+	if age1, age2 := header(first, "Age"), header(second, "Age"); age1 != 0 || age2 < 1 {
+		t.Errorf("Age %d then %d, want 0 then at least 1", age1, age2)
+	}
+	ttl1, ttl2 := header(first, "X-Cache-TTL-Remaining"), header(second, "X-Cache-TTL-Remaining")
+	if ttl1 > 120 || ttl1 < 118 || ttl2 >= ttl1 {
+		t.Errorf("X-Cache-TTL-Remaining %d then %d, want about 120 then less", ttl1, ttl2)
+	}
+}
+
+func TestEmptyBackendBodiesAreServedAsNoContent(t *testing.T) {
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("empty") == "" {
+			w.Write([]byte(`{}`))
+		}
+	})
+	cached := cachedTestRouter("empty-cached", time.Minute, time.Minute)
+	direct := directTestRouter(t, "empty-direct", http.MethodGet)
+
+	for i := 0; i < 2; i++ {
+		for target, r := range map[string]*gin.Engine{"/api/empty-cached?empty=1": cached, "/api/empty-direct?empty=1": direct} {
+			w := get(r, target)
+			if w.Code != http.StatusNoContent || w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+				t.Errorf("%s: status %d, Content-Type %q, body %q, want a bare 204", target, w.Code, w.Header().Get("Content-Type"), w.Body)
+			}
+		}
+	}
+	if cacheHas(cacheKeyFor("empty-cached", "empty=1")) {
+		t.Error("empty body was cached")
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want every empty response fetched", hits)
+	}
+
+	if w := get(cached, "/api/empty-cached"); w.Code != http.StatusOK || w.Body.String() != `{}` {
+		t.Errorf("non-empty body: status %d, body %s, want 200", w.Code, w.Body)
+	}
+}
+
+// ageLastKnownGood makes the last known good copy of cacheKey look like
+// its TTL ended age ago
+func ageLastKnownGood(t *testing.T, cacheKey string, age time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	entry, ok := getCacheEntry(ctx, lkgKey(cacheKey))
+	if !ok {
+		t.Fatalf("no last known good copy of %s", cacheKey)
+	}
+	entry.SoftExpiry = time.Now().Add(-age)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheStore.Set(ctx, lkgKey(cacheKey), signCacheEntry(lkgKey(cacheKey), data), 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachedProxyServesStaleIfError(t *testing.T) {
+	var down atomic.Bool
+	ok := jsonBackend(`{"BTC":50000}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok(w, r)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	t.Setenv("STALE_IF_ERROR_SIE_PRICES", "1m")
+	r := cachedTestRouter("sie-prices", time.Minute, time.Minute)
+	cacheKey := cacheKeyFor("sie-prices", "")
+
+	get(r, "/api/sie-prices")
+	if _, err := cacheStore.Del(context.Background(), cacheKey); err != nil {
+		t.Fatal(err)
+	}
+	down.Store(true)
+
+	ageLastKnownGood(t, cacheKey, 30*time.Second)
+	w := get(r, "/api/sie-prices")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE-IF-ERROR" || w.Body.String() != `{"BTC":50000}` {
+		t.Errorf("error within the window: status %d, X-Cache %q, body %s, want the stale copy", w.Code, w.Header().Get("X-Cache"), w.Body)
+	}
+	backend.Close()
+	if w := get(r, "/api/sie-prices"); w.Header().Get("X-Cache") != "STALE-IF-ERROR" {
+		t.Errorf("backend down within the window: X-Cache %q, want STALE-IF-ERROR", w.Header().Get("X-Cache"))
+	}
+
+	ageLastKnownGood(t, cacheKey, 2*time.Minute)
+	w = get(r, "/api/sie-prices")
+	if w.Code != http.StatusBadGateway || errorCode(t, w) != "backend_error" {
+		t.Errorf("error beyond the window: status %d, body %s, want the backend error", w.Code, w.Body)
+	}
+}
+
+func TestRouteStaleIfError(t *testing.T) {
+	if d := routeStaleIfError("sie-default"); d != 0 {
+		t.Errorf("without STALE_IF_ERROR: %v, want 0 to serve the last known good copy however old", d)
+	}
+	t.Setenv("STALE_IF_ERROR", "5m")
+	t.Setenv("STALE_IF_ERROR_SIE_NEWS", "1h")
+	if d := routeStaleIfError("sie-default"); d != 5*time.Minute {
+		t.Errorf("STALE_IF_ERROR: %v, want 5m", d)
+	}
+	if d := routeStaleIfError("sie-news"); d != time.Hour {
+		t.Errorf("STALE_IF_ERROR_SIE_NEWS: %v, want 1h", d)
+	}
+}
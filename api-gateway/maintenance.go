@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// maintenanceMode is set while /api requests are answered with a
+	// maintenance response. It starts from the MAINTENANCE env var and is
+	// toggled per instance through the admin endpoint.
+	maintenanceMode = newAtomicBool(getEnvBool("MAINTENANCE", false))
+
+	// maintenanceMessage is the message of maintenance responses
+	maintenanceMessage = getEnv("MAINTENANCE_MESSAGE", "service under maintenance")
+
+	// maintenanceRetryAfter is the Retry-After sent with maintenance
+	// responses
+	maintenanceRetryAfter = getEnvDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute)
+)
+
+// newAtomicBool returns an atomic.Bool holding value
+func newAtomicBool(value bool) *atomic.Bool {
+	b := new(atomic.Bool)
+	b.Store(value)
+	return b
+}
+
+// maintenanceGate creates a middleware that answers /api requests with 503
+// while maintenance mode is on. Admin and health endpoints keep working.
+func maintenanceGate() gin.HandlerFunc {
+	retryAfter := strconv.Itoa(int(math.Ceil(maintenanceRetryAfter.Seconds())))
+	if maintenanceMode.Load() {
+		slog.Warn("Starting in maintenance mode")
+	}
+
+	return func(c *gin.Context) {
+		if maintenanceMode.Load() && strings.HasPrefix(c.Request.URL.Path, "/api/") {
+			c.Header("Retry-After", retryAfter)
+			errorResponse(c, http.StatusServiceUnavailable, "maintenance", maintenanceMessage)
+			return
+		}
+		c.Next()
+	}
+}
+
+// maintenanceRequest is the body accepted by setMaintenance
+type maintenanceRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// setMaintenance turns maintenance mode on or off until the next restart
+func setMaintenance(c *gin.Context) {
+	var req maintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	if req.Enabled == nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_body", "enabled is required")
+		return
+	}
+
+	previous := maintenanceMode.Swap(*req.Enabled)
+	slog.Warn("Maintenance mode changed", "enabled", *req.Enabled, "previous", previous, "request_id", c.GetString("request_id"))
+	c.JSON(http.StatusOK, gin.H{"enabled": *req.Enabled, "previous": previous})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &maintenanceMode, newAtomicBool(false))
+	setForTest(t, &maintenanceRetryAfter, 90*time.Second)
+	setForTest(t, &maintenanceMessage, "back soon")
+	setForTest(t, &adminAPIKeys, parseAPIKeys(testAdminKey))
+	started.Store(true)
+	t.Cleanup(func() { started.Store(false) })
+	r := gin.New()
+	r.Use(maintenanceGate())
+	r.GET("/health", healthCheck)
+	r.GET("/api/maint-prices", cachedProxy("maint-prices", time.Minute, time.Minute, 0))
+	registerAdminRoutes(r)
+
+	if w := get(r, "/api/maint-prices"); w.Code != http.StatusOK {
+		t.Fatalf("before maintenance: status %d", w.Code)
+	}
+	w := serve(r, http.MethodPost, "/admin/maintenance", adminHeader, `{"enabled":true}`)
+	var toggled struct{ Enabled, Previous bool }
+	decodeJSON(t, w, &toggled)
+	if w.Code != http.StatusOK || !toggled.Enabled || toggled.Previous {
+		t.Fatalf("enabling maintenance: status %d, body %s", w.Code, w.Body)
+	}
+
+	for _, target := range []string{"/api/maint-prices", "/api/unknown"} {
+		w := get(r, target)
+		if w.Code != http.StatusServiceUnavailable || errorCode(t, w) != "maintenance" || w.Header().Get("Retry-After") != "90" {
+			t.Errorf("%s: status %d, Retry-After %q, body %s, want a 503 maintenance response", target, w.Code, w.Header().Get("Retry-After"), w.Body)
+		}
+		var envelope struct{ Error struct{ Message string } }
+		decodeJSON(t, w, &envelope)
+		if envelope.Error.Message != "back soon" {
+			t.Errorf("%s: message %q, want MAINTENANCE_MESSAGE", target, envelope.Error.Message)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want none during maintenance", hits)
+	}
+	if w := get(r, "/health"); w.Code != http.StatusOK {
+		t.Errorf("health during maintenance: status %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/admin/cache/stats", adminHeader, ""); w.Code != http.StatusOK {
+		t.Errorf("admin during maintenance: status %d, want 200", w.Code)
+	}
+
+	serve(r, http.MethodPost, "/admin/maintenance", adminHeader, `{"enabled":false}`)
+	if w := get(r, "/api/maint-prices"); w.Code != http.StatusOK {
+		t.Errorf("after maintenance: status %d, want 200", w.Code)
+	}
+}
+
+func TestMaintenanceToggleRejectsInvalidRequests(t *testing.T) {
+	setForTest(t, &maintenanceMode, newAtomicBool(false))
+	r := adminTestRouter(t)
+	for _, body := range []string{`{}`, `{"enabled":"yes"}`, `{`} {
+		if w := serve(r, http.MethodPost, "/admin/maintenance", adminHeader, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if w := serve(r, http.MethodPost, "/admin/maintenance", http.Header{"Content-Type": {"application/json"}}, `{"enabled":true}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin key: status %d, want 401", w.Code)
+	}
+	if maintenanceMode.Load() {
+		t.Error("maintenance mode turned on by a rejected request")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryCache is an in-process Cache that evicts the least recently used
+// entry once it holds maxEntries. Expired entries are removed lazily.
+type memoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // front is most recently used
+	items      map[string]*list.Element
+}
+
+// memoryItem is an entry of memoryCache
+type memoryItem struct {
+	key       string
+	value     []byte
+	expiresAt time.Time // zero if the entry does not expire
+}
+
+// newMemoryCache creates a memoryCache holding at most maxEntries entries,
+// or an unbounded one if maxEntries is not positive
+func newMemoryCache(maxEntries int) *memoryCache {
+	return &memoryCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// Get returns the value of key and marks it recently used
+func (m *memoryCache) Get(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.items[key]
+	if !ok {
+		return nil, 0, errCacheMiss
+	}
+	item := el.Value.(*memoryItem)
+	if item.expired(time.Now()) {
+		m.remove(el)
+		return nil, 0, errCacheMiss
+	}
+	m.order.MoveToFront(el)
+
+	ttl := time.Duration(-1)
+	if !item.expiresAt.IsZero() {
+		ttl = time.Until(item.expiresAt)
+	}
+	return item.value, ttl, nil
+}
+
+// GetMany loads each key with Get
+func (m *memoryCache) GetMany(ctx context.Context, keys []string) ([][]byte, []time.Duration, error) {
+	values := make([][]byte, len(keys))
+	ttls := make([]time.Duration, len(keys))
+	for i, key := range keys {
+		values[i], ttls[i], _ = m.Get(ctx, key)
+	}
+	return values, ttls, nil
+}
+
+// Set stores a copy of value under key, evicting the least recently used
+// entry if the cache is full
+func (m *memoryCache) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	item := &memoryItem{key: key, value: append([]byte(nil), value...)}
+	if expiry > 0 {
+		item.expiresAt = time.Now().Add(expiry)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		el.Value = item
+		m.order.MoveToFront(el)
+		return nil
+	}
+	m.items[key] = m.order.PushFront(item)
+	if m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		m.remove(m.order.Back())
+	}
+	return nil
+}
+
+// Del deletes keys, returning the number that were set
+func (m *memoryCache) Del(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for _, key := range keys {
+		if el, ok := m.items[key]; ok {
+			if !el.Value.(*memoryItem).expired(now) {
+				deleted++
+			}
+			m.remove(el)
+		}
+	}
+	return deleted, nil
+}
+
+// Scan calls fn with the unexpired keys matching pattern, in batches of
+// scanBatchSize. The keys are collected up front so fn may modify the
+// cache.
+func (m *memoryCache) Scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	m.mu.Lock()
+	var keys []string
+	now := time.Now()
+	for key, el := range m.items {
+		if !el.Value.(*memoryItem).expired(now) && globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for start := 0; start < len(keys); start += scanBatchSize {
+		end := min(start+scanBatchSize, len(keys))
+		if err := fn(keys[start:end]); err != nil {
+			if errors.Is(err, errStopScan) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanPage returns up to count matching keys in key order after cursor,
+// which is the last key of the previous page
+func (m *memoryCache) ScanPage(ctx context.Context, pattern, cursor string, count int64) ([]string, string, error) {
+	m.mu.Lock()
+	var keys []string
+	now := time.Now()
+	for key, el := range m.items {
+		if key > cursor && !el.Value.(*memoryItem).expired(now) && globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Strings(keys)
+	if count <= 0 || int64(len(keys)) <= count {
+		return keys, "", nil
+	}
+	keys = keys[:count]
+	return keys, keys[len(keys)-1], nil
+}
+
+// Ping always succeeds
+func (m *memoryCache) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close drops all entries
+func (m *memoryCache) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.order.Init()
+	m.items = map[string]*list.Element{}
+	return nil
+}
+
+// remove deletes el from the cache; the caller must hold mu
+func (m *memoryCache) remove(el *list.Element) {
+	m.order.Remove(el)
+	delete(m.items, el.Value.(*memoryItem).key)
+}
+
+// expired reports whether the item has expired at now
+func (i *memoryItem) expired(now time.Time) bool {
+	return !i.expiresAt.IsZero() && !now.Before(i.expiresAt)
+}
+
+// globMatch reports whether s matches a Redis glob pattern, supporting *, ?
+// and backslash escapes. Character classes are not used by the gateway's
+// patterns and are matched literally.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+		}
+		if len(s) == 0 || s[0] != pattern[0] {
+			return false
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
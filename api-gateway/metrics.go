@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_hits_total",
+		Help: "Number of requests served from the Redis cache.",
+	}, []string{"endpoint"})
+
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_misses_total",
+		Help: "Number of requests not found in the Redis cache.",
+	}, []string{"endpoint"})
+
+	backendLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_backend_request_duration_seconds",
+		Help:    "Latency of proxied backend requests.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	backendErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_backend_errors_total",
+		Help: "Number of failed backend requests or 5xx backend responses.",
+	}, []string{"endpoint"})
+
+	slowRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_slow_backend_requests_total",
+		Help: "Number of backend requests slower than SLOW_REQUEST_THRESHOLD.",
+	}, []string{"endpoint"})
+
+	coalescedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_coalesced_requests_total",
+		Help: "Number of cache misses that shared a backend fetch with concurrent requests.",
+	}, []string{"endpoint"})
+
+	refreshAheads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_refresh_ahead_total",
+		Help: "Number of hot cache keys refreshed ahead of expiry.",
+	}, []string{"endpoint"})
+
+	cacheRevalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_revalidations_total",
+		Help: "Number of stale cache entries revalidated with the backend by result (not_modified or modified).",
+	}, []string{"endpoint", "result"})
+
+	shadowResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_shadow_requests_total",
+		Help: "Number of shadow backend requests by result (match, status_mismatch, body_mismatch, error or dropped).",
+	}, []string{"endpoint", "result"})
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gateway_redis_pool_total_conns",
+		Help: "Number of connections in the Redis pool.",
+	}, func() float64 { return float64(redisPoolStats().TotalConns) })
+
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "gateway_redis_pool_idle_conns",
+		Help: "Number of idle connections in the Redis pool.",
+	}, func() float64 { return float64(redisPoolStats().IdleConns) })
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gateway_redis_pool_hits_total",
+		Help: "Number of times a free connection was found in the Redis pool.",
+	}, func() float64 { return float64(redisPoolStats().Hits) })
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gateway_redis_pool_misses_total",
+		Help: "Number of times no free connection was found in the Redis pool.",
+	}, func() float64 { return float64(redisPoolStats().Misses) })
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gateway_redis_pool_timeouts_total",
+		Help: "Number of times waiting for a Redis pool connection timed out.",
+	}, func() float64 { return float64(redisPoolStats().Timeouts) })
+
+	_ = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "gateway_redis_pool_stale_conns_total",
+		Help: "Number of stale connections removed from the Redis pool.",
+	}, func() float64 { return float64(redisPoolStats().StaleConns) })
+)
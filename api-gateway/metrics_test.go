@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetrics returns the /metrics exposition
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	w := get(promhttp.Handler(), "/metrics")
+	if w.Code != http.StatusOK {
+		t.Fatalf("scraping metrics: status %d", w.Code)
+	}
+	return w.Body.String()
+}
+
+// assertMetric fails the test unless the exposition has a line starting
+// with sample
+func assertMetric(t *testing.T, exposition, sample string) {
+	t.Helper()
+	for _, line := range strings.Split(exposition, "\n") {
+		if strings.HasPrefix(line, sample) {
+			return
+		}
+	}
+	t.Errorf("metrics have no %s", sample)
+}
+
+func TestMetricsCountCacheHitsMissesAndBackendErrors(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	r := cachedTestRouter("metrics-cached", time.Minute, time.Minute)
+	get(r, "/api/metrics-cached")
+	get(r, "/api/metrics-cached")
+	get(r, "/api/metrics-cached")
+
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	get(directTestRouter(t, "metrics-direct", http.MethodGet), "/api/metrics-direct")
+
+	exposition := scrapeMetrics(t)
+	assertMetric(t, exposition, `gateway_cache_misses_total{endpoint="metrics-cached"} 1`)
+	assertMetric(t, exposition, `gateway_cache_hits_total{endpoint="metrics-cached"} 2`)
+	assertMetric(t, exposition, `gateway_backend_request_duration_seconds_count{endpoint="metrics-cached"} 1`)
+	assertMetric(t, exposition, `gateway_backend_errors_total{endpoint="metrics-direct"} 1`)
+}
+
+func TestSlowBackendRequestsAreLoggedAndCounted(t *testing.T) {
+	ok := jsonBackend(`{"ok":true}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") == "SLOW" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		ok(w, r)
+	})
+	setForTest(t, &slowRequestThreshold, 20*time.Millisecond)
+	logs := captureLogs(t, slog.LevelInfo)
+	r := cachedTestRouter("slow-prices", time.Minute, time.Minute)
+
+	get(r, "/api/slow-prices?symbol=FAST")
+	get(r, "/api/slow-prices?symbol=SLOW")
+	get(r, "/api/slow-prices?symbol=SLOW")
+
+	lines := logs.lines(t, "Slow backend request")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d slow requests, want only the SLOW backend fetch", len(lines))
+	}
+	if lines[0]["level"] != "WARN" || lines[0]["endpoint"] != "slow-prices" || lines[0]["query"] != "symbol=SLOW" {
+		t.Errorf("slow request logged as %v", lines[0])
+	}
+	if d, err := time.ParseDuration(lines[0]["duration"].(string)); err != nil || d < 50*time.Millisecond {
+		t.Errorf("slow request duration %v, want at least 50ms", lines[0]["duration"])
+	}
+	assertMetric(t, scrapeMetrics(t), `gateway_slow_backend_requests_total{endpoint="slow-prices"} 1`)
+}
@@ -0,0 +1,24 @@
+package main
+
+import "strings"
+
+// cacheNamespace prefixes every Redis key the gateway uses so environments
+// sharing a Redis instance don't collide, from the CACHE_NAMESPACE env var
+// (e.g. prod gives prod:cache:prices:...)
+var cacheNamespace = strings.TrimSuffix(getEnv("CACHE_NAMESPACE", ""), ":")
+
+// namespacedKey prefixes a Redis key with the cache namespace
+func namespacedKey(key string) string {
+	if cacheNamespace == "" {
+		return key
+	}
+	return cacheNamespace + ":" + key
+}
+
+// namespacedPattern prefixes a SCAN pattern with the cache namespace
+func namespacedPattern(pattern string) string {
+	if cacheNamespace == "" {
+		return pattern
+	}
+	return escapeGlob(cacheNamespace) + ":" + pattern
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCacheNamespace(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &cacheNamespace, "prod")
+	admin := adminTestRouter(t)
+	r := cachedTestRouter("ns-prices", time.Minute, time.Minute)
+	seedCache(t, "staging:cache:ns-prices:", "cache:ns-prices:")
+
+	get(r, "/api/ns-prices")
+	if !cacheHas("prod:cache:ns-prices:") || !cacheHas("prod:lkg:ns-prices:") {
+		t.Fatal("entry not written under the prod namespace")
+	}
+	if w := get(r, "/api/ns-prices"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache %q, want a hit read under the namespace", w.Header().Get("X-Cache"))
+	}
+	if stats := getCacheStats(t, admin, ""); stats.Keys["ns-prices"] != 1 {
+		t.Errorf("stats count %d ns-prices keys, want only the namespaced one", stats.Keys["ns-prices"])
+	}
+
+	w := serve(admin, http.MethodPost, "/admin/cache/purge", adminHeader, `{"all":true}`)
+	var resp struct{ Deleted int64 }
+	decodeJSON(t, w, &resp)
+	if resp.Deleted != 1 || cacheHas("prod:cache:ns-prices:") {
+		t.Errorf("purge deleted %d keys, want the 1 prod cache key", resp.Deleted)
+	}
+	if !cacheHas("staging:cache:ns-prices:") || !cacheHas("cache:ns-prices:") {
+		t.Error("purge deleted keys outside the namespace")
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1", hits)
+	}
+}
+
+func TestNamespacedPatternEscapesNamespace(t *testing.T) {
+	setForTest(t, &cacheNamespace, "env[1]")
+	if got := namespacedPattern("cache:*"); got != `env\[1\]:cache:*` {
+		t.Errorf("namespacedPattern = %q, want the namespace escaped", got)
+	}
+	if got := namespacedKey("cache:prices:"); got != "env[1]:cache:prices:" {
+		t.Errorf("namespacedKey = %q", got)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+var (
+	// negativeTTL is how long cacheable error responses are cached, from
+	// the NEGATIVE_TTL env var
+	negativeTTL = getEnvDuration("NEGATIVE_TTL", 60*time.Second)
+
+	// negativeStatuses are the backend status codes that are negatively
+	// cached, from the comma-separated NEGATIVE_CACHE_STATUSES env var
+	negativeStatuses = parseParamSet(getEnv("NEGATIVE_CACHE_STATUSES", "404"))
+)
+
+// isNegativelyCached reports whether a backend response with status should
+// be cached as a negative entry. Server errors never are.
+func isNegativelyCached(status int) bool {
+	if negativeTTL <= 0 || status < 400 || status >= 500 {
+		return false
+	}
+	return negativeStatuses[strconv.Itoa(status)]
+}
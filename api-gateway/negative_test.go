@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// statusBackend returns a handler answering with the status in the status
+// query param
+func statusBackend(w http.ResponseWriter, r *http.Request) {
+	status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write([]byte(`{"detail":"not found"}`))
+}
+
+func TestNegativeCaching(t *testing.T) {
+	backend := newTestBackend(t, statusBackend)
+	setForTest(t, &negativeTTL, time.Minute)
+	setForTest(t, &negativeStatuses, parseParamSet("404,410"))
+	setForTest(t, &backendMaxRetries, 0)
+	r := cachedTestRouter("negative", time.Minute, time.Minute)
+
+	for _, tc := range []struct {
+		status int
+		cached bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusGone, true},
+		{http.StatusBadRequest, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, false},
+	} {
+		target := "/api/negative?status=" + strconv.Itoa(tc.status)
+		hits := backend.hits.Load()
+		get(r, target)
+		w := get(r, target)
+		if cached := backend.hits.Load() == hits+1; cached != tc.cached {
+			t.Errorf("%d: cached %v, want %v", tc.status, cached, tc.cached)
+		}
+		if tc.cached && (w.Code != tc.status || w.Header().Get("X-Cache") != "HIT-NEGATIVE") {
+			t.Errorf("%d: second request status %d, X-Cache %q, want HIT-NEGATIVE", tc.status, w.Code, w.Header().Get("X-Cache"))
+		}
+	}
+}
+
+func TestNegativeEntriesExpireAfterNegativeTTL(t *testing.T) {
+	backend := newTestBackend(t, statusBackend)
+	setForTest(t, &negativeTTL, 30*time.Millisecond)
+	r := cachedTestRouter("negative-ttl", time.Minute, time.Minute)
+
+	get(r, "/api/negative-ttl?status=404")
+	time.Sleep(50 * time.Millisecond)
+	if w := get(r, "/api/negative-ttl?status=404"); w.Header().Get("X-Cache") == "HIT-NEGATIVE" {
+		t.Error("negative entry served past NEGATIVE_TTL")
+	}
+	waitForRefreshes(t)
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// oomError is a Redis error reply, as returned by a server at maxmemory
+type oomError string
+
+func (e oomError) Error() string { return string(e) }
+
+func (oomError) RedisError() {}
+
+// errRedisOOM is the error Redis returns for writes at maxmemory
+var errRedisOOM = oomError("OOM command not allowed when used memory > 'maxmemory'.")
+
+// oomCache wraps a Cache, rejecting every write with errRedisOOM
+type oomCache struct {
+	Cache
+	sets atomic.Int64
+}
+
+func (c *oomCache) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	c.sets.Add(1)
+	return errRedisOOM
+}
+
+func TestRedisOOMPausesWrites(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	cache := &oomCache{Cache: newMemoryCache(0)}
+	setForTest[Cache](t, &cacheStore, cache)
+	t.Cleanup(func() { redisWritesPausedUntil.Store(0) })
+	logs := captureLogs(t, slog.LevelInfo)
+	r := cachedTestRouter("oom-prices", time.Minute, 0)
+
+	// Written before Redis filled up, so still readable
+	fresh := cacheEntry{Body: []byte(`{"cached":true}`), ContentType: "application/json", SoftExpiry: time.Now().Add(time.Minute)}
+	data, _ := json.Marshal(fresh)
+	cache.Cache.Set(context.Background(), namespacedKey("cache:oom-prices:symbol=BTC"), data, time.Minute)
+
+	if w := get(r, "/api/oom-prices?symbol=ETH"); w.Code != 200 || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("status %d X-Cache %q, want a MISS served despite the failed write", w.Code, w.Header().Get("X-Cache"))
+	}
+	if !redisWritesPaused() {
+		t.Fatal("writes not paused after an OOM error")
+	}
+	attempted := cache.sets.Load()
+
+	for i := 0; i < 3; i++ {
+		if w := get(r, fmt.Sprintf("/api/oom-prices?symbol=SOL%d", i)); w.Code != 200 {
+			t.Fatalf("status %d while writes are paused", w.Code)
+		}
+	}
+	if got := cache.sets.Load(); got != attempted {
+		t.Errorf("%d writes attempted while paused, want none", got-attempted)
+	}
+	if w := get(r, "/api/oom-prices?symbol=BTC"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"cached":true}` {
+		t.Errorf("X-Cache %q body %s, want reads to continue while writes are paused", w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want 4", hits)
+	}
+	if n := len(logs.lines(t, "Redis out of memory, pausing cache writes")); n != 1 {
+		t.Errorf("OOM logged %d times, want once per pause", n)
+	}
+
+	redisWritesPausedUntil.Store(time.Now().Add(-time.Second).UnixNano())
+	get(r, "/api/oom-prices?symbol=ADA")
+	if got := cache.sets.Load(); got == attempted {
+		t.Error("writes not retried after the cooldown")
+	}
+}
+
+func TestIsRedisOOMError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"oom reply", errRedisOOM, true},
+		{"wrapped oom reply", fmt.Errorf("set: %w", errRedisOOM), true},
+		{"other reply", oomError("ERR wrong number of arguments"), false},
+		{"plain error", errors.New("OOM but not a reply"), false},
+		{"connection error", errCacheDown, false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRedisOOMError(tt.err); got != tt.want {
+				t.Errorf("isRedisOOMError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
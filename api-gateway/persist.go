@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	// cacheSnapshotEnabled turns on persisting the cache to a file so it
+	// is warm after a restart, from the CACHE_SNAPSHOT env var
+	cacheSnapshotEnabled = getEnvBool("CACHE_SNAPSHOT", false)
+
+	// cacheSnapshotPath is the file the cache is persisted to, from the
+	// CACHE_SNAPSHOT_PATH env var
+	cacheSnapshotPath = getEnv("CACHE_SNAPSHOT_PATH", "cache-snapshot.json")
+
+	// cacheSnapshotInterval is how often the cache is persisted, from the
+	// CACHE_SNAPSHOT_INTERVAL env var
+	cacheSnapshotInterval = getEnvDuration("CACHE_SNAPSHOT_INTERVAL", 5*time.Minute)
+
+	// cacheSnapshotTimeout bounds writing or restoring a snapshot, from the
+	// CACHE_SNAPSHOT_TIMEOUT env var
+	cacheSnapshotTimeout = getEnvDuration("CACHE_SNAPSHOT_TIMEOUT", 30*time.Second)
+)
+
+// cacheSnapshotPatterns match the keys persisted in cache snapshots: the
+// cached responses and their last known good copies
+var cacheSnapshotPatterns = []string{"cache:*", "lkg:*"}
+
+// cacheSnapshot is the file format of a persisted cache
+type cacheSnapshot struct {
+	WrittenAt time.Time            `json:"written_at"`
+	Entries   []cacheSnapshotEntry `json:"entries"`
+}
+
+// cacheSnapshotEntry is one persisted cache key with its stored value
+type cacheSnapshotEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+
+	// ExpiresAt is when the key expires, zero if it does not
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// startCacheSnapshots restores the cache from the snapshot file and then
+// persists it every cacheSnapshotInterval, when CACHE_SNAPSHOT is set. The
+// restore finishes before it returns so the cache is warm before serving.
+func startCacheSnapshots() {
+	if !cacheSnapshotEnabled {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cacheSnapshotTimeout)
+	restored, err := restoreCacheSnapshot(ctx, cacheSnapshotPath)
+	cancel()
+	if err != nil {
+		slog.Warn("Error restoring cache snapshot", "path", cacheSnapshotPath, "error", err)
+	} else {
+		slog.Info("Restored cache snapshot", "path", cacheSnapshotPath, "keys", restored)
+	}
+
+	if cacheSnapshotInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cacheSnapshotInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			saveCacheSnapshot()
+		}
+	}()
+}
+
+// saveCacheSnapshot persists the cache to the snapshot file, logging the
+// outcome
+func saveCacheSnapshot() {
+	ctx, cancel := context.WithTimeout(context.Background(), cacheSnapshotTimeout)
+	defer cancel()
+	written, err := writeCacheSnapshot(ctx, cacheSnapshotPath)
+	if err != nil {
+		slog.Warn("Error writing cache snapshot", "path", cacheSnapshotPath, "error", err)
+		return
+	}
+	slog.Debug("Wrote cache snapshot", "path", cacheSnapshotPath, "keys", written)
+}
+
+// writeCacheSnapshot writes the cached responses to path, replacing it
+// atomically, and returns the number of keys written
+func writeCacheSnapshot(ctx context.Context, path string) (int, error) {
+	snapshot := cacheSnapshot{WrittenAt: time.Now(), Entries: []cacheSnapshotEntry{}}
+	for _, pattern := range cacheSnapshotPatterns {
+		err := cacheStore.Scan(ctx, namespacedPattern(pattern), func(keys []string) error {
+			for _, key := range keys {
+				value, ttl, err := cacheStore.Get(ctx, key)
+				if err == errCacheMiss {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				entry := cacheSnapshotEntry{Key: key, Value: value}
+				if ttl > 0 {
+					entry.ExpiresAt = snapshot.WrittenAt.Add(ttl)
+				}
+				snapshot.Entries = append(snapshot.Entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("scanning cache: %w", err)
+		}
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return len(snapshot.Entries), nil
+}
+
+// restoreCacheSnapshot stores the unexpired keys of the snapshot at path
+// that are not already cached, with their remaining TTLs, and returns the
+// number of keys restored. A missing snapshot restores nothing.
+func restoreCacheSnapshot(ctx context.Context, path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var snapshot cacheSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	restored := 0
+	now := time.Now()
+	for _, entry := range snapshot.Entries {
+		var ttl time.Duration
+		if !entry.ExpiresAt.IsZero() {
+			if ttl = entry.ExpiresAt.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		// Keys cached since the snapshot are newer
+		if _, _, err := cacheStore.Get(ctx, entry.Key); err != errCacheMiss {
+			if err != nil {
+				return restored, err
+			}
+			continue
+		}
+		if err := cacheStore.Set(ctx, entry.Key, entry.Value, ttl); err != nil {
+			return restored, err
+		}
+		restored++
+	}
+	return restored, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	server := newTestRedis(t)
+	ctx := context.Background()
+	r := cachedTestRouter("snapshot-prices", time.Minute, time.Minute)
+	get(r, "/api/snapshot-prices?symbol=BTC")
+	if err := cacheStore.Set(ctx, namespacedKey("ratelimit:192.0.2.1"), []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	written, err := writeCacheSnapshot(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot cacheSnapshot
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if written == 0 || len(snapshot.Entries) != written {
+		t.Fatalf("wrote %d keys, snapshot has %d", written, len(snapshot.Entries))
+	}
+	for _, entry := range snapshot.Entries {
+		if entry.Key == namespacedKey("ratelimit:192.0.2.1") {
+			t.Errorf("snapshot has non-cache key %s", entry.Key)
+		}
+	}
+
+	// A Redis restart loses everything
+	server.FlushAll()
+	restored, err := restoreCacheSnapshot(ctx, path)
+	if err != nil || restored != written {
+		t.Fatalf("restored %d keys, %v, want %d", restored, err, written)
+	}
+	cacheKey := cacheKeyFor("snapshot-prices", "symbol=BTC")
+	if _, ttl, err := cacheStore.Get(ctx, cacheKey); err != nil || ttl <= 0 || ttl > 2*time.Minute {
+		t.Errorf("restored %s with TTL %v, %v, want its remaining TTL", cacheKey, ttl, err)
+	}
+	w := get(r, "/api/snapshot-prices?symbol=BTC")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"BTC":50000}` {
+		t.Errorf("after restore: X-Cache %q, body %s, want a HIT", w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want the restored cache used", hits)
+	}
+}
+
+func TestRestoreCacheSnapshotSkipsExpiredAndNewerKeys(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	ctx := context.Background()
+	now := time.Now()
+	snapshot, _ := json.Marshal(cacheSnapshot{WrittenAt: now, Entries: []cacheSnapshotEntry{
+		{Key: namespacedKey("cache:a:"), Value: []byte("a"), ExpiresAt: now.Add(time.Minute)},
+		{Key: namespacedKey("cache:b:"), Value: []byte("b"), ExpiresAt: now.Add(-time.Second)},
+		{Key: namespacedKey("cache:c:"), Value: []byte("old")},
+		{Key: namespacedKey("lkg:cache:d:"), Value: []byte("d")},
+	}})
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := os.WriteFile(path, snapshot, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cacheStore.Set(ctx, namespacedKey("cache:c:"), []byte("new"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := restoreCacheSnapshot(ctx, path)
+	if err != nil || restored != 2 {
+		t.Fatalf("restored %d keys, %v, want the unexpired, uncached ones", restored, err)
+	}
+	for key, want := range map[string]string{"cache:a:": "a", "cache:c:": "new", "lkg:cache:d:": "d"} {
+		if value, _, err := cacheStore.Get(ctx, namespacedKey(key)); err != nil || string(value) != want {
+			t.Errorf("%s = %q, %v, want %q", key, value, err, want)
+		}
+	}
+	if _, _, err := cacheStore.Get(ctx, namespacedKey("cache:b:")); err != errCacheMiss {
+		t.Errorf("expired key restored: %v", err)
+	}
+}
+
+func TestRestoreCacheSnapshotErrors(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	dir := t.TempDir()
+	if restored, err := restoreCacheSnapshot(context.Background(), filepath.Join(dir, "missing.json")); restored != 0 || err != nil {
+		t.Errorf("missing snapshot: restored %d, %v, want nothing", restored, err)
+	}
+	corrupt := filepath.Join(dir, "corrupt.json")
+	if err := os.WriteFile(corrupt, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreCacheSnapshot(context.Background(), corrupt); err == nil {
+		t.Error("corrupt snapshot restored without error")
+	}
+}
+
+func TestStartCacheSnapshotsRestoresOnBoot(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	key := namespacedKey("cache:boot:")
+	if err := cacheStore.Set(ctx, key, []byte("warm"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &cacheSnapshotPath, path)
+	setForTest(t, &cacheSnapshotInterval, 0)
+	saveCacheSnapshot()
+	setForTest[Cache](t, &cacheStore, newMemoryCache(0))
+
+	setForTest(t, &cacheSnapshotEnabled, false)
+	startCacheSnapshots()
+	if _, _, err := cacheStore.Get(ctx, key); err != errCacheMiss {
+		t.Errorf("restored with CACHE_SNAPSHOT off: %v", err)
+	}
+	setForTest(t, &cacheSnapshotEnabled, true)
+	startCacheSnapshots()
+	if value, _, err := cacheStore.Get(ctx, key); err != nil || string(value) != "warm" {
+		t.Errorf("after boot %s = %q, %v, want it restored", key, value, err)
+	}
+}
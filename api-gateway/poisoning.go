@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// unkeyedHeaders are request headers backends may read, e.g. to build
+// links, but that are not part of cache keys, from the comma-separated
+// UNKEYED_HEADERS env var. Caching a response to a request carrying one
+// would serve whatever it changed to every other client. Forwarded and
+// X-Forwarded-* are not among them: load balancers set them on every
+// request, and cached fetches only pass keyed headers to backends, so they
+// are stripped rather than bypassing the cache.
+var unkeyedHeaders = parseHeaderList(getEnv("UNKEYED_HEADERS",
+	"X-Host,X-Original-URL,X-Rewrite-URL,X-HTTP-Method-Override"))
+
+// parseHeaderList splits a comma-separated list of header names into their
+// canonical form
+func parseHeaderList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
+
+// routeUnkeyedHeaders returns the headers that make a request to endpoint
+// uncacheable: unkeyedHeaders plus the endpoint's own from its
+// UNKEYED_HEADERS_<ENDPOINT> env var (e.g. UNKEYED_HEADERS_NEWS=X-Region),
+// except those in vary, which are keyed
+func routeUnkeyedHeaders(endpoint string, vary []string) []string {
+	keyed := map[string]bool{}
+	for _, name := range vary {
+		keyed[name] = true
+	}
+	var names []string
+	for _, name := range append(unkeyedHeaders, parseHeaderList(getEnv(endpointEnvKey("UNKEYED_HEADERS_", endpoint), ""))...) {
+		if !keyed[name] {
+			keyed[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// presentHeaders returns the names that are set in header
+func presentHeaders(header http.Header, names []string) []string {
+	var present []string
+	for _, name := range names {
+		if len(header.Values(name)) > 0 {
+			present = append(present, name)
+		}
+	}
+	return present
+}
+
+// proxyUnkeyed fetches a cached endpoint's response from the backend for a
+// request carrying the unkeyed headers names, which are forwarded with it,
+// without reading or writing the cache
+func proxyUnkeyed(c *gin.Context, endpoint, query string, header http.Header, body []byte, names []string, varyHeader string) {
+	sampledLog.Warn("Request has unkeyed headers, bypassing cache", "endpoint", endpoint, "headers", names,
+		"request_id", c.GetString("request_id"))
+
+	forwarded := header.Clone()
+	if forwarded == nil {
+		forwarded = http.Header{}
+	}
+	for _, name := range names {
+		for _, v := range c.Request.Header.Values(name) {
+			forwarded.Add(name, v)
+		}
+	}
+	uri, err := backendURI(endpoint, query)
+	if err != nil {
+		respondBackendError(c, err)
+		return
+	}
+
+	start := time.Now()
+	resp, err := fetchBackend(c.Request.Context(), endpoint, uri, query, withClientIP(c, forwarded), body)
+	c.Set("backend_latency", time.Since(start))
+	if err != nil {
+		respondBackendError(c, err)
+		return
+	}
+	resp.copyHeaders(c)
+	// Shared caches in front of the gateway must not keep it either
+	c.Header("Cache-Control", "private, no-store")
+	setCacheStatus(c, endpoint, "UNKEYED")
+	c.Header("Vary", varyHeader)
+	if resp.status >= http.StatusBadRequest {
+		respondBackendStatus(c, resp.status, resp.body)
+		return
+	}
+	resp.write(c)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnkeyedHeadersAreNotCached(t *testing.T) {
+	backend := newTestBackend(t, linkBackend("X-Host"))
+	r := cachedTestRouter("poison-prices", time.Minute, time.Minute)
+	attack := http.Header{"X-Host": {"evil.example"}}
+	clean := `{"link":"https://api.example.com/prices"}`
+	poisoned := `{"link":"https://evil.example/prices"}`
+
+	for i, tc := range []struct {
+		header http.Header
+		cache  string
+		body   string
+	}{
+		{attack, "UNKEYED", poisoned},
+		{nil, "MISS", clean},
+		{attack, "UNKEYED", poisoned},
+		{nil, "HIT", clean},
+	} {
+		w := serve(r, http.MethodGet, "/api/poison-prices", tc.header, "")
+		if w.Header().Get("X-Cache") != tc.cache || w.Body.String() != tc.body {
+			t.Errorf("request %d: X-Cache %q, body %s, want %s %s", i, w.Header().Get("X-Cache"), w.Body, tc.cache, tc.body)
+		}
+		if tc.cache == "UNKEYED" && w.Header().Get("Cache-Control") != "private, no-store" {
+			t.Errorf("request %d: Cache-Control %q, want shared caches told not to store it", i, w.Header().Get("Cache-Control"))
+		}
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want every unkeyed request fetched", hits)
+	}
+}
+
+func TestForwardingHeadersAreStrippedAndCached(t *testing.T) {
+	backend := newTestBackend(t, linkBackend("X-Forwarded-Host"))
+	r := cachedTestRouter("poison-forwarded", time.Minute, time.Minute)
+	clean := `{"link":"https://api.example.com/prices"}`
+
+	for i, tc := range []struct {
+		header http.Header
+		cache  string
+	}{
+		{http.Header{"X-Forwarded-Proto": {"https"}}, "MISS"},
+		{http.Header{"X-Forwarded-Proto": {"https"}}, "HIT"},
+		{http.Header{"X-Forwarded-Host": {"evil.example"}, "Forwarded": {"host=evil.example"}}, "HIT"},
+	} {
+		w := serve(r, http.MethodGet, "/api/poison-forwarded", tc.header, "")
+		if w.Header().Get("X-Cache") != tc.cache || w.Body.String() != clean {
+			t.Errorf("request %d: X-Cache %q, body %s, want %s %s", i, w.Header().Get("X-Cache"), w.Body, tc.cache, clean)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1", hits)
+	}
+}
+
+// linkBackend returns a handler answering with a link to the host in the
+// header name, or api.example.com without it
+func linkBackend(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Header.Get(name)
+		if host == "" {
+			host = "api.example.com"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"link":"https://` + host + `/prices"}`))
+	}
+}
+
+func TestRouteUnkeyedHeaders(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	t.Setenv("UNKEYED_HEADERS_POISON_REGION", "x-region")
+	regional := cachedTestRouter("poison-region", time.Minute, time.Minute)
+	other := cachedTestRouter("poison-other", time.Minute, time.Minute)
+	header := http.Header{"X-Region": {"eu"}}
+
+	for i := 0; i < 2; i++ {
+		if w := serve(regional, http.MethodGet, "/api/poison-region", header, ""); w.Header().Get("X-Cache") != "UNKEYED" {
+			t.Errorf("X-Region to its endpoint: X-Cache %q, want UNKEYED", w.Header().Get("X-Cache"))
+		}
+	}
+	serve(other, http.MethodGet, "/api/poison-other", header, "")
+	if w := serve(other, http.MethodGet, "/api/poison-other", header, ""); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Region to another endpoint: X-Cache %q, want it cached", w.Header().Get("X-Cache"))
+	}
+
+	setForTest(t, &unkeyedHeaders, parseHeaderList("x-forwarded-host, X-Host"))
+	if got, want := routeUnkeyedHeaders("poison-region", nil), []string{"X-Forwarded-Host", "X-Host", "X-Region"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unkeyed headers %v, want %v", got, want)
+	}
+	if got, want := routeUnkeyedHeaders("poison-region", []string{"X-Forwarded-Host"}), []string{"X-Host", "X-Region"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unkeyed headers of a route varying by X-Forwarded-Host %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// prettyParam is the query param asking for indented JSON responses
+const prettyParam = "pretty"
+
+// minifyJSON compacts JSON backend responses before they are cached and
+// served, from the MINIFY_JSON env var
+var minifyJSON = getEnvBool("MINIFY_JSON", true)
+
+// minifyBody returns body with insignificant whitespace removed, or body
+// unchanged if it isn't valid JSON
+func minifyBody(body []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil || buf.Len() == len(body) {
+		return body, false
+	}
+	return buf.Bytes(), true
+}
+
+// prettyJSON creates a middleware that indents JSON responses for requests
+// with a truthy pretty param, e.g. ?pretty=1, for debugging. The param is
+// removed from the request so it neither reaches the backend nor changes
+// the cache key, and cached bodies keep their compact form. Streamed
+// responses are sent unchanged.
+func prettyJSON() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		values, err := url.ParseQuery(c.Request.URL.RawQuery)
+		if err != nil || !values.Has(prettyParam) {
+			c.Next()
+			return
+		}
+		pretty := isTruthy(values.Get(prettyParam))
+		values.Del(prettyParam)
+		c.Request.URL.RawQuery = values.Encode()
+		if !pretty || c.IsWebsocket() {
+			c.Next()
+			return
+		}
+
+		w := &prettyResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+			w.finish()
+		}()
+		c.Next()
+	}
+}
+
+// prettyResponseWriter buffers a response body so prettyJSON can indent it
+// once the handler has finished
+type prettyResponseWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	streaming bool // flushed by the handler, so sent unchanged
+}
+
+// Write buffers data until finish
+func (w *prettyResponseWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+// WriteString buffers s until finish
+func (w *prettyResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends the response so far unchanged and stops buffering
+func (w *prettyResponseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		if w.body.Len() > 0 {
+			w.ResponseWriter.Write(w.body.Bytes())
+			w.body.Reset()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// finish writes the buffered body, indented if it is JSON. Compressed
+// bodies, such as cached entries, are decompressed first.
+func (w *prettyResponseWriter) finish() {
+	if w.streaming || w.body.Len() == 0 {
+		return
+	}
+	body := w.body.Bytes()
+	header := w.Header()
+	if isJSONContentType(header.Get("Content-Type")) {
+		if indented, ok := indentBody(body, header.Get("Content-Encoding")); ok {
+			body = indented
+			header.Del("Content-Encoding")
+			header.Set("Content-Length", strconv.Itoa(len(body)))
+			// Same content, different bytes
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+	w.ResponseWriter.Write(body)
+}
+
+// indentBody returns a JSON body, encoded with encoding, indented by two
+// spaces, or false if it can't be decoded
+func indentBody(body []byte, encoding string) ([]byte, bool) {
+	body, err := decodeBody(body, encoding)
+	if err != nil {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, body, "", "  "); err != nil {
+		return nil, false
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), true
+}
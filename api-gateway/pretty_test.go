@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPrettyJSON(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{ "b": [1, 2],  "a": "x" }`))
+	})
+	setForTest(t, &minifyJSON, true)
+	setForTest(t, &cacheCompressMinBytes, 1)
+	r := gin.New()
+	r.Use(prettyJSON())
+	r.GET("/api/pretty-prices", cachedProxy("pretty-prices", time.Minute, time.Minute, 0))
+	minified := `{"b":[1,2],"a":"x"}`
+	indented := "{\n  \"b\": [\n    1,\n    2\n  ],\n  \"a\": \"x\"\n}\n"
+
+	for i, tc := range []struct {
+		target string
+		cache  string
+		body   string
+	}{
+		{"/api/pretty-prices?symbol=BTC&pretty=1", "MISS", indented},
+		{"/api/pretty-prices?symbol=BTC", "HIT", minified},
+		{"/api/pretty-prices?pretty=true&symbol=BTC", "HIT", indented},
+		{"/api/pretty-prices?symbol=BTC&pretty=0", "HIT", minified},
+	} {
+		w := get(r, tc.target)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tc.cache || w.Body.String() != tc.body {
+			t.Errorf("request %d %s: status %d, X-Cache %q, body %q, want %s %q", i, tc.target, w.Code, w.Header().Get("X-Cache"), w.Body, tc.cache, tc.body)
+		}
+		if tc.body == indented {
+			if cl := w.Header().Get("Content-Length"); cl != strconv.Itoa(len(indented)) || w.Header().Get("Content-Encoding") != "" {
+				t.Errorf("request %d: Content-Length %q, Content-Encoding %q for the indented body", i, cl, w.Header().Get("Content-Encoding"))
+			}
+			if etag := w.Header().Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+				t.Errorf("request %d: ETag %q, want a weak one for the reformatted body", i, etag)
+			}
+		}
+	}
+
+	mu.Lock()
+	if len(queries) != 1 || strings.Contains(queries[0], "pretty") {
+		t.Errorf("backend queries %v, want one without the pretty param", queries)
+	}
+	mu.Unlock()
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want pretty to share the cache entry", hits)
+	}
+	var entry cacheEntry
+	data, _, err := cacheStore.Get(context.Background(), cacheKeyFor("pretty-prices", "symbol=BTC"))
+	if err != nil || json.Unmarshal(data, &entry) != nil {
+		t.Fatalf("reading cache entry: %v", err)
+	}
+	if body, err := decodeBody(entry.Body, entry.Encoding); err != nil || string(body) != minified {
+		t.Errorf("cached body %q, %v, want the minified form", body, err)
+	}
+}
+
+func TestPrettyJSONLeavesOtherBodiesUnchanged(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("{ not json }"))
+	})
+	r := gin.New()
+	r.Use(prettyJSON())
+	r.GET("/api/pretty-text", cachedProxy("pretty-text", time.Minute, time.Minute, 0))
+	if w := get(r, "/api/pretty-text?pretty=1"); w.Body.String() != "{ not json }" {
+		t.Errorf("text body %q, want it unchanged", w.Body)
+	}
+}
+
+func TestMinifyBody(t *testing.T) {
+	if body, ok := minifyBody([]byte(`{ "a" : [ 1, 2 ] }`)); !ok || string(body) != `{"a":[1,2]}` {
+		t.Errorf("minified %q, %v", body, ok)
+	}
+	if body, ok := minifyBody([]byte(`{"a":1}`)); ok || string(body) != `{"a":1}` {
+		t.Errorf("already minified body: %q, %v, want it unchanged", body, ok)
+	}
+	if body, ok := minifyBody([]byte("not json")); ok || string(body) != "not json" {
+		t.Errorf("invalid JSON: %q, %v, want it unchanged", body, ok)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// slowRequestThreshold is the backend fetch duration above which requests
+// are logged and counted as slow
+var slowRequestThreshold = getEnvDuration("SLOW_REQUEST_THRESHOLD", 2*time.Second)
+
+// responseHeaderAllowlist are the backend response headers passed to
+// clients, from the comma-separated RESPONSE_HEADER_ALLOWLIST env var. It is
+// nil when set to "*", passing all headers.
+var responseHeaderAllowlist = parseHeaderAllowlist(getEnv("RESPONSE_HEADER_ALLOWLIST", "Content-Type,Content-Length,Cache-Control,ETag"))
+
+// parseHeaderAllowlist splits a comma-separated list of header names into a
+// set of canonical names, returning nil for "*"
+func parseHeaderAllowlist(value string) map[string]bool {
+	if strings.TrimSpace(value) == "*" {
+		return nil
+	}
+	set := map[string]bool{}
+	for name := range parseParamSet(value) {
+		set[http.CanonicalHeaderKey(name)] = true
+	}
+	return set
+}
+
+// backendResponse is a backend response with its body fully read
+type backendResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	fetchedAt time.Time
+}
+
+// withClientIP returns a copy of header telling the backend the client's
+// address in X-Forwarded-For and X-Real-IP. An X-Forwarded-For sent with
+// the request is appended to only if it came from a trusted proxy, and
+// replaced otherwise so clients cannot spoof it.
+func withClientIP(c *gin.Context, header http.Header) http.Header {
+	forwarded := header.Clone()
+	if forwarded == nil {
+		forwarded = http.Header{}
+	}
+	peer := c.RemoteIP()
+	chain := peer
+	if ipAllowed(peer, trustedProxyCIDRs) {
+		if prior := strings.Join(c.Request.Header.Values("X-Forwarded-For"), ", "); prior != "" {
+			chain = prior + ", " + peer
+		}
+	}
+	forwarded.Set("X-Forwarded-For", chain)
+	forwarded.Set("X-Real-IP", c.ClientIP())
+	return forwarded
+}
+
+// endpointFetchers fetch the responses of cached endpoints that are not a
+// single backend request
+var endpointFetchers = map[string]func(ctx context.Context, rawQuery string, header http.Header) (*backendResponse, error){}
+
+// jsonResponse returns a backendResponse with a JSON body built by the
+// gateway
+func jsonResponse(status int, body []byte) *backendResponse {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	return &backendResponse{status: status, header: header, body: body, fetchedAt: time.Now()}
+}
+
+// proxyRequest sends a request for uri (path and query) to the backend and
+// reads the response, recording backend metrics under endpoint. It is used
+// wherever the body is needed whole, such as for caching.
+func proxyRequest(ctx context.Context, endpoint, method, uri string, header http.Header, body []byte) (*backendResponse, error) {
+	release, err := acquireEndpointSlot(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	resp, err := doBackendRequest(ctx, endpointBackends(endpoint), method, uri, header, body)
+	if err != nil {
+		backendErrors.WithLabelValues(endpoint).Inc()
+		return nil, fmt.Errorf("Error proxying request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	respBody, err := readResponseBody(resp.Body)
+	elapsed := time.Since(start)
+	backendLatency.WithLabelValues(endpoint).Observe(elapsed.Seconds())
+	observeSlowRequest(endpoint, uri, elapsed)
+	if err != nil {
+		backendErrors.WithLabelValues(endpoint).Inc()
+		return nil, fmt.Errorf("Error reading response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		backendErrors.WithLabelValues(endpoint).Inc()
+	}
+
+	return &backendResponse{status: resp.StatusCode, header: resp.Header, body: respBody, fetchedAt: time.Now()}, nil
+}
+
+// streamRequest sends a request for uri to the backend like proxyRequest,
+// but copies the response body to the client as it arrives instead of
+// buffering it. Errors are returned only if they happen before the response
+// status is sent; later ones are logged.
+func streamRequest(c *gin.Context, endpoint, method, uri string, header http.Header, body []byte) error {
+	ctx := c.Request.Context()
+	release, err := acquireEndpointSlot(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	start := time.Now()
+	resp, err := doBackendRequest(ctx, endpointBackends(endpoint), method, uri, header, body)
+	if err != nil {
+		backendErrors.WithLabelValues(endpoint).Inc()
+		return fmt.Errorf("Error proxying request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		backendErrors.WithLabelValues(endpoint).Inc()
+	}
+
+	if resp.StatusCode == http.StatusOK && resp.ContentLength == 0 {
+		// Served as 204 like empty cached responses
+		resp.StatusCode = http.StatusNoContent
+		resp.Header.Del("Content-Type")
+		resp.Header.Del("Content-Length")
+	}
+	copyResponseHeaders(c, resp.Header)
+	if resp.StatusCode >= http.StatusBadRequest {
+		// Error bodies are read whole to wrap them in the error envelope
+		var errBody []byte
+		errBody, err = readResponseBody(resp.Body)
+		respondBackendStatus(c, resp.StatusCode, errBody)
+	} else {
+		if c.Writer.Header().Get("Content-Type") == "" && resp.Header.Get("Content-Type") != "" {
+			c.Header("Content-Type", resp.Header.Get("Content-Type"))
+		}
+		c.Status(resp.StatusCode)
+		_, err = io.Copy(flushWriter{c.Writer}, resp.Body)
+	}
+
+	elapsed := time.Since(start)
+	backendLatency.WithLabelValues(endpoint).Observe(elapsed.Seconds())
+	observeSlowRequest(endpoint, uri, elapsed)
+	if err != nil {
+		backendErrors.WithLabelValues(endpoint).Inc()
+		sampledLog.Warn("Error streaming response", "endpoint", endpoint, "error", err)
+	}
+	return nil
+}
+
+// flushWriter flushes the client response after every write so streamed
+// bytes are sent as soon as they arrive
+type flushWriter struct {
+	w gin.ResponseWriter
+}
+
+// Write writes p to the client and flushes it
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.w.Flush()
+	return n, err
+}
+
+// observeSlowRequest logs and counts a backend fetch of uri that took
+// longer than slowRequestThreshold
+func observeSlowRequest(endpoint, uri string, elapsed time.Duration) {
+	if slowRequestThreshold <= 0 || elapsed <= slowRequestThreshold {
+		return
+	}
+	slowRequests.WithLabelValues(endpoint).Inc()
+	_, query, _ := strings.Cut(uri, "?")
+	slog.Warn("Slow backend request", "endpoint", endpoint, "query", query, "duration", elapsed.String())
+}
+
+// lastModified returns the backend's Last-Modified time, or when the
+// response was fetched if the backend didn't send a valid one
+func (r *backendResponse) lastModified() time.Time {
+	if t, err := http.ParseTime(r.header.Get("Last-Modified")); err == nil {
+		return t
+	}
+	return r.fetchedAt
+}
+
+// copyHeaders copies the allowed backend response headers to the client
+// response, so backend internals such as Server or Set-Cookie don't leak
+func (r *backendResponse) copyHeaders(c *gin.Context) {
+	copyResponseHeaders(c, r.header)
+}
+
+// copyResponseHeaders copies the allowed headers of a backend response to
+// the client response
+func copyResponseHeaders(c *gin.Context, header http.Header) {
+	for k, v := range header {
+		if responseHeaderAllowlist != nil && !responseHeaderAllowlist[k] {
+			continue
+		}
+		for _, vv := range v {
+			c.Header(k, vv)
+		}
+	}
+}
+
+// write sends the backend response to the client
+func (r *backendResponse) write(c *gin.Context) {
+	if r.status == http.StatusNoContent {
+		c.Status(r.status)
+		return
+	}
+	c.Data(r.status, r.header.Get("Content-Type"), r.body)
+}
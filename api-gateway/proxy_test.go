@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCachedAndDirectProxyRespondIdentically(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=30")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"status":` + strconv.Itoa(status) + `}`))
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	cached := cachedTestRouter("core-cached", time.Minute, time.Minute)
+	direct := directTestRouter(t, "core-direct", http.MethodGet)
+
+	for _, status := range []int{http.StatusOK, http.StatusNotFound, http.StatusServiceUnavailable} {
+		query := "?status=" + strconv.Itoa(status)
+		c := get(cached, "/api/core-cached"+query)
+		d := get(direct, "/api/core-direct"+query)
+		if c.Code != status || d.Code != status {
+			t.Errorf("%d: cached status %d, direct status %d", status, c.Code, d.Code)
+		}
+		if c.Body.String() != d.Body.String() {
+			t.Errorf("%d: cached body %s, direct body %s", status, c.Body, d.Body)
+		}
+		if c.Header().Get("Content-Type") == "" {
+			t.Errorf("%d: no Content-Type", status)
+		}
+		for _, name := range []string{"Content-Type", "Cache-Control"} {
+			if c.Header().Get(name) != d.Header().Get(name) {
+				t.Errorf("%d: cached %s %q, direct %q", status, name, c.Header().Get(name), d.Header().Get(name))
+			}
+		}
+	}
+}
+
+func TestResponseHeaderAllowlist(t *testing.T) {
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "backend/1.2.3")
+		w.Header().Set("X-Debug-Query-Ms", "12")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("Cache-Control", "max-age=30")
+		w.Header().Set("ETag", `"v1"`)
+		jsonBackend(`{"ok":true}`)(w, r)
+	})
+	r := directTestRouter(t, "header-allowlist", http.MethodGet)
+
+	w := get(r, "/api/header-allowlist")
+	for _, name := range []string{"Server", "X-Debug-Query-Ms", "Set-Cookie"} {
+		if value := w.Header().Get(name); value != "" {
+			t.Errorf("blocked header %s passed through as %q", name, value)
+		}
+	}
+	for name, want := range map[string]string{"Content-Type": "application/json", "Cache-Control": "max-age=30", "ETag": `"v1"`} {
+		if value := w.Header().Get(name); value != want {
+			t.Errorf("allowed header %s = %q, want %q", name, value, want)
+		}
+	}
+
+	setForTest(t, &responseHeaderAllowlist, parseHeaderAllowlist(" * "))
+	w = get(r, "/api/header-allowlist")
+	if w.Header().Get("Server") != "backend/1.2.3" || w.Header().Get("X-Debug-Query-Ms") != "12" {
+		t.Errorf("headers %v with RESPONSE_HEADER_ALLOWLIST=*, want all backend headers", w.Header())
+	}
+}
+
+func TestParseHeaderAllowlist(t *testing.T) {
+	allowlist := parseHeaderAllowlist("content-type, x-rate-limit-remaining,")
+	if len(allowlist) != 2 || !allowlist["Content-Type"] || !allowlist["X-Rate-Limit-Remaining"] {
+		t.Errorf("parseHeaderAllowlist = %v, want the canonical names", allowlist)
+	}
+}
+
+func TestDirectProxyStreamsResponses(t *testing.T) {
+	release := make(chan struct{})
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("X-Backend-Version", "2")
+		io.WriteString(w, "{\"tick\":1}\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "{\"tick\":2}\n")
+	})
+	setForTest(t, &responseHeaderAllowlist, parseHeaderAllowlist("Content-Type,X-Backend-Version"))
+	gateway := httptest.NewServer(directTestRouter(t, "stream-ticks", http.MethodGet))
+	t.Cleanup(gateway.Close)
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	t.Cleanup(unblock)
+
+	resp, err := http.Get(gateway.URL + "/api/stream-ticks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || resp.Header.Get("X-Backend-Version") != "2" {
+		t.Errorf("headers %v, want the backend's forwarded", resp.Header)
+	}
+
+	// The first line arrives while the backend is still holding the rest
+	reader := bufio.NewReader(resp.Body)
+	first := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		first <- line
+	}()
+	select {
+	case line := <-first:
+		if line != "{\"tick\":1}\n" {
+			t.Errorf("first line %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("first line not received before the backend finished")
+	}
+
+	unblock()
+	rest, err := io.ReadAll(reader)
+	if err != nil || string(rest) != "{\"tick\":2}\n" {
+		t.Errorf("rest of the body %q, %v", rest, err)
+	}
+}
+
+func TestProxiesForwardClientIP(t *testing.T) {
+	var mu sync.Mutex
+	var received http.Header
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = r.Header.Clone()
+		mu.Unlock()
+		w.Write([]byte(`{}`))
+	})
+	setForTest(t, &trustedProxyCIDRs, parseCIDRs("TRUSTED_PROXY_CIDRS", "10.0.0.0/8"))
+	cached := cachedTestRouter("forwarded-cached", time.Minute, time.Minute)
+	direct := directTestRouter(t, "forwarded-direct", http.MethodGet)
+	for _, r := range []*gin.Engine{cached, direct} {
+		if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, tc := range []struct {
+		name   string
+		peer   string
+		xff    []string
+		wantFF string
+		wantIP string
+	}{
+		{"client", "192.0.2.1", nil, "192.0.2.1", "192.0.2.1"},
+		{"spoofed", "192.0.2.1", []string{"203.0.113.9"}, "192.0.2.1", "192.0.2.1"},
+		{"trusted proxy", "10.0.0.5", []string{"203.0.113.9"}, "203.0.113.9, 10.0.0.5", "203.0.113.9"},
+		{"proxy chain", "10.0.0.5", []string{"198.51.100.1, 203.0.113.9"}, "198.51.100.1, 203.0.113.9, 10.0.0.5", "203.0.113.9"},
+	} {
+		for name, r := range map[string]*gin.Engine{"/api/forwarded-cached": cached, "/api/forwarded-direct": direct} {
+			req := newRequest(http.MethodGet, name+"?n="+strconv.Itoa(i), http.Header{"X-Forwarded-For": tc.xff}, "")
+			req.RemoteAddr = tc.peer + ":1234"
+			if w := serveRequest(r, req); w.Code != http.StatusOK {
+				t.Fatalf("%s %s: status %d", name, tc.name, w.Code)
+			}
+			mu.Lock()
+			if got := received.Values("X-Forwarded-For"); len(got) != 1 || got[0] != tc.wantFF {
+				t.Errorf("%s %s: backend got X-Forwarded-For %q, want %q", name, tc.name, got, tc.wantFF)
+			}
+			if got := received.Get("X-Real-IP"); got != tc.wantIP {
+				t.Errorf("%s %s: backend got X-Real-IP %q, want %q", name, tc.name, got, tc.wantIP)
+			}
+			mu.Unlock()
+		}
+	}
+}
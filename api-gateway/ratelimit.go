@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// rateLimit creates a middleware limiting each client IP to RATE_LIMIT
+// requests per RATE_WINDOW, using a fixed window counter in Redis. Tenants
+// with their own rate limit are limited per tenant instead, and clients with
+// an admin override by their overridden limit. A limit of zero disables rate
+// limiting.
+func rateLimit(tenants []*tenant) gin.HandlerFunc {
+	defaultLimit := getEnvInt("RATE_LIMIT", 0)
+	window := getEnvDuration("RATE_WINDOW", time.Minute)
+	tenantLimits := 0
+	for _, t := range tenants {
+		if t.RateLimit > 0 {
+			tenantLimits++
+		}
+	}
+	if defaultLimit <= 0 && tenantLimits == 0 {
+		slog.Info("RATE_LIMIT not set - rate limiting disabled")
+		return func(c *gin.Context) { c.Next() }
+	}
+	if rdb == nil {
+		slog.Warn("Rate limiting requires CACHE_BACKEND=redis - rate limiting disabled")
+		return func(c *gin.Context) { c.Next() }
+	}
+	slog.Info("Rate limiting enabled", "limit", defaultLimit, "tenant_limits", tenantLimits, "window", window.String())
+
+	return func(c *gin.Context) {
+		// Fail open while Redis is unavailable or rejecting writes
+		if authExemptPaths[c.Request.URL.Path] || redisBypassed() || redisWritesPaused() {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		limit := defaultLimit
+		key := namespacedKey(fmt.Sprintf("ratelimit:%s", c.ClientIP()))
+		if t := tenantFrom(ctx); t != nil && t.RateLimit > 0 {
+			limit = t.RateLimit
+			key = namespacedKey(fmt.Sprintf("ratelimit:tenant:%s", t.ID))
+		}
+		if override, counterKey, ok := rateLimitOverride(ctx, c); ok {
+			limit, key = override, counterKey
+		}
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		// Start the window in the same transaction as the increment, so a
+		// failure between the two can't leave a counter that never expires
+		var incr *redis.IntCmd
+		var ttl *redis.DurationCmd
+		_, err := rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.Incr(ctx, key)
+			pipe.ExpireNX(ctx, key, window)
+			ttl = pipe.TTL(ctx, key)
+			return nil
+		})
+		if err != nil {
+			// Fail open so a Redis outage doesn't block all traffic
+			sampledLog.Warn("Error checking rate limit", "error", err)
+			checkRedisError(err)
+			c.Next()
+			return
+		}
+
+		if incr.Val() > int64(limit) {
+			retryAfter := window
+			if d := ttl.Val(); d > 0 {
+				retryAfter = d
+			}
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			errorResponse(c, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitTestRouter returns a router rate limited by rateLimit serving
+// 200 on /api/prices
+func rateLimitTestRouter(t *testing.T, limit, window string, tenants ...*tenant) *gin.Engine {
+	t.Helper()
+	t.Setenv("RATE_LIMIT", limit)
+	t.Setenv("RATE_WINDOW", window)
+	r := gin.New()
+	r.Use(rateLimit(tenants))
+	r.GET("/api/prices", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+// requestFrom sends a GET for target through r from the client ip
+func requestFrom(r http.Handler, target, ip string) int {
+	req := newRequest(http.MethodGet, target, nil, "")
+	req.RemoteAddr = ip + ":1234"
+	return serveRequest(r, req).Code
+}
+
+func TestRateLimitRejectsOverLimitClients(t *testing.T) {
+	server := newTestRedis(t)
+	r := rateLimitTestRouter(t, "2", "1m")
+
+	for i := 0; i < 2; i++ {
+		if code := requestFrom(r, "/api/prices", "192.0.2.1"); code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want 200", i, code)
+		}
+	}
+	// httptest requests come from 192.0.2.1 too
+	w := get(r, "/api/prices")
+	if w.Code != http.StatusTooManyRequests || errorCode(t, w) != "rate_limited" {
+		t.Fatalf("over-limit request: status %d, body %s, want 429", w.Code, w.Body)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "60" {
+		t.Errorf("Retry-After %q, want 60", retryAfter)
+	}
+
+	// Other clients have their own counter
+	if code := requestFrom(r, "/api/prices", "192.0.2.2"); code != http.StatusOK {
+		t.Errorf("other client: status %d, want 200", code)
+	}
+
+	// The window resets once its counter expires
+	server.FastForward(time.Minute)
+	if code := requestFrom(r, "/api/prices", "192.0.2.1"); code != http.StatusOK {
+		t.Errorf("next window: status %d, want 200", code)
+	}
+}
+
+func TestRateLimitCounterAlwaysExpires(t *testing.T) {
+	server := newTestRedis(t)
+	r := rateLimitTestRouter(t, "5", "1m")
+	key := namespacedKey("ratelimit:192.0.2.1")
+
+	// A counter left without a window still gets one
+	if err := server.Set(key, "1"); err != nil {
+		t.Fatal(err)
+	}
+	requestFrom(r, "/api/prices", "192.0.2.1")
+	if ttl := server.TTL(key); ttl != time.Minute {
+		t.Fatalf("counter TTL %v, want 1m", ttl)
+	}
+
+	// Later requests don't extend the window
+	server.FastForward(20 * time.Second)
+	requestFrom(r, "/api/prices", "192.0.2.1")
+	if ttl := server.TTL(key); ttl != 40*time.Second {
+		t.Errorf("counter TTL %v after a later request, want 40s", ttl)
+	}
+}
+
+func TestRateLimitFailsOpenWhileBypassed(t *testing.T) {
+	newTestRedis(t)
+	r := rateLimitTestRouter(t, "1", "1m")
+	redisBypass.Store(true)
+	t.Cleanup(func() { redisBypass.Store(false) })
+
+	for i := 0; i < 3; i++ {
+		if code := requestFrom(r, "/api/prices", "192.0.2.1"); code != http.StatusOK {
+			t.Errorf("request %d while bypassed: status %d, want 200", i, code)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// defaultOverrideTTL is how long a rate limit override lasts when the
+	// request doesn't say
+	defaultOverrideTTL = time.Hour
+
+	// maxOverrideTTL bounds how long a rate limit override can last, so
+	// forgotten overrides don't stay forever
+	maxOverrideTTL = 7 * 24 * time.Hour
+)
+
+// rateLimitOverrideKey returns the Redis key holding the rate limit
+// override of a client IP or API key. API keys are stored hashed.
+func rateLimitOverrideKey(kind, client string) string {
+	if kind == "key" {
+		client = hashHex([]byte(client))
+	}
+	return namespacedKey(fmt.Sprintf("ratelimit:override:%s:%s", kind, client))
+}
+
+// rateLimitOverride returns the overridden limit of the request's API key
+// or, failing that, its client IP, along with the counter key it is
+// counted under. API key overrides are counted per key.
+func rateLimitOverride(ctx context.Context, c *gin.Context) (int, string, bool) {
+	if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+		if limit, ok := loadRateLimitOverride(ctx, rateLimitOverrideKey("key", apiKey)); ok {
+			return limit, namespacedKey("ratelimit:key:" + hashHex([]byte(apiKey))), true
+		}
+	}
+	ip := c.ClientIP()
+	if limit, ok := loadRateLimitOverride(ctx, rateLimitOverrideKey("ip", ip)); ok {
+		return limit, namespacedKey("ratelimit:" + ip), true
+	}
+	return 0, "", false
+}
+
+// loadRateLimitOverride reads the override stored under key. Errors are
+// logged and treated as no override.
+func loadRateLimitOverride(ctx context.Context, key string) (int, bool) {
+	value, err := rdb.Get(ctx, key).Result()
+	if err != nil {
+		if err != redis.Nil {
+			sampledLog.Warn("Error reading rate limit override", "key", key, "error", err)
+			checkRedisError(err)
+		}
+		return 0, false
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return limit, true
+}
+
+// registerRateLimitOverrideRoutes sets up the admin endpoints managing rate
+// limit overrides
+func registerRateLimitOverrideRoutes(admin gin.IRoutes) {
+	admin.GET("/ratelimit/overrides", getRateLimitOverrides)
+	admin.POST("/ratelimit/overrides", setRateLimitOverride)
+	admin.DELETE("/ratelimit/overrides", deleteRateLimitOverride)
+}
+
+// rateLimitOverrideRequest is the body accepted by setRateLimitOverride.
+// Exactly one of IP and Key names the client.
+type rateLimitOverrideRequest struct {
+	IP    string `json:"ip"`
+	Key   string `json:"key"`
+	Limit int    `json:"limit"`
+	TTL   string `json:"ttl"`
+}
+
+// rateLimitOverrideInfo describes an override returned by
+// getRateLimitOverrides. API keys are only shown hashed.
+type rateLimitOverrideInfo struct {
+	Type       string  `json:"type"`
+	Client     string  `json:"client"`
+	Limit      int     `json:"limit"`
+	TTLSeconds float64 `json:"ttl_seconds"`
+}
+
+// overrideClient returns the kind ("ip" or "key") and normalized client of
+// an override, given its ip and key
+func overrideClient(ip, key string) (string, string, error) {
+	switch {
+	case ip != "" && key != "":
+		return "", "", errors.New("only one of ip and key can be set")
+	case ip != "":
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return "", "", fmt.Errorf("invalid ip %q", ip)
+		}
+		return "ip", parsed.String(), nil
+	case key != "":
+		return "key", key, nil
+	}
+	return "", "", errors.New("ip or key is required")
+}
+
+// requireRateLimitRedis responds with 501 if rate limiting is unavailable
+// because the cache backend isn't Redis
+func requireRateLimitRedis(c *gin.Context) bool {
+	if rdb == nil {
+		errorResponse(c, http.StatusNotImplemented, "redis_required", "rate limit overrides require CACHE_BACKEND=redis")
+		return false
+	}
+	return true
+}
+
+// setRateLimitOverride sets the rate limit of a client IP or API key until
+// the override's TTL passes, replacing any earlier override
+func setRateLimitOverride(c *gin.Context) {
+	if !requireRateLimitRedis(c) {
+		return
+	}
+	var req rateLimitOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_body", fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+	kind, client, err := overrideClient(req.IP, req.Key)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_client", err.Error())
+		return
+	}
+	if req.Limit <= 0 {
+		errorResponse(c, http.StatusBadRequest, "invalid_limit", "limit must be positive")
+		return
+	}
+	ttl := defaultOverrideTTL
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || ttl > maxOverrideTTL {
+			errorResponse(c, http.StatusBadRequest, "invalid_ttl", fmt.Sprintf("ttl must be a duration between 0 and %s", maxOverrideTTL))
+			return
+		}
+	}
+
+	key := rateLimitOverrideKey(kind, client)
+	if err := rdb.Set(c.Request.Context(), key, req.Limit, ttl).Err(); err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error saving rate limit override: %v", err))
+		return
+	}
+	if kind == "key" {
+		client = hashHex([]byte(client))
+	}
+	slog.Info("Set rate limit override", "type", kind, "client", client, "limit", req.Limit, "ttl", ttl.String(),
+		"request_id", c.GetString("request_id"))
+	c.JSON(http.StatusOK, rateLimitOverrideInfo{Type: kind, Client: client, Limit: req.Limit, TTLSeconds: ttl.Seconds()})
+}
+
+// getRateLimitOverrides returns the override of the ip or key query param,
+// or lists every override when neither is given
+func getRateLimitOverrides(c *gin.Context) {
+	if !requireRateLimitRedis(c) {
+		return
+	}
+	ctx := c.Request.Context()
+	if c.Query("ip") == "" && c.Query("key") == "" {
+		var keys []string
+		err := cacheStore.Scan(ctx, namespacedPattern("ratelimit:override:*"), func(batch []string) error {
+			keys = append(keys, batch...)
+			if len(keys) >= maxListedKeys {
+				keys = keys[:maxListedKeys]
+				return errStopScan
+			}
+			return nil
+		})
+		if err != nil {
+			errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error scanning rate limit overrides: %v", err))
+			return
+		}
+		overrides := make([]rateLimitOverrideInfo, 0, len(keys))
+		for _, key := range keys {
+			if info, ok := describeRateLimitOverride(ctx, key); ok {
+				overrides = append(overrides, info)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"overrides": overrides, "count": len(overrides)})
+		return
+	}
+
+	kind, client, err := overrideClient(c.Query("ip"), c.Query("key"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_client", err.Error())
+		return
+	}
+	info, ok := describeRateLimitOverride(ctx, rateLimitOverrideKey(kind, client))
+	if !ok {
+		errorResponse(c, http.StatusNotFound, "override_not_found", "no rate limit override for client")
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+// describeRateLimitOverride returns the override stored under key, or false
+// if it has expired or can't be read
+func describeRateLimitOverride(ctx context.Context, key string) (rateLimitOverrideInfo, bool) {
+	value, ttl, err := cacheStore.Get(ctx, key)
+	if err != nil {
+		return rateLimitOverrideInfo{}, false
+	}
+	limit, err := strconv.Atoi(string(value))
+	if err != nil {
+		return rateLimitOverrideInfo{}, false
+	}
+	kind, client, _ := strings.Cut(strings.TrimPrefix(key, namespacedKey("ratelimit:override:")), ":")
+	return rateLimitOverrideInfo{Type: kind, Client: client, Limit: limit, TTLSeconds: ttl.Seconds()}, true
+}
+
+// deleteRateLimitOverride removes the override of the ip or key query
+// param, restoring the client's normal rate limit
+func deleteRateLimitOverride(c *gin.Context) {
+	if !requireRateLimitRedis(c) {
+		return
+	}
+	kind, client, err := overrideClient(c.Query("ip"), c.Query("key"))
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid_client", err.Error())
+		return
+	}
+	deleted, err := rdb.Del(c.Request.Context(), rateLimitOverrideKey(kind, client)).Result()
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error deleting rate limit override: %v", err))
+		return
+	}
+	slog.Info("Deleted rate limit override", "type", kind, "deleted", deleted, "request_id", c.GetString("request_id"))
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
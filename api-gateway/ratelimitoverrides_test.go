@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// requestWithKey sends a GET for target through r from the client ip with
+// the API key apiKey and returns the response status
+func requestWithKey(r http.Handler, target, ip, apiKey string) int {
+	req := newRequest(http.MethodGet, target, http.Header{"X-API-Key": {apiKey}}, "")
+	req.RemoteAddr = ip + ":1234"
+	return serveRequest(r, req).Code
+}
+
+// allowedRequests sends requests from ip with apiKey until one is rate
+// limited, returning the number allowed
+func allowedRequests(t *testing.T, r http.Handler, ip, apiKey string) int {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		if code := requestWithKey(r, "/api/prices", ip, apiKey); code == http.StatusTooManyRequests {
+			return i
+		}
+	}
+	t.Fatalf("%s was never rate limited", ip)
+	return 0
+}
+
+func TestRateLimitOverrideIsHonoredAndExpires(t *testing.T) {
+	server := newTestRedis(t)
+	admin := adminTestRouter(t)
+	r := rateLimitTestRouter(t, "1", "1m")
+
+	w := serve(admin, http.MethodPost, "/admin/ratelimit/overrides", adminHeader, `{"ip":"192.0.2.2","limit":3,"ttl":"10m"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("setting override: status %d, body %s", w.Code, w.Body)
+	}
+	if n := allowedRequests(t, r, "192.0.2.2", ""); n != 3 {
+		t.Errorf("overridden client allowed %d requests, want 3", n)
+	}
+	if n := allowedRequests(t, r, "192.0.2.1", ""); n != 1 {
+		t.Errorf("other client allowed %d requests, want the default 1", n)
+	}
+
+	var info rateLimitOverrideInfo
+	decodeJSON(t, serve(admin, http.MethodGet, "/admin/ratelimit/overrides?ip=192.0.2.2", adminHeader, ""), &info)
+	if info.Type != "ip" || info.Client != "192.0.2.2" || info.Limit != 3 || info.TTLSeconds != 600 {
+		t.Errorf("override %+v", info)
+	}
+
+	// Both the override and the rate limit window run out
+	server.FastForward(10 * time.Minute)
+	if w := serve(admin, http.MethodGet, "/admin/ratelimit/overrides?ip=192.0.2.2", adminHeader, ""); w.Code != http.StatusNotFound {
+		t.Errorf("expired override: status %d, want 404", w.Code)
+	}
+	if n := allowedRequests(t, r, "192.0.2.2", ""); n != 1 {
+		t.Errorf("client allowed %d requests after its override expired, want the default 1", n)
+	}
+}
+
+func TestRateLimitOverrideByAPIKey(t *testing.T) {
+	newTestRedis(t)
+	admin := adminTestRouter(t)
+	r := rateLimitTestRouter(t, "1", "1m")
+	if n := allowedRequests(t, r, "192.0.2.1", ""); n != 1 {
+		t.Fatalf("client allowed %d requests, want 1", n)
+	}
+
+	serve(admin, http.MethodPost, "/admin/ratelimit/overrides", adminHeader, `{"key":"support-key","limit":2}`)
+	if n := allowedRequests(t, r, "192.0.2.1", "support-key"); n != 2 {
+		t.Errorf("overridden key allowed %d requests, want 2 counted per key", n)
+	}
+
+	var listing struct {
+		Overrides []rateLimitOverrideInfo `json:"overrides"`
+		Count     int                     `json:"count"`
+	}
+	decodeJSON(t, serve(admin, http.MethodGet, "/admin/ratelimit/overrides", adminHeader, ""), &listing)
+	if listing.Count != 1 || listing.Overrides[0].Type != "key" || listing.Overrides[0].Client != hashHex([]byte("support-key")) ||
+		listing.Overrides[0].TTLSeconds != defaultOverrideTTL.Seconds() {
+		t.Errorf("overrides %+v, want the key override listed hashed with the default TTL", listing)
+	}
+
+	var deleted struct{ Deleted int }
+	decodeJSON(t, serve(admin, http.MethodDelete, "/admin/ratelimit/overrides?key=support-key", adminHeader, ""), &deleted)
+	if deleted.Deleted != 1 {
+		t.Errorf("deleted %d overrides, want 1", deleted.Deleted)
+	}
+	if code := requestWithKey(r, "/api/prices", "192.0.2.1", "support-key"); code != http.StatusTooManyRequests {
+		t.Errorf("after deleting the override: status %d, want the client's IP limit", code)
+	}
+}
+
+func TestRateLimitOverrideRejectsInvalidRequests(t *testing.T) {
+	newTestRedis(t)
+	admin := adminTestRouter(t)
+
+	for _, body := range []string{
+		`{"ip":"192.0.2.1","key":"k","limit":2}`,
+		`{"limit":2}`,
+		`{"ip":"not-an-ip","limit":2}`,
+		`{"ip":"192.0.2.1","limit":0}`,
+		`{"ip":"192.0.2.1","limit":2,"ttl":"1000h"}`,
+		`{"ip":"192.0.2.1","limit":2,"ttl":"soon"}`,
+		`{"ip":`,
+	} {
+		if w := serve(admin, http.MethodPost, "/admin/ratelimit/overrides", adminHeader, body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, w.Code)
+		}
+	}
+	if w := serve(admin, http.MethodPost, "/admin/ratelimit/overrides", http.Header{"Content-Type": {"application/json"}}, `{"ip":"192.0.2.1","limit":2}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("without the admin key: status %d, want 401", w.Code)
+	}
+
+	setForTest(t, &rdb, nil)
+	if w := serve(admin, http.MethodGet, "/admin/ratelimit/overrides", adminHeader, ""); w.Code != http.StatusNotImplemented {
+		t.Errorf("without Redis: status %d, want 501", w.Code)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recoverPanics creates a middleware that recovers from panics in later
+// handlers, logging the panic with its stack trace and responding with a
+// JSON 500 carrying the request ID
+func recoverPanics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			if recovered == http.ErrAbortHandler {
+				// Deliberate abort, let net/http drop the connection
+				panic(recovered)
+			}
+
+			requestID := c.GetString("request_id")
+			slog.Error("Panic handling request",
+				"request_id", requestID,
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"panic", fmt.Sprint(recovered),
+				"stack", string(debug.Stack()),
+			)
+			if c.Writer.Written() {
+				// Too late to change the response
+				c.Abort()
+				return
+			}
+			errorResponse(c, http.StatusInternalServerError, "internal_error", "internal error")
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	logs := captureLogs(t, slog.LevelInfo)
+	r := gin.New()
+	r.Use(requestID(), recoverPanics())
+	r.GET("/panic", func(c *gin.Context) { panic("nil map write") })
+	r.GET("/partial", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("after writing")
+	})
+	r.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/panic", nil)
+	req.Header.Set("X-Request-ID", "req-panic-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope struct {
+		Error struct {
+			Code, Message string
+			RequestID     string `json:"request_id"`
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&envelope)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || envelope.Error.Code != "internal_error" ||
+		envelope.Error.Message != "internal error" || envelope.Error.RequestID != "req-panic-1" {
+		t.Errorf("status %d, error %+v, want a 500 internal_error envelope with the request ID", resp.StatusCode, envelope.Error)
+	}
+
+	lines := logs.lines(t, "Panic handling request")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d panics, want 1", len(lines))
+	}
+	if lines[0]["request_id"] != "req-panic-1" || lines[0]["panic"] != "nil map write" || lines[0]["path"] != "/panic" {
+		t.Errorf("panic logged as %v", lines[0])
+	}
+	if stack, _ := lines[0]["stack"].(string); !strings.Contains(stack, "TestRecoverPanics") {
+		t.Errorf("logged stack %.80q..., want the panicking handler", stack)
+	}
+
+	if w := get(r, "/partial"); w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("panic after writing: status %d, body %q, want the response left as written", w.Code, w.Body)
+	}
+
+	resp, err = http.Get(server.URL + "/ok")
+	if err != nil {
+		t.Fatalf("server down after a panic: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("after a panic: status %d, body %q", resp.StatusCode, body)
+	}
+}
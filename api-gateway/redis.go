@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// newRedisClient creates the Redis client for REDIS_MODE. standalone (the
+// default) connects to REDIS_URL, cluster to the REDIS_ADDRS cluster nodes
+// and sentinel to the REDIS_MASTER_NAME master via the REDIS_ADDRS
+// sentinels. All modes are used through redis.UniversalClient, with the
+// connection pool tuned by REDIS_POOL_SIZE, REDIS_MIN_IDLE_CONNS and
+// REDIS_POOL_TIMEOUT where set.
+func newRedisClient(mode string) (redis.UniversalClient, error) {
+	addrs := parseRedisAddrs(getEnv("REDIS_ADDRS", ""))
+	password := getEnv("REDIS_PASSWORD", "")
+	poolSize := getEnvInt("REDIS_POOL_SIZE", 0)
+	minIdleConns := getEnvInt("REDIS_MIN_IDLE_CONNS", 0)
+	poolTimeout := getEnvDuration("REDIS_POOL_TIMEOUT", 0)
+
+	switch strings.ToLower(mode) {
+	case "", "standalone":
+		opt, err := redis.ParseURL(redisURL)
+		if err != nil {
+			return nil, fmt.Errorf("parsing REDIS_URL: %w", err)
+		}
+		// Keep pool settings given as REDIS_URL query params unless overridden
+		if poolSize > 0 {
+			opt.PoolSize = poolSize
+		}
+		if minIdleConns > 0 {
+			opt.MinIdleConns = minIdleConns
+		}
+		if poolTimeout > 0 {
+			opt.PoolTimeout = poolTimeout
+		}
+		return redis.NewClient(opt), nil
+	case "cluster":
+		if len(addrs) == 0 {
+			return nil, errors.New("REDIS_ADDRS is required in cluster mode")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Password:     password,
+			PoolSize:     poolSize,
+			MinIdleConns: minIdleConns,
+			PoolTimeout:  poolTimeout,
+		}), nil
+	case "sentinel":
+		masterName := getEnv("REDIS_MASTER_NAME", "")
+		if len(addrs) == 0 || masterName == "" {
+			return nil, errors.New("REDIS_ADDRS and REDIS_MASTER_NAME are required in sentinel mode")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       masterName,
+			SentinelAddrs:    addrs,
+			SentinelPassword: getEnv("REDIS_SENTINEL_PASSWORD", ""),
+			Password:         password,
+			DB:               getEnvInt("REDIS_DB", 0),
+			PoolSize:         poolSize,
+			MinIdleConns:     minIdleConns,
+			PoolTimeout:      poolTimeout,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown REDIS_MODE %q", mode)
+	}
+}
+
+// redisPoolStats returns the connection pool stats of the Redis client, or
+// zero stats when the cache is not backed by Redis
+func redisPoolStats() *redis.PoolStats {
+	if rdb == nil {
+		return &redis.PoolStats{}
+	}
+	return rdb.PoolStats()
+}
+
+// parseRedisAddrs splits a comma-separated list of host:port addresses
+func parseRedisAddrs(value string) []string {
+	var addrs []string
+	for _, a := range strings.Split(value, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// redisCache is the Cache backed by a Redis client
+type redisCache struct {
+	client redis.UniversalClient
+}
+
+// Get loads key and its TTL in a single round trip
+func (r *redisCache) Get(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, key)
+		ttl = pipe.TTL(ctx, key)
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, 0, errCacheMiss
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return []byte(get.Val()), ttl.Val(), nil
+}
+
+// GetMany loads every key and its TTL in a single pipeline
+func (r *redisCache) GetMany(ctx context.Context, keys []string) ([][]byte, []time.Duration, error) {
+	gets := make([]*redis.StringCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, key)
+			ttls[i] = pipe.TTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, nil, err
+	}
+	values := make([][]byte, len(keys))
+	expiries := make([]time.Duration, len(keys))
+	for i := range keys {
+		if gets[i].Err() == nil {
+			values[i] = []byte(gets[i].Val())
+			expiries[i] = ttls[i].Val()
+		}
+	}
+	return values, expiries, nil
+}
+
+// Set stores value under key
+func (r *redisCache) Set(ctx context.Context, key string, value []byte, expiry time.Duration) error {
+	return r.client.Set(ctx, key, value, expiry).Err()
+}
+
+// Del deletes keys one by one in a pipeline, as cluster keys may be in
+// different slots
+func (r *redisCache) Del(ctx context.Context, keys ...string) (int64, error) {
+	dels := make([]*redis.IntCmd, len(keys))
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			dels[i] = pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	var deleted int64
+	for _, del := range dels {
+		deleted += del.Val()
+	}
+	return deleted, err
+}
+
+// Scan uses SCAN so Redis is not blocked. In cluster mode every master is
+// scanned, and fn calls are serialized.
+func (r *redisCache) Scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		return scanNode(ctx, r.client, pattern, fn)
+	}
+
+	var mu sync.Mutex
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		return scanNode(ctx, node, pattern, func(keys []string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return fn(keys)
+		})
+	})
+	if errors.Is(err, errStopScan) {
+		return nil
+	}
+	return err
+}
+
+// ScanPage runs one SCAN call. In cluster mode the masters are scanned in
+// address order, with the cursor holding the master's index and its SCAN
+// cursor.
+func (r *redisCache) ScanPage(ctx context.Context, pattern, cursor string, count int64) ([]string, string, error) {
+	cluster, ok := r.client.(*redis.ClusterClient)
+	if !ok {
+		scanCursor, err := parseScanCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		keys, next, err := r.client.Scan(ctx, scanCursor, pattern, count).Result()
+		return keys, formatScanCursor(next), err
+	}
+
+	masters, err := clusterMasters(ctx, cluster)
+	if err != nil {
+		return nil, "", err
+	}
+	index, scanCursor := 0, uint64(0)
+	if cursor != "" {
+		nodeCursor, rest, _ := strings.Cut(cursor, ":")
+		if index, err = strconv.Atoi(nodeCursor); err != nil || index < 0 || index >= len(masters) {
+			return nil, "", fmt.Errorf("invalid cursor %q", cursor)
+		}
+		if scanCursor, err = parseScanCursor(rest); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var keys []string
+	var next uint64
+	err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+		if node.Options().Addr != masters[index] {
+			return nil
+		}
+		var err error
+		keys, next, err = node.Scan(ctx, scanCursor, pattern, count).Result()
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	switch {
+	case next != 0:
+		return keys, fmt.Sprintf("%d:%d", index, next), nil
+	case index+1 < len(masters):
+		return keys, fmt.Sprintf("%d:0", index+1), nil
+	default:
+		return keys, "", nil
+	}
+}
+
+// clusterMasters returns the addresses of the cluster's masters in order
+func clusterMasters(ctx context.Context, cluster *redis.ClusterClient) ([]string, error) {
+	slots, err := cluster.ClusterSlots(ctx).Result()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var masters []string
+	for _, slot := range slots {
+		if len(slot.Nodes) > 0 && !seen[slot.Nodes[0].Addr] {
+			seen[slot.Nodes[0].Addr] = true
+			masters = append(masters, slot.Nodes[0].Addr)
+		}
+	}
+	sort.Strings(masters)
+	return masters, nil
+}
+
+// parseScanCursor parses a SCAN cursor, with "" for the first page
+func parseScanCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(cursor, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return n, nil
+}
+
+// formatScanCursor formats a SCAN cursor, with "" after the last page
+func formatScanCursor(cursor uint64) string {
+	if cursor == 0 {
+		return ""
+	}
+	return strconv.FormatUint(cursor, 10)
+}
+
+// Ping pings Redis
+func (r *redisCache) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Close closes the Redis client
+func (r *redisCache) Close() error {
+	return r.client.Close()
+}
+
+// scanNode runs Scan against a single Redis node
+func scanNode(ctx context.Context, node redis.UniversalClient, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				if errors.Is(err, errStopScan) {
+					return nil
+				}
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
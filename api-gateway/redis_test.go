@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestNewRedisClientStandalone(t *testing.T) {
+	server := miniredis.RunT(t)
+	setForTest(t, &redisURL, "redis://"+server.Addr()+"/0")
+	t.Setenv("REDIS_POOL_SIZE", "7")
+
+	for _, mode := range []string{"", "standalone", "STANDALONE"} {
+		client, err := newRedisClient(mode)
+		if err != nil {
+			t.Fatalf("mode %q: %v", mode, err)
+		}
+		defer client.Close()
+		standalone, ok := client.(*redis.Client)
+		if !ok {
+			t.Fatalf("mode %q built a %T, want *redis.Client", mode, client)
+		}
+		if got := standalone.Options().PoolSize; got != 7 {
+			t.Errorf("mode %q: pool size %d, want REDIS_POOL_SIZE", mode, got)
+		}
+		cache := &redisCache{client: client}
+		if err := cache.Set(context.Background(), "key", []byte("value"), 0); err != nil {
+			t.Fatalf("mode %q: set: %v", mode, err)
+		}
+		if got, _ := server.Get("key"); got != "value" {
+			t.Errorf("mode %q: miniredis has %q, want the value written", mode, got)
+		}
+	}
+}
+
+func TestNewRedisClientCluster(t *testing.T) {
+	t.Setenv("REDIS_ADDRS", "redis-1:6379, redis-2:6379,")
+	client, err := newRedisClient("cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		t.Fatalf("built a %T, want *redis.ClusterClient", client)
+	}
+	if addrs := cluster.Options().Addrs; len(addrs) != 2 || addrs[0] != "redis-1:6379" || addrs[1] != "redis-2:6379" {
+		t.Errorf("cluster addrs %q, want REDIS_ADDRS", addrs)
+	}
+}
+
+func TestNewRedisClientSentinel(t *testing.T) {
+	t.Setenv("REDIS_ADDRS", "sentinel-1:26379,sentinel-2:26379")
+	t.Setenv("REDIS_MASTER_NAME", "mymaster")
+	client, err := newRedisClient("sentinel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, ok := client.(*redis.Client); !ok {
+		t.Fatalf("built a %T, want a failover *redis.Client", client)
+	}
+}
+
+func TestNewRedisClientRejectsBadConfig(t *testing.T) {
+	tests := []struct {
+		name, mode, addrs, masterName string
+	}{
+		{"cluster without addrs", "cluster", "", ""},
+		{"sentinel without addrs", "sentinel", "", "mymaster"},
+		{"sentinel without master", "sentinel", "sentinel-1:26379", ""},
+		{"unknown mode", "replicated", "redis-1:6379", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("REDIS_ADDRS", tt.addrs)
+			t.Setenv("REDIS_MASTER_NAME", tt.masterName)
+			if client, err := newRedisClient(tt.mode); err == nil {
+				client.Close()
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestNewRedisClientPoolSettings(t *testing.T) {
+	setForTest(t, &redisURL, "redis://localhost:6379/0?pool_size=5&min_idle_conns=1")
+	client, err := newRedisClient("standalone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt := client.(*redis.Client).Options()
+	client.Close()
+	if opt.PoolSize != 5 || opt.MinIdleConns != 1 {
+		t.Errorf("pool size %d, min idle %d, want the REDIS_URL params without env overrides", opt.PoolSize, opt.MinIdleConns)
+	}
+
+	t.Setenv("REDIS_POOL_SIZE", "40")
+	t.Setenv("REDIS_MIN_IDLE_CONNS", "8")
+	t.Setenv("REDIS_POOL_TIMEOUT", "2s")
+	client, err = newRedisClient("standalone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt = client.(*redis.Client).Options()
+	client.Close()
+	if opt.PoolSize != 40 || opt.MinIdleConns != 8 || opt.PoolTimeout != 2*time.Second {
+		t.Errorf("pool size %d, min idle %d, timeout %s, want the env overrides", opt.PoolSize, opt.MinIdleConns, opt.PoolTimeout)
+	}
+
+	t.Setenv("REDIS_ADDRS", "redis-1:6379")
+	client, err = newRedisClient("cluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterOpt := client.(*redis.ClusterClient).Options()
+	client.Close()
+	if clusterOpt.PoolSize != 40 || clusterOpt.MinIdleConns != 8 || clusterOpt.PoolTimeout != 2*time.Second {
+		t.Errorf("cluster pool size %d, min idle %d, timeout %s, want the env overrides", clusterOpt.PoolSize, clusterOpt.MinIdleConns, clusterOpt.PoolTimeout)
+	}
+}
+
+func TestRedisPoolStatsAreReported(t *testing.T) {
+	newTestRedis(t)
+	admin := adminTestRouter(t)
+	if err := cacheStore.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(admin, http.MethodGet, "/admin/cache/stats", adminHeader, "")
+	var stats struct {
+		RedisPool map[string]int64 `json:"redis_pool"`
+	}
+	decodeJSON(t, w, &stats)
+	if stats.RedisPool["total_conns"] != 1 || stats.RedisPool["misses"] != 1 {
+		t.Errorf("redis_pool %v, want the connection opened by the ping", stats.RedisPool)
+	}
+	exposition := scrapeMetrics(t)
+	assertMetric(t, exposition, "gateway_redis_pool_total_conns 1")
+	assertMetric(t, exposition, "gateway_redis_pool_misses_total 1")
+}
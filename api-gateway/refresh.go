@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// nocacheParam is the query param asking for a forced cache refresh
+const nocacheParam = "nocache"
+
+// cacheRefresh returns the request's raw query without the nocache param,
+// which is never part of cache keys or backend requests, and whether the
+// request forces a refresh with ?nocache=1 or an X-Bypass-Cache header.
+// Refreshes are only honored for admins.
+func cacheRefresh(c *gin.Context) (string, bool) {
+	rawQuery := c.Request.URL.RawQuery
+	requested := isTruthy(c.GetHeader("X-Bypass-Cache"))
+
+	if values, err := url.ParseQuery(rawQuery); err == nil {
+		if _, ok := values[nocacheParam]; ok {
+			requested = requested || isTruthy(values.Get(nocacheParam))
+			values.Del(nocacheParam)
+			rawQuery = values.Encode()
+		}
+	}
+
+	return rawQuery, requested && isAdminRequest(c)
+}
+
+// isTruthy reports whether a flag value is true, treating an empty value as
+// false
+func isTruthy(value string) bool {
+	b, err := strconv.ParseBool(value)
+	return err == nil && b
+}
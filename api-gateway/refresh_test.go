@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheBypassRefreshesEntry(t *testing.T) {
+	var version atomic.Int64
+	var query atomic.Value
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		query.Store(r.URL.RawQuery)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, version.Add(1))
+	})
+	setForTest(t, &adminAPIKeys, parseAPIKeys(testAdminKey))
+	r := cachedTestRouter("nocache", time.Minute, time.Minute)
+
+	for _, tc := range []struct {
+		name, target string
+		header       http.Header
+		cache, body  string
+	}{
+		{"first request", "/api/nocache?symbol=BTC", nil, "MISS", `{"version":1}`},
+		{"nocache without admin key", "/api/nocache?symbol=BTC&nocache=1", nil, "HIT", `{"version":1}`},
+		{"admin nocache", "/api/nocache?nocache=1&symbol=BTC", adminHeader, "REFRESH", `{"version":2}`},
+		{"after refresh", "/api/nocache?symbol=BTC", nil, "HIT", `{"version":2}`},
+		{"admin nocache=0", "/api/nocache?symbol=BTC&nocache=0", adminHeader, "HIT", `{"version":2}`},
+		{"admin bypass header", "/api/nocache?symbol=BTC", http.Header{"X-API-Key": {testAdminKey}, "X-Bypass-Cache": {"true"}}, "REFRESH", `{"version":3}`},
+		{"bypass header without admin key", "/api/nocache?symbol=BTC", http.Header{"X-Bypass-Cache": {"true"}}, "HIT", `{"version":3}`},
+	} {
+		w := serve(r, http.MethodGet, tc.target, tc.header, "")
+		if w.Header().Get("X-Cache") != tc.cache || w.Body.String() != tc.body {
+			t.Errorf("%s: X-Cache %q, body %s, want %s %s", tc.name, w.Header().Get("X-Cache"), w.Body, tc.cache, tc.body)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+	if q := query.Load(); q != "symbol=BTC" {
+		t.Errorf("backend query %q, want nocache stripped", q)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+var (
+	backendMaxRetries     = getEnvInt("BACKEND_MAX_RETRIES", 3)
+	backendRetryBaseDelay = getEnvDuration("BACKEND_RETRY_BASE_DELAY", 100*time.Millisecond)
+	backendRetryMaxDelay  = getEnvDuration("BACKEND_RETRY_MAX_DELAY", 2*time.Second)
+)
+
+// retryBackendRequest sends a request for uri (path and query) to the pool
+// of backends, retrying idempotent requests on connection errors and 5xx
+// responses with exponential backoff and jitter
+func retryBackendRequest(ctx context.Context, pool []*backend, method, uri string, header http.Header, body []byte) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(method) && backendMaxRetries > 0 {
+		attempts += backendMaxRetries
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := tryBackends(ctx, pool, method, uri, header, body)
+		if attempt >= attempts || ctx.Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+		delay := retryDelay(attempt)
+		sampledLog.Warn("Retrying backend request", "uri", uri, "attempt", attempt)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// shouldRetry reports whether a backend result is a transient failure
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, errNoBackends)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// retryDelay returns the backoff before the given retry attempt, doubling
+// from the base delay up to the max with jitter of up to half the delay
+func retryDelay(attempt int) time.Duration {
+	delay := backendRetryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > backendRetryMaxDelay {
+		delay = backendRetryMaxDelay
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// isIdempotent reports whether requests with method may safely be repeated
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// canResend reports whether a failed request may be sent to another backend.
+// Non-idempotent requests are only resent if they never reached a backend.
+func canResend(method string, err error) bool {
+	if isIdempotent(method) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyBackend returns a handler failing with 502 the first failures times,
+// then answering body as JSON
+func flakyBackend(failures int64, body string) http.HandlerFunc {
+	var calls atomic.Int64
+	ok := jsonBackend(body)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		ok(w, r)
+	}
+}
+
+func TestRetryRecoversFromTransientFailures(t *testing.T) {
+	backend := newTestBackend(t, flakyBackend(2, `{"ok":true}`))
+	r := cachedTestRouter("retry-cached", time.Minute, time.Minute)
+
+	w := get(r, "/api/retry-cached")
+	if w.Code != http.StatusOK || w.Body.String() != `{"ok":true}` {
+		t.Errorf("status %d, body %s, want the response after two failures", w.Code, w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+}
+
+func TestRetrySkipsNonIdempotentMethods(t *testing.T) {
+	backend := newTestBackend(t, flakyBackend(2, `{"ok":true}`))
+	r := directTestRouter(t, "retry-direct", http.MethodPost, http.MethodPut)
+
+	if w := serve(r, http.MethodPost, "/api/retry-direct", nil, `{}`); w.Code != http.StatusBadGateway {
+		t.Errorf("POST: status %d, want the backend's 502 without retries", w.Code)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times by POST, want 1", hits)
+	}
+	if w := serve(r, http.MethodPut, "/api/retry-direct", nil, `{}`); w.Code != http.StatusOK {
+		t.Errorf("PUT: status %d, want 200 after a retry", w.Code)
+	}
+}
+
+func TestRetryStopsWhenContextIsCancelled(t *testing.T) {
+	backend := newTestBackend(t, flakyBackend(100, `{}`))
+	setForTest(t, &backendRetryBaseDelay, time.Minute)
+	setForTest(t, &backendRetryMaxDelay, time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := retryBackendRequest(ctx, backends, http.MethodGet, "/api/prices", nil, nil)
+	if err == nil || time.Since(start) > time.Second {
+		t.Errorf("retryBackendRequest returned %v after %s, want the context error promptly", err, time.Since(start))
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1 before the cancelled backoff", hits)
+	}
+}
+
+func TestRetryDelayBackoff(t *testing.T) {
+	setForTest(t, &backendRetryBaseDelay, 100*time.Millisecond)
+	setForTest(t, &backendRetryMaxDelay, time.Second)
+	for attempt, full := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		if delay := retryDelay(attempt); delay < full/2 || delay > full {
+			t.Errorf("retryDelay(%d) = %s, want between %s and %s", attempt, delay, full/2, full)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBackendRetryAfter caps how long a backend 429 stops requests for a
+// cache key, from the BACKEND_RETRY_AFTER_MAX env var
+var maxBackendRetryAfter = getEnvDuration("BACKEND_RETRY_AFTER_MAX", 5*time.Minute)
+
+// backendRateLimitedError is returned for cache keys the backend rate
+// limited, until its Retry-After passes
+type backendRateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (e *backendRateLimitedError) Error() string {
+	return fmt.Sprintf("backend rate limited, retry after %s", e.retryAfter)
+}
+
+// retryAfterSeconds returns the Retry-After header value for d, rounded up
+// to whole seconds
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimitKey returns the key of the sentinel stopping fetches of cacheKey
+// while the backend rate limits it
+func rateLimitKey(cacheKey string) string {
+	return namespacedKey("ratelimited:" + strings.TrimPrefix(cacheKey, namespacedKey("cache:")))
+}
+
+// parseRetryAfter returns the wait from a Retry-After header, given in
+// seconds or as an HTTP date, capped at maxBackendRetryAfter
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		wait = at.Sub(now)
+	} else {
+		return 0, false
+	}
+	if wait <= 0 {
+		return 0, false
+	}
+	return min(wait, maxBackendRetryAfter), true
+}
+
+// checkRateLimited returns a backendRateLimitedError if the backend rate
+// limited cacheKey and its Retry-After has not passed yet
+func checkRateLimited(ctx context.Context, cacheKey string) error {
+	if redisBypassed() {
+		return nil
+	}
+	_, ttl, err := cacheStore.Get(ctx, rateLimitKey(cacheKey))
+	if err != nil {
+		if err != errCacheMiss {
+			checkRedisError(err)
+		}
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	return &backendRateLimitedError{retryAfter: ttl}
+}
+
+// recordRateLimited stores a sentinel stopping fetches of cacheKey until
+// the Retry-After of a backend 429 passes, returning the error to report.
+// It returns nil if the response has no usable Retry-After.
+func recordRateLimited(ctx context.Context, cacheKey string, header http.Header) error {
+	wait, ok := parseRetryAfter(header.Get("Retry-After"), time.Now())
+	if !ok {
+		return nil
+	}
+	sampledLog.Warn("Backend rate limited request, pausing fetches", "key", cacheKey, "retry_after", wait.String())
+	if !redisBypassed() && !redisWritesPaused() {
+		if err := cacheStore.Set(ctx, rateLimitKey(cacheKey), []byte("1"), wait); err != nil {
+			slog.Warn("Error storing rate limit sentinel", "key", cacheKey, "error", err)
+			checkRedisError(err)
+		}
+	}
+	return &backendRateLimitedError{retryAfter: wait}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackendRateLimitPausesFetches(t *testing.T) {
+	var retryAfter atomic.Value
+	retryAfter.Store("30")
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("symbol") == "BTC" {
+			if value := retryAfter.Load().(string); value != "" {
+				w.Header().Set("Retry-After", value)
+			}
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		jsonBackend(`{"ok":true}`)(w, r)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	r := cachedTestRouter("limited-prices", time.Minute, time.Minute)
+
+	for i := 0; i < 5; i++ {
+		w := get(r, "/api/limited-prices?symbol=BTC")
+		if w.Code != http.StatusTooManyRequests || errorCode(t, w) != "backend_rate_limited" {
+			t.Fatalf("request %d: status %d, body %s, want 429 backend_rate_limited", i+1, w.Code, w.Body)
+		}
+		if seconds, _ := strconv.Atoi(w.Header().Get("Retry-After")); seconds < 29 || seconds > 30 {
+			t.Errorf("request %d: Retry-After %q, want the remaining window", i+1, w.Header().Get("Retry-After"))
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times during its Retry-After, want 1", hits)
+	}
+
+	if w := get(r, "/api/limited-prices?symbol=ETH"); w.Code != http.StatusOK {
+		t.Errorf("other query: status %d, want it fetched", w.Code)
+	}
+
+	// Once the window passes, the backend is asked again
+	retryAfter.Store("")
+	cacheKey := cacheKeyFor("limited-prices", "symbol=BTC")
+	if _, err := cacheStore.Del(context.Background(), rateLimitKey(cacheKey)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if w := get(r, "/api/limited-prices?symbol=BTC"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "" {
+			t.Errorf("without Retry-After: status %d, Retry-After %q, want a relayed 429", w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want every 429 without Retry-After fetched", hits)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	setForTest(t, &maxBackendRetryAfter, 5*time.Minute)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"30", 30 * time.Second, true},
+		{" 120 ", 2 * time.Minute, true},
+		{"3600", 5 * time.Minute, true},
+		{now.Add(45 * time.Second).Format(http.TimeFormat), 45 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, false},
+		{"0", 0, false},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	} {
+		if got, ok := parseRetryAfter(tc.value, now); got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want %s, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// routeConfig configures one proxied /api/<endpoint> route
+type routeConfig struct {
+	Endpoint string `json:"endpoint"`
+	TTL      string `json:"ttl,omitempty"`
+	Cache    bool   `json:"cache"`
+
+	// Methods are the HTTP methods proxied by uncached routes, GET if
+	// empty. Cached routes always serve GET and may add POST, whose
+	// responses are cached by a hash of the request body.
+	Methods []string `json:"methods,omitempty"`
+
+	// MaxCacheBytes is the largest body cached for the route, or 0 for no
+	// limit. Larger bodies are served without being cached.
+	MaxCacheBytes int64 `json:"max_cache_bytes,omitempty"`
+
+	// AllowedParams are the query params accepted by the route, or nil to
+	// accept all. ALLOWED_PARAMS_<ENDPOINT> overrides it.
+	AllowedParams []string `json:"allowed_params,omitempty"`
+
+	// ttl is the parsed TTL of cached routes
+	ttl time.Duration
+}
+
+// defaultRoutes are the proxied routes used when ROUTES_CONFIG is not set
+var defaultRoutes = []routeConfig{
+	{Endpoint: "prices", TTL: "5m", Cache: true},
+	{Endpoint: "news", TTL: "5m", Cache: true},
+	{Endpoint: "predictions", TTL: "15m", Cache: true},
+	{Endpoint: "accuracy", TTL: "1h", Cache: true},
+	{Endpoint: "advanced-insights", TTL: "10m", Cache: true},
+	{Endpoint: "symbols", TTL: "6h", Cache: true},
+	{Endpoint: "test-connectivity"}, // Don't cache test endpoints
+	{Endpoint: "test-eventregistry"},
+	{Endpoint: "test-openai"},
+}
+
+// routeMiddleware are handlers run before specific endpoints are proxied
+var routeMiddleware = map[string][]gin.HandlerFunc{
+	"prices": {validateCurrency()},
+}
+
+// routeKeyBuilders build the cache keys of endpoints whose responses depend
+// on only some query params. Other endpoints are keyed by the full
+// normalized query.
+var routeKeyBuilders = map[string]cacheKeyBuilder{
+	"news": keyParams("category", "lang"),
+}
+
+// routeQueryTTLs return the cache TTL of endpoints whose responses stay
+// fresh for a time depending on the keyed query, overriding the route TTL
+var routeQueryTTLs = map[string]func(query string) time.Duration{}
+
+// endpointNamePattern matches valid endpoint names
+var endpointNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// loadRoutes returns the proxied routes from the JSON file named by the
+// ROUTES_CONFIG env var, or the defaults if it is unset
+func loadRoutes() ([]routeConfig, error) {
+	path := getEnv("ROUTES_CONFIG", "")
+	routes := defaultRoutes
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		routes = nil
+		if err := json.Unmarshal(data, &routes); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	return validateRoutes(routes)
+}
+
+// validateRoutes checks route names are valid and unique, including against
+// the built-in batch route, normalizes their methods and parses the TTLs of
+// cached routes
+func validateRoutes(routes []routeConfig) ([]routeConfig, error) {
+	seen := make(map[string]bool, len(routes))
+	validated := make([]routeConfig, len(routes))
+	for i, route := range routes {
+		if !endpointNamePattern.MatchString(route.Endpoint) {
+			return nil, fmt.Errorf("route %d: invalid endpoint %q", i, route.Endpoint)
+		}
+		if seen[route.Endpoint] || route.Endpoint == "batch" {
+			return nil, fmt.Errorf("route %d: duplicate endpoint %q", i, route.Endpoint)
+		}
+		seen[route.Endpoint] = true
+
+		methods, err := validateMethods(route)
+		if err != nil {
+			return nil, err
+		}
+		route.Methods = methods
+
+		if route.MaxCacheBytes < 0 {
+			return nil, fmt.Errorf("route %q: invalid max_cache_bytes %d", route.Endpoint, route.MaxCacheBytes)
+		}
+		if route.Cache {
+			ttl, err := time.ParseDuration(route.TTL)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("route %q: invalid ttl %q", route.Endpoint, route.TTL)
+			}
+			route.ttl = ttl
+		}
+		validated[i] = route
+	}
+	return validated, nil
+}
+
+// proxiedMethods are the HTTP methods uncached routes may proxy
+var proxiedMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// validateMethods returns the uppercased methods of a route, defaulting to
+// GET, checking they can be proxied and cached routes only serve GET and POST
+func validateMethods(route routeConfig) ([]string, error) {
+	if len(route.Methods) == 0 {
+		return []string{http.MethodGet}, nil
+	}
+	seen := make(map[string]bool, len(route.Methods))
+	methods := make([]string, len(route.Methods))
+	for i, method := range route.Methods {
+		method = strings.ToUpper(method)
+		if !proxiedMethods[method] || (route.Cache && method != http.MethodGet && method != http.MethodPost) {
+			return nil, fmt.Errorf("route %q: invalid method %q", route.Endpoint, route.Methods[i])
+		}
+		if seen[method] {
+			return nil, fmt.Errorf("route %q: duplicate method %q", route.Endpoint, route.Methods[i])
+		}
+		seen[method] = true
+		methods[i] = method
+	}
+	return methods, nil
+}
+
+// directEndpoints are the registered endpoints proxied without caching
+var directEndpoints = map[string]bool{}
+
+// isProxiedEndpoint reports whether endpoint is a registered proxied route
+func isProxiedEndpoint(endpoint string) bool {
+	return isCachedEndpoint(endpoint) || directEndpoints[endpoint]
+}
+
+// registerRoutes registers the configured proxied routes. Cached prices
+// also get the /api/prices/:symbol form and /api/prices/bulk, and cached
+// diffEndpoints a /api/<endpoint>/diff route.
+func registerRoutes(r gin.IRoutes, routes []routeConfig) {
+	for _, route := range routes {
+		var middleware []gin.HandlerFunc
+		allowed := routeAllowedParams(route)
+		if allowed != nil {
+			middleware = append(middleware, allowedParams(allowed))
+		}
+		middleware = append(middleware, routeMiddleware[route.Endpoint]...)
+
+		if !route.Cache {
+			directEndpoints[route.Endpoint] = true
+			for _, method := range route.Methods {
+				r.Handle(method, "/api/"+route.Endpoint, append(middleware, idempotency(), directProxy)...)
+			}
+			continue
+		}
+
+		handler := cachedRoute(r, route.Endpoint, route.ttl, route.MaxCacheBytes, middleware...)
+		for _, method := range route.Methods {
+			if method == http.MethodPost {
+				r.POST("/api/"+route.Endpoint, append(middleware, handler)...)
+			}
+		}
+		if route.Endpoint == "prices" {
+			r.GET("/api/prices/:symbol", append(middleware, symbolParam(), handler)...)
+			bulkMiddleware := routeMiddleware[route.Endpoint]
+			if allowed != nil {
+				bulkMiddleware = append([]gin.HandlerFunc{allowedParams(withParams(allowed, "symbols"))}, bulkMiddleware...)
+			}
+			registerBulkPrices(r, route, bulkMiddleware)
+			historyMiddleware := routeMiddleware[route.Endpoint]
+			if allowed != nil {
+				historyMiddleware = append([]gin.HandlerFunc{allowedParams(withParams(allowed, "symbol", "from", "to", "interval"))}, historyMiddleware...)
+			}
+			registerPriceHistory(r, route, historyMiddleware)
+		}
+		if diffEndpoints[route.Endpoint] {
+			diffMiddleware := routeMiddleware[route.Endpoint]
+			if allowed != nil {
+				diffMiddleware = append([]gin.HandlerFunc{allowedParams(withParams(allowed, "since"))}, diffMiddleware...)
+			}
+			r.GET("/api/"+route.Endpoint+"/diff", append(diffMiddleware, diffHandler(route.Endpoint))...)
+		}
+	}
+}
+
+// withParams returns a copy of the allowed param set with extra params
+func withParams(allowed map[string]bool, extra ...string) map[string]bool {
+	params := make(map[string]bool, len(allowed)+len(extra))
+	for name := range allowed {
+		params[name] = true
+	}
+	for _, name := range extra {
+		params[name] = true
+	}
+	return params
+}
+
+// routeAllowedParams returns the set of query params accepted by a route,
+// from ALLOWED_PARAMS_<ENDPOINT> or its config, or nil to accept all
+func routeAllowedParams(route routeConfig) map[string]bool {
+	if value := getEnv(endpointEnvKey("ALLOWED_PARAMS_", route.Endpoint), ""); value != "" {
+		return parseParamSet(value)
+	}
+	if route.AllowedParams == nil {
+		return nil
+	}
+	return parseParamSet(strings.Join(route.AllowedParams, ","))
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pricesTestRouter returns a router with the default cached prices route
+// registered, along with its symbol, bulk and history forms
+func pricesTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	routes, err := validateRoutes([]routeConfig{{Endpoint: "prices", TTL: "1m", Cache: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	registerRoutes(r, routes)
+	return r
+}
+
+func TestPriceSymbolRoute(t *testing.T) {
+	var uri atomic.Value
+	ok := jsonBackend(`{"BTC":50000}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		uri.Store(r.URL.RequestURI())
+		ok(w, r)
+	})
+	r := pricesTestRouter(t)
+
+	w := get(r, "/api/prices/BTC")
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" || uri.Load() != "/api/prices?symbol=BTC" {
+		t.Fatalf("status %d, X-Cache %q, backend URI %v, want a miss for /api/prices?symbol=BTC", w.Code, w.Header().Get("X-Cache"), uri.Load())
+	}
+	for _, target := range []string{"/api/prices/BTC", "/api/prices?symbol=BTC"} {
+		if w := get(r, target); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"BTC":50000}` {
+			t.Errorf("%s: X-Cache %q, body %s, want the cached response", target, w.Header().Get("X-Cache"), w.Body)
+		}
+	}
+	if w := get(r, "/api/prices/ETH"); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("/api/prices/ETH: X-Cache %q, want its own cache entry", w.Header().Get("X-Cache"))
+	}
+
+	for _, target := range []string{"/api/prices/BTC-USD", "/api/prices/ABCDEFGHIJK"} {
+		if w := get(r, target); w.Code != http.StatusBadRequest || errorCode(t, w) != "invalid_symbol" {
+			t.Errorf("%s: status %d, body %s, want 400 invalid_symbol", target, w.Code, w.Body)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+
+	setForTest(t, &symbolPattern, regexp.MustCompile(`^[A-Z]{3}-[A-Z]{3}$`))
+	if w := get(r, "/api/prices/BTC-USD"); w.Code != http.StatusOK {
+		t.Errorf("/api/prices/BTC-USD with a custom SYMBOL_PATTERN: status %d, want 200", w.Code)
+	}
+}
+
+func TestSymbolsRouteCachesAndRefreshes(t *testing.T) {
+	var version atomic.Int64
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"symbols":["BTC"],"version":%d}`, version.Add(1))
+	})
+	routes, err := validateRoutes([]routeConfig{{Endpoint: "symbols", TTL: "6h", Cache: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := adminTestRouter(t)
+	registerRoutes(r, routes)
+
+	get(r, "/api/symbols")
+	w := get(r, "/api/symbols")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"symbols":["BTC"],"version":1}` {
+		t.Fatalf("second request: X-Cache %q, body %s, want a hit", w.Header().Get("X-Cache"), w.Body)
+	}
+	if remaining, _ := strconv.Atoi(w.Header().Get("X-Cache-TTL-Remaining")); remaining < 12*3600-5 {
+		t.Errorf("X-Cache-TTL-Remaining %d, want the 6h TTL and stale window", remaining)
+	}
+
+	// The refresh hook purges and re-fetches, so clients never miss
+	w = serve(r, http.MethodPost, "/admin/symbols/refresh", adminHeader, "")
+	var refreshed struct{ Deleted, Status int }
+	decodeJSON(t, w, &refreshed)
+	if w.Code != http.StatusOK || refreshed.Deleted != 1 || refreshed.Status != http.StatusOK {
+		t.Fatalf("refresh: status %d, body %s", w.Code, w.Body)
+	}
+	if w := get(r, "/api/symbols"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != `{"symbols":["BTC"],"version":2}` {
+		t.Errorf("after refresh: X-Cache %q, body %s, want the refreshed list", w.Header().Get("X-Cache"), w.Body)
+	}
+
+	serve(r, http.MethodPost, "/admin/cache/purge", adminHeader, `{"endpoint":"symbols"}`)
+	if w := get(r, "/api/symbols"); w.Header().Get("X-Cache") != "MISS" || w.Body.String() != `{"symbols":["BTC"],"version":3}` {
+		t.Errorf("after purge: X-Cache %q, body %s, want a miss", w.Header().Get("X-Cache"), w.Body)
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+}
+
+// writeRoutesConfig writes config to a file and points ROUTES_CONFIG at it
+func writeRoutesConfig(t *testing.T, config string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "routes.json")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ROUTES_CONFIG", path)
+}
+
+func TestLoadRoutesFromConfig(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	writeRoutesConfig(t, `[
+		{"endpoint": "config-fast", "ttl": "30s", "cache": true},
+		{"endpoint": "config-slow", "ttl": "2h", "cache": true},
+		{"endpoint": "config-direct", "methods": ["get", "POST"]}
+	]`)
+	routes, err := loadRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	registerRoutes(r, routes)
+
+	for _, tc := range []struct {
+		endpoint string
+		ttl      time.Duration
+	}{
+		{"config-fast", 30 * time.Second},
+		{"config-slow", 2 * time.Hour},
+	} {
+		get(r, "/api/"+tc.endpoint)
+		w := get(r, "/api/"+tc.endpoint)
+		if w.Header().Get("X-Cache") != "HIT" {
+			t.Errorf("%s: X-Cache %q, want HIT", tc.endpoint, w.Header().Get("X-Cache"))
+		}
+		// Entries are kept for the TTL plus an equal stale window
+		remaining, _ := strconv.Atoi(w.Header().Get("X-Cache-TTL-Remaining"))
+		if want := int(2 * tc.ttl / time.Second); remaining > want || remaining < want-2 {
+			t.Errorf("%s: X-Cache-TTL-Remaining %d, want about %d", tc.endpoint, remaining, want)
+		}
+	}
+
+	get(r, "/api/config-direct")
+	w := serve(r, http.MethodPost, "/api/config-direct", nil, `{}`)
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "" {
+		t.Errorf("POST config-direct: status %d X-Cache %q, want an uncached 200", w.Code, w.Header().Get("X-Cache"))
+	}
+	if w := get(r, "/api/prices"); w.Code != http.StatusNotFound {
+		t.Errorf("default prices route: status %d, want 404 when not configured", w.Code)
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want 4", hits)
+	}
+}
+
+func TestLoadRoutesDefaults(t *testing.T) {
+	t.Setenv("ROUTES_CONFIG", "")
+	routes, err := loadRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != len(defaultRoutes) {
+		t.Fatalf("got %d routes, want the %d defaults", len(routes), len(defaultRoutes))
+	}
+	if routes[0].Endpoint != "prices" || routes[0].ttl != 5*time.Minute {
+		t.Errorf("first route %q with ttl %s, want prices with 5m", routes[0].Endpoint, routes[0].ttl)
+	}
+}
+
+func TestLoadRoutesRejectsInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name, config string
+	}{
+		{"duplicate endpoint", `[{"endpoint": "prices", "ttl": "1m", "cache": true}, {"endpoint": "prices"}]`},
+		{"batch endpoint", `[{"endpoint": "batch"}]`},
+		{"invalid endpoint", `[{"endpoint": "../admin"}]`},
+		{"missing ttl", `[{"endpoint": "prices", "cache": true}]`},
+		{"negative ttl", `[{"endpoint": "prices", "ttl": "-1m", "cache": true}]`},
+		{"unknown method", `[{"endpoint": "prices", "methods": ["FETCH"]}]`},
+		{"invalid JSON", `{"endpoint": "prices"}`},
+	} {
+		writeRoutesConfig(t, tc.config)
+		if _, err := loadRoutes(); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+
+	t.Setenv("ROUTES_CONFIG", filepath.Join(t.TempDir(), "missing.json"))
+	if _, err := loadRoutes(); err == nil {
+		t.Error("missing file: no error")
+	}
+}
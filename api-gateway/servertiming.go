@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverTimingEnabled adds a Server-Timing header to cached responses, from
+// the SERVER_TIMING env var
+var serverTimingEnabled = getEnvBool("SERVER_TIMING", true)
+
+// serverTimingMetrics are the Server-Timing entries and the context keys
+// holding their durations, in header order
+var serverTimingMetrics = []struct{ name, key string }{
+	{"cache", "cache_latency"},
+	{"backend", "backend_latency"},
+}
+
+// serverTiming creates a middleware recording when the request started, for
+// the total duration in the Server-Timing header
+func serverTiming() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("request_start", time.Now())
+		c.Next()
+	}
+}
+
+// setServerTiming sets the Server-Timing header from the cache lookup and
+// backend fetch durations recorded so far, and the total time since the
+// request started. Steps that didn't happen, e.g. the backend fetch of a
+// cache hit, are left out.
+func setServerTiming(c *gin.Context) {
+	if !serverTimingEnabled {
+		return
+	}
+	var entries []string
+	for _, metric := range serverTimingMetrics {
+		if d, ok := c.Get(metric.key); ok {
+			entries = append(entries, serverTimingEntry(metric.name, d.(time.Duration)))
+		}
+	}
+	if start, ok := c.Get("request_start"); ok {
+		entries = append(entries, serverTimingEntry("total", time.Since(start.(time.Time))))
+	}
+	if len(entries) > 0 {
+		c.Header("Server-Timing", strings.Join(entries, ", "))
+	}
+}
+
+// serverTimingEntry formats a Server-Timing entry with its duration in
+// milliseconds, e.g. cache;dur=0.4
+func serverTimingEntry(name string, d time.Duration) string {
+	ms := math.Round(float64(d.Microseconds())/10) / 100
+	return name + ";dur=" + strconv.FormatFloat(ms, 'f', -1, 64)
+}
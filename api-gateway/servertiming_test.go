@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serverTimingTestRouter returns a router caching /api/timing-prices with
+// request start times recorded
+func serverTimingTestRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(serverTiming())
+	r.GET("/api/timing-prices", cachedProxy("timing-prices", time.Minute, time.Minute, 0))
+	return r
+}
+
+func TestServerTimingHeader(t *testing.T) {
+	ok := jsonBackend(`{}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		ok(w, r)
+	})
+	setForTest(t, &serverTimingEnabled, true)
+	r := serverTimingTestRouter()
+
+	miss := regexp.MustCompile(`^cache;dur=[0-9.]+, backend;dur=([0-9.]+), total;dur=([0-9.]+)$`)
+	w := get(r, "/api/timing-prices")
+	match := miss.FindStringSubmatch(w.Header().Get("Server-Timing"))
+	if w.Header().Get("X-Cache") != "MISS" || match == nil {
+		t.Fatalf("MISS Server-Timing %q", w.Header().Get("Server-Timing"))
+	}
+	backend, _ := strconv.ParseFloat(match[1], 64)
+	total, _ := strconv.ParseFloat(match[2], 64)
+	if backend < 20 || total < backend {
+		t.Errorf("backend took %vms of %vms, want at least the backend's 20ms", backend, total)
+	}
+
+	hit := regexp.MustCompile(`^cache;dur=[0-9.]+, total;dur=[0-9.]+$`)
+	w = get(r, "/api/timing-prices")
+	if w.Header().Get("X-Cache") != "HIT" || !hit.MatchString(w.Header().Get("Server-Timing")) {
+		t.Errorf("HIT Server-Timing %q, want no backend entry", w.Header().Get("Server-Timing"))
+	}
+}
+
+func TestServerTimingDisabled(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	setForTest(t, &serverTimingEnabled, false)
+	r := serverTimingTestRouter()
+	for i := 0; i < 2; i++ {
+		if header := get(r, "/api/timing-prices").Header().Get("Server-Timing"); header != "" {
+			t.Errorf("Server-Timing %q with SERVER_TIMING off", header)
+		}
+	}
+}
+
+func TestServerTimingEntry(t *testing.T) {
+	for _, tc := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{400 * time.Microsecond, "cache;dur=0.4"},
+		{120 * time.Millisecond, "cache;dur=120"},
+		{1234567 * time.Nanosecond, "cache;dur=1.23"},
+		{0, "cache;dur=0"},
+	} {
+		if got := serverTimingEntry("cache", tc.d); got != tc.want {
+			t.Errorf("serverTimingEntry(%v) = %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var (
+	// shadowBackendURL is a backend sent copies of sampled backend reads,
+	// from the SHADOW_BACKEND_URL env var. Shadowing is disabled when unset.
+	shadowBackendURL = strings.TrimRight(strings.TrimSpace(getEnv("SHADOW_BACKEND_URL", "")), "/")
+
+	// shadowSampleRate is the fraction of backend reads shadowed, from the
+	// SHADOW_SAMPLE_RATE env var
+	shadowSampleRate = getEnvFloat("SHADOW_SAMPLE_RATE", 0.1)
+
+	// shadowTimeout bounds each shadow request
+	shadowTimeout = getEnvDuration("SHADOW_TIMEOUT", 10*time.Second)
+
+	// shadowSlots bounds the shadow requests in flight, from the
+	// SHADOW_MAX_INFLIGHT env var. Requests beyond it are dropped.
+	shadowSlots = make(chan struct{}, max(getEnvInt("SHADOW_MAX_INFLIGHT", 10), 1))
+)
+
+// shadowRead sends a copy of a GET for uri to the shadow backend in the
+// background, if one is configured and the request is sampled, and reports
+// whether its response differs from resp. It never blocks the caller.
+func shadowRead(endpoint, uri string, header http.Header, resp *backendResponse) {
+	if shadowBackendURL == "" || rand.Float64() >= shadowSampleRate {
+		return
+	}
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		shadowResults.WithLabelValues(endpoint, "dropped").Inc()
+		return
+	}
+
+	// The caller may go on to replace the body, e.g. when transforming it
+	header, primary := header.Clone(), *resp
+	go func() {
+		defer func() { <-shadowSlots }()
+		result := compareShadow(endpoint, uri, header, &primary)
+		shadowResults.WithLabelValues(endpoint, result).Inc()
+	}()
+}
+
+// compareShadow fetches uri from the shadow backend and returns how its
+// response compares to resp
+func compareShadow(endpoint, uri string, header http.Header, resp *backendResponse) string {
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, shadowBackendURL+uri, nil)
+	if err != nil {
+		sampledLog.Warn("Error creating shadow request", "endpoint", endpoint, "uri", uri, "error", err)
+		return "error"
+	}
+	if header != nil {
+		req.Header = header
+	}
+	shadow, err := backendClient.Do(req)
+	if err != nil {
+		sampledLog.Warn("Shadow request failed", "endpoint", endpoint, "uri", uri, "error", err)
+		return "error"
+	}
+	defer shadow.Body.Close()
+	body, err := readResponseBody(shadow.Body)
+	if err != nil {
+		sampledLog.Warn("Error reading shadow response", "endpoint", endpoint, "uri", uri, "error", err)
+		return "error"
+	}
+
+	if shadow.StatusCode != resp.status {
+		sampledLog.Warn("Shadow backend status differs", "endpoint", endpoint, "uri", uri,
+			"status", resp.status, "shadow_status", shadow.StatusCode)
+		return "status_mismatch"
+	}
+	if !sameBody(resp.body, body) {
+		sampledLog.Warn("Shadow backend body differs", "endpoint", endpoint, "uri", uri,
+			"bytes", len(resp.body), "shadow_bytes", len(body))
+		return "body_mismatch"
+	}
+	return "match"
+}
+
+// sameBody reports whether two response bodies are equal, comparing them as
+// JSON values when both parse so formatting and key order don't count
+func sameBody(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(va, vb)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newShadowBackend starts a mock shadow backend serving handler and
+// shadows every backend read to it, with up to inflight at a time
+func newShadowBackend(t *testing.T, inflight int, handler http.HandlerFunc) *atomic.Int64 {
+	t.Helper()
+	var hits atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	setForTest(t, &shadowBackendURL, server.URL)
+	setForTest(t, &shadowSampleRate, 1.0)
+	slots := make(chan struct{}, inflight)
+	setForTest(t, &shadowSlots, slots)
+	setForTest(t, &sampledLog, newSampledLogger(0))
+	// Taking every slot waits for the shadow requests to finish
+	t.Cleanup(func() {
+		for i := 0; i < inflight; i++ {
+			slots <- struct{}{}
+		}
+	})
+	return &hits
+}
+
+func TestShadowReadsReportDifferences(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"a":1,"b":2}`))
+	shadowHits := newShadowBackend(t, 10, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("symbol") {
+		case "SAME":
+			w.Write([]byte(`{ "b": 2, "a": 1 }`))
+		case "BODY":
+			w.Write([]byte(`{"a":1,"b":3}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	logs := captureLogs(t, slog.LevelWarn)
+	r := cachedTestRouter("shadow-prices", time.Minute, time.Minute)
+
+	for _, symbol := range []string{"SAME", "BODY", "STATUS"} {
+		if w := get(r, "/api/shadow-prices?symbol="+symbol); w.Code != http.StatusOK || w.Body.String() != `{"a":1,"b":2}` {
+			t.Errorf("%s: status %d, body %s, want the primary response", symbol, w.Code, w.Body)
+		}
+	}
+	get(r, "/api/shadow-prices?symbol=SAME")
+	waitFor(t, func() bool { return shadowHits.Load() == 3 && len(shadowSlots) == 0 })
+
+	exposition := scrapeMetrics(t)
+	assertMetric(t, exposition, `gateway_shadow_requests_total{endpoint="shadow-prices",result="match"} 1`)
+	assertMetric(t, exposition, `gateway_shadow_requests_total{endpoint="shadow-prices",result="body_mismatch"} 1`)
+	assertMetric(t, exposition, `gateway_shadow_requests_total{endpoint="shadow-prices",result="status_mismatch"} 1`)
+	if lines := logs.lines(t, "Shadow backend body differs"); len(lines) != 1 || lines[0]["uri"] != "/api/shadow-prices?symbol=BODY" {
+		t.Errorf("body difference logged as %v", lines)
+	}
+	if lines := logs.lines(t, "Shadow backend status differs"); len(lines) != 1 || lines[0]["shadow_status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("status difference logged as %v", lines)
+	}
+}
+
+func TestShadowReadsNeverDelayClients(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	release := make(chan struct{})
+	shadowHits := newShadowBackend(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	r := directTestRouter(t, "shadow-direct", http.MethodGet)
+	cached := cachedTestRouter("shadow-slow", time.Minute, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		get(cached, "/api/shadow-slow?n=1")
+		get(cached, "/api/shadow-slow?n=2")
+		get(r, "/api/shadow-direct")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("client responses waited for the shadow backend")
+	}
+	close(release)
+	waitFor(t, func() bool { return len(shadowSlots) == 0 })
+	if hits := shadowHits.Load(); hits != 1 {
+		t.Errorf("shadow backend hit %d times, want one in flight and the rest dropped", hits)
+	}
+	assertMetric(t, scrapeMetrics(t), `gateway_shadow_requests_total{endpoint="shadow-slow",result="dropped"} 1`)
+}
+
+func TestShadowReadsAreSampled(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{}`))
+	shadowHits := newShadowBackend(t, 10, func(w http.ResponseWriter, r *http.Request) {})
+	setForTest(t, &shadowSampleRate, 0.0)
+	r := cachedTestRouter("shadow-unsampled", time.Minute, time.Minute)
+	for i := 0; i < 10; i++ {
+		get(r, "/api/shadow-unsampled?n="+strconv.Itoa(i))
+	}
+	if hits := shadowHits.Load(); hits != 0 {
+		t.Errorf("shadow backend hit %d times at a sample rate of 0", hits)
+	}
+}
+
+func TestSameBody(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want bool
+	}{
+		{`{"a":1,"b":[1,2]}`, `{ "b": [1, 2], "a": 1 }`, true},
+		{`{"a":1}`, `{"a":2}`, false},
+		{`{"b":[1,2]}`, `{"b":[2,1]}`, false},
+		{"plain", "plain", true},
+		{"plain", "other", false},
+	} {
+		if got := sameBody([]byte(tc.a), []byte(tc.b)); got != tc.want {
+			t.Errorf("sameBody(%s, %s) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
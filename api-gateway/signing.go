@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+)
+
+// cacheHMACSecret signs stored cache entries so tampering in a shared Redis
+// is detected, from the CACHE_HMAC_SECRET env var. Signing is disabled when
+// it is unset.
+var cacheHMACSecret = []byte(getEnv("CACHE_HMAC_SECRET", ""))
+
+// macSeparator separates the hex MAC from the encoded entry
+const macSeparator = '.'
+
+// cacheEntryMAC returns the hex HMAC of an encoded entry bound to its key,
+// so signed entries can't be moved between keys
+func cacheEntryMAC(key string, data []byte) []byte {
+	mac := hmac.New(sha256.New, cacheHMACSecret)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	encoded := make([]byte, hex.EncodedLen(len(sum)))
+	hex.Encode(encoded, sum)
+	return encoded
+}
+
+// signCacheEntry prefixes an encoded entry stored under key with its MAC
+func signCacheEntry(key string, data []byte) []byte {
+	if len(cacheHMACSecret) == 0 {
+		return data
+	}
+	signed := cacheEntryMAC(key, data)
+	signed = append(signed, macSeparator)
+	return append(signed, data...)
+}
+
+// verifyCacheEntry returns the encoded entry stored under key, reporting
+// false if its MAC is missing or wrong. Any MAC is stripped but not checked
+// when signing is disabled.
+func verifyCacheEntry(key string, data []byte) ([]byte, bool) {
+	// Encoded entries are JSON objects, so can't start with a hex MAC
+	i := bytes.IndexByte(data, macSeparator)
+	signed := i == hex.EncodedLen(sha256.Size) && data[0] != '{'
+	if len(cacheHMACSecret) == 0 {
+		if signed {
+			return data[i+1:], true
+		}
+		return data, true
+	}
+
+	if !signed || !hmac.Equal(data[:i], cacheEntryMAC(key, data[i+1:])) {
+		slog.Warn("Security event: cache entry failed signature check", "key", key, "signed", signed)
+		return nil, false
+	}
+	return data[i+1:], true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestVerifyCacheEntry(t *testing.T) {
+	data := []byte(`{"body":"e30="}`)
+	setForTest(t, &cacheHMACSecret, []byte("secret"))
+	signed := signCacheEntry("cache:prices:", data)
+
+	tampered := bytes.Replace(signed, []byte("e30="), []byte("e31="), 1)
+	for _, tc := range []struct {
+		name   string
+		key    string
+		stored []byte
+		ok     bool
+	}{
+		{"valid", "cache:prices:", signed, true},
+		{"tampered body", "cache:prices:", tampered, false},
+		{"moved to another key", "cache:news:", signed, false},
+		{"unsigned", "cache:prices:", data, false},
+	} {
+		got, ok := verifyCacheEntry(tc.key, tc.stored)
+		if ok != tc.ok || ok && !bytes.Equal(got, data) {
+			t.Errorf("%s: verifyCacheEntry = %q, %v, want ok %v", tc.name, got, ok, tc.ok)
+		}
+	}
+
+	setForTest(t, &cacheHMACSecret, nil)
+	if got := signCacheEntry("cache:prices:", data); !bytes.Equal(got, data) {
+		t.Errorf("signCacheEntry without a secret = %q, want the entry unchanged", got)
+	}
+	for _, stored := range [][]byte{data, signed} {
+		if got, ok := verifyCacheEntry("cache:prices:", stored); !ok || !bytes.Equal(got, data) {
+			t.Errorf("verifyCacheEntry without a secret = %q, %v, want the entry", got, ok)
+		}
+	}
+}
+
+func TestTamperedCacheEntryIsAMiss(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	setForTest(t, &cacheHMACSecret, []byte("secret"))
+	logs := captureLogs(t, slog.LevelWarn)
+	r := cachedTestRouter("signed", time.Minute, time.Minute)
+
+	get(r, "/api/signed")
+	if w := get(r, "/api/signed"); w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("signed entry: X-Cache %q, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	ctx := context.Background()
+	key := namespacedKey("cache:signed:")
+	stored, ttl, err := cacheStore.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored = bytes.Replace(stored, []byte(`"content_type":"application/json"`), []byte(`"content_type":"text/html"`), 1)
+	if err := cacheStore.Set(ctx, key, stored, ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	if w := get(r, "/api/signed"); w.Header().Get("X-Cache") != "MISS" || w.Code != http.StatusOK {
+		t.Errorf("tampered entry: status %d, X-Cache %q, want a 200 MISS", w.Code, w.Header().Get("X-Cache"))
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2", hits)
+	}
+	if lines := logs.lines(t, "Security event: cache entry failed signature check"); len(lines) == 0 {
+		t.Error("tampered entry was not logged as a security event")
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheStatusCounters count responses by X-Cache value since startup or the
+// last reset, for the admin stats endpoint
+var cacheStatusCounters = map[string]*atomic.Int64{
+	"HIT":            {},
+	"HIT-NEGATIVE":   {},
+	"STALE":          {},
+	"STALE-FALLBACK": {},
+	"STALE-IF-ERROR": {},
+	"MISS":           {},
+	"REFRESH":        {},
+	"BYPASS":         {},
+	"TOO-LARGE":      {},
+	"REVALIDATED":    {},
+	"UNKEYED":        {},
+}
+
+// cacheStatusValues map X-Cache values to the coarser X-Cache-Status values
+// used by monitoring:
+//
+//	hit          served from a fresh entry, including negative entries
+//	stale        served from an expired entry, while refreshing or because
+//	             the backend failed
+//	miss         fetched from the backend and cached
+//	refresh      fetched from the backend on an admin's request
+//	bypass       fetched from the backend while the cache is unavailable,
+//	             or because the request has an unkeyed header
+//	too-large    fetched from the backend, too large to be cached
+//	revalidated  the client's copy is current and 304 was sent, or the
+//	             backend confirmed the cached copy is current
+var cacheStatusValues = map[string]string{
+	"HIT":            "hit",
+	"HIT-NEGATIVE":   "hit",
+	"STALE":          "stale",
+	"STALE-FALLBACK": "stale",
+	"STALE-IF-ERROR": "stale",
+	"MISS":           "miss",
+	"REFRESH":        "refresh",
+	"BYPASS":         "bypass",
+	"TOO-LARGE":      "too-large",
+	"REVALIDATED":    "revalidated",
+	"UNKEYED":        "bypass",
+}
+
+var (
+	statsResetMu sync.Mutex
+	statsSince   = time.Now()
+)
+
+// setCacheStatus sets the X-Cache, X-Cache-Status and Server-Timing headers
+// and counts the response, including in the hit ratio of endpoint.
+// Refreshes are left out of the hit ratio as they skip the cache on purpose.
+func setCacheStatus(c *gin.Context, endpoint, status string) {
+	c.Header("X-Cache", status)
+	c.Header("X-Cache-Status", cacheStatusValues[status])
+	setServerTiming(c)
+	if counter := cacheStatusCounters[status]; counter != nil {
+		counter.Add(1)
+	}
+	switch cacheStatusValues[status] {
+	case "hit", "stale", "revalidated":
+		hitRatios.record(endpoint, true)
+	case "miss", "bypass", "too-large":
+		hitRatios.record(endpoint, false)
+	}
+}
+
+// cacheStats handles /admin/cache/stats, reporting response counts by cache
+// status, the rolling hit ratio and the number of cached keys per endpoint.
+// With ?reset=true the counters are reset after being reported.
+func cacheStats(c *gin.Context) {
+	reset := isTruthy(c.Query("reset"))
+
+	statsResetMu.Lock()
+	counts := make(map[string]int64, len(cacheStatusCounters))
+	for status, counter := range cacheStatusCounters {
+		if reset {
+			counts[status] = counter.Swap(0)
+		} else {
+			counts[status] = counter.Load()
+		}
+	}
+	since := statsSince
+	if reset {
+		statsSince = time.Now()
+	}
+	statsResetMu.Unlock()
+
+	keys, err := countCachedKeys(c.Request.Context())
+	if err != nil {
+		errorResponse(c, http.StatusInternalServerError, "cache_error", fmt.Sprintf("Error counting cache keys: %v", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"hits":             counts["HIT"],
+		"negative_hits":    counts["HIT-NEGATIVE"],
+		"misses":           counts["MISS"],
+		"refreshes":        counts["REFRESH"],
+		"bypasses":         counts["BYPASS"],
+		"unkeyed":          counts["UNKEYED"],
+		"too_large":        counts["TOO-LARGE"],
+		"revalidated":      counts["REVALIDATED"],
+		"stale":            counts["STALE"],
+		"stale_fallback":   counts["STALE-FALLBACK"],
+		"stale_if_error":   counts["STALE-IF-ERROR"],
+		"since":            since.Format(time.RFC3339),
+		"keys":             keys,
+		"redis_pool":       redisPoolSummary(),
+		"hit_ratio":        hitRatios.snapshot(),
+		"hit_ratio_window": hitRatios.window.String(),
+	})
+}
+
+// countCachedKeys counts the cached keys of each cached endpoint
+func countCachedKeys(ctx context.Context) (map[string]int64, error) {
+	keys := make(map[string]int64, len(cachedEndpoints))
+	for endpoint := range cachedEndpoints {
+		n, err := countKeys(ctx, namespacedPattern(fmt.Sprintf("cache:%s:*", escapeGlob(endpoint))))
+		if err != nil {
+			return nil, err
+		}
+		keys[endpoint] = n
+	}
+	return keys, nil
+}
+
+// countKeys counts the cache keys matching pattern
+func countKeys(ctx context.Context, pattern string) (int64, error) {
+	var count int64
+	err := cacheStore.Scan(ctx, pattern, func(keys []string) error {
+		count += int64(len(keys))
+		return nil
+	})
+	return count, err
+}
+
+// redisPoolSummary reports the Redis connection pool stats
+func redisPoolSummary() gin.H {
+	stats := redisPoolStats()
+	return gin.H{
+		"hits":        stats.Hits,
+		"misses":      stats.Misses,
+		"timeouts":    stats.Timeouts,
+		"total_conns": stats.TotalConns,
+		"idle_conns":  stats.IdleConns,
+		"stale_conns": stats.StaleConns,
+	}
+}
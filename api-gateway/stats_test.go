@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cacheStatsResponse is the part of a /admin/cache/stats response checked
+// by tests
+type cacheStatsResponse struct {
+	Hits     int64
+	Misses   int64
+	Bypasses int64
+	Stale    int64
+	Keys     map[string]int64
+}
+
+// getCacheStats requests /admin/cache/stats with query
+func getCacheStats(t *testing.T, r *gin.Engine, query string) cacheStatsResponse {
+	t.Helper()
+	w := serve(r, http.MethodGet, "/admin/cache/stats"+query, adminHeader, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("stats: status %d, body %s", w.Code, w.Body)
+	}
+	var stats cacheStatsResponse
+	decodeJSON(t, w, &stats)
+	return stats
+}
+
+func TestCacheStatsCountRequests(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	admin := adminTestRouter(t)
+	r := cachedTestRouter("stats-counted", 30*time.Millisecond, time.Minute)
+	getCacheStats(t, admin, "?reset=true")
+
+	get(r, "/api/stats-counted")
+	get(r, "/api/stats-counted")
+	get(r, "/api/stats-counted?symbol=BTC")
+	get(r, "/api/stats-counted")
+	time.Sleep(40 * time.Millisecond)
+	get(r, "/api/stats-counted")
+	waitForRefreshes(t)
+	redisBypass.Store(true)
+	t.Cleanup(func() { redisBypass.Store(false) })
+	get(r, "/api/stats-counted?symbol=ETH")
+
+	stats := getCacheStats(t, admin, "?reset=true")
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Stale != 1 || stats.Bypasses != 1 {
+		t.Errorf("stats %+v, want 2 hits, 2 misses, 1 stale and 1 bypass", stats)
+	}
+	if n := stats.Keys["stats-counted"]; n != 2 {
+		t.Errorf("%d stats-counted keys, want 2", n)
+	}
+
+	stats = getCacheStats(t, admin, "")
+	if stats.Hits != 0 || stats.Misses != 0 || stats.Stale != 0 || stats.Bypasses != 0 {
+		t.Errorf("stats %+v after a reset, want zeros", stats)
+	}
+}
+
+func TestCacheStatusHeader(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	setForTest(t, &adminAPIKeys, parseAPIKeys(testAdminKey))
+	t.Cleanup(func() { redisBypass.Store(false) })
+	r := cachedTestRouter("status-prices", 50*time.Millisecond, time.Minute)
+	tooLarge := gin.New()
+	tooLarge.GET("/api/status-news", cachedProxy("status-news", time.Minute, time.Minute, 5))
+
+	w := get(r, "/api/status-prices")
+	etag := w.Header().Get("ETag")
+	for _, tc := range []struct {
+		name   string
+		r      http.Handler
+		header http.Header
+		before func()
+		code   int
+		cache  string
+		status string
+	}{
+		{"fresh entry", r, nil, nil, http.StatusOK, "HIT", "hit"},
+		{"client copy current", r, http.Header{"If-None-Match": {etag}}, nil, http.StatusNotModified, "HIT", "revalidated"},
+		{"admin refresh", r, http.Header{"X-API-Key": {testAdminKey}, "X-Bypass-Cache": {"true"}}, nil, http.StatusOK, "REFRESH", "refresh"},
+		{"expired entry", r, nil, func() { time.Sleep(60 * time.Millisecond) }, http.StatusOK, "STALE", "stale"},
+		{"oversize body", tooLarge, nil, nil, http.StatusOK, "TOO-LARGE", "too-large"},
+		{"cache unavailable", r, nil, func() { waitForRefreshes(t); redisBypass.Store(true) }, http.StatusOK, "BYPASS", "bypass"},
+	} {
+		if tc.before != nil {
+			tc.before()
+		}
+		target := "/api/status-prices"
+		if tc.r == tooLarge {
+			target = "/api/status-news"
+		}
+		w := serve(tc.r, http.MethodGet, target, tc.header, "")
+		if w.Code != tc.code || w.Header().Get("X-Cache") != tc.cache || w.Header().Get("X-Cache-Status") != tc.status {
+			t.Errorf("%s: status %d, X-Cache %q, X-Cache-Status %q, want %d %s %s", tc.name,
+				w.Code, w.Header().Get("X-Cache"), w.Header().Get("X-Cache-Status"), tc.code, tc.cache, tc.status)
+		}
+	}
+	if w.Header().Get("X-Cache-Status") != "miss" {
+		t.Errorf("first request: X-Cache-Status %q, want miss", w.Header().Get("X-Cache-Status"))
+	}
+}
+
+func TestCacheStatusValuesCoverEveryXCacheValue(t *testing.T) {
+	documented := map[string]bool{"hit": true, "stale": true, "miss": true, "refresh": true, "bypass": true, "too-large": true, "revalidated": true}
+	for value := range cacheStatusCounters {
+		if status := cacheStatusValues[value]; !documented[status] {
+			t.Errorf("X-Cache %s maps to X-Cache-Status %q, want a documented value", value, status)
+		}
+	}
+}
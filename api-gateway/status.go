@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the gateway build version, set at build time with
+// -ldflags "-X main.Version=<version>"
+var Version = "dev"
+
+// startedAt is when the gateway process started
+var startedAt = time.Now()
+
+// adminStatus handles /admin/status, reporting the state of each subsystem
+// for a status page. The overall status is degraded if any check fails.
+func adminStatus(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+	defer cancel()
+
+	degraded := false
+	check := func(start time.Time, err error) gin.H {
+		result := gin.H{"status": "ok", "latency_ms": msSince(start)}
+		if err != nil {
+			degraded = true
+			result["status"] = "unavailable"
+			result["error"] = err.Error()
+		}
+		return result
+	}
+
+	start := time.Now()
+	cache := check(start, cacheStore.Ping(ctx))
+
+	start = time.Now()
+	backend := check(start, probeBackend(ctx))
+
+	start = time.Now()
+	keys, err := countCachedKeys(ctx)
+	keyCounts := check(start, err)
+	keyCounts["counts"] = keys
+
+	status := "ok"
+	if degraded {
+		status = "degraded"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":         status,
+		"version":        Version,
+		"started_at":     startedAt.UTC().Format(time.RFC3339),
+		"uptime_seconds": int64(time.Since(startedAt).Seconds()),
+		"maintenance":    maintenanceMode.Load(),
+		"cache":          cache,
+		"backend":        backend,
+		"keys":           keyCounts,
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// adminStatusResponse is an /admin/status response
+type adminStatusResponse struct {
+	Status        string
+	Version       string
+	StartedAt     string `json:"started_at"`
+	UptimeSeconds *int64 `json:"uptime_seconds"`
+	Maintenance   bool
+	Cache         map[string]any
+	Backend       map[string]any
+	Keys          struct {
+		Status string
+		Counts map[string]int64
+	}
+}
+
+// getAdminStatus fetches /admin/status from r
+func getAdminStatus(t *testing.T, r http.Handler) adminStatusResponse {
+	t.Helper()
+	w := serve(r, http.MethodGet, "/admin/status", adminHeader, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("/admin/status: status %d, body %s", w.Code, w.Body)
+	}
+	var status adminStatusResponse
+	decodeJSON(t, w, &status)
+	return status
+}
+
+func TestAdminStatus(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &Version, "1.4.2")
+	admin := adminTestRouter(t)
+	cachedTestRouter("status-page", time.Minute, time.Minute)
+	seedCache(t, "cache:status-page:a", "cache:status-page:b")
+
+	status := getAdminStatus(t, admin)
+	if status.Status != "ok" || status.Version != "1.4.2" || status.Maintenance {
+		t.Errorf("status %q, version %q, maintenance %v, want ok 1.4.2 false", status.Status, status.Version, status.Maintenance)
+	}
+	if _, err := time.Parse(time.RFC3339, status.StartedAt); err != nil || status.UptimeSeconds == nil {
+		t.Errorf("started_at %q, uptime_seconds %v, want the process start", status.StartedAt, status.UptimeSeconds)
+	}
+	for name, check := range map[string]map[string]any{"cache": status.Cache, "backend": status.Backend} {
+		if _, ok := check["latency_ms"].(float64); check["status"] != "ok" || !ok {
+			t.Errorf("%s check %v, want ok with a latency", name, check)
+		}
+	}
+	if status.Keys.Status != "ok" || status.Keys.Counts["status-page"] != 2 {
+		t.Errorf("keys %+v, want 2 status-page keys", status.Keys)
+	}
+
+	if w := get(admin, "/admin/status"); w.Code != http.StatusUnauthorized {
+		t.Errorf("without admin key: status %d, want 401", w.Code)
+	}
+}
+
+func TestAdminStatusReportsDegradedSubsystems(t *testing.T) {
+	var backendDown atomic.Bool
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if backendDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	cache := newFlakyCache(t)
+	admin := adminTestRouter(t)
+
+	backendDown.Store(true)
+	status := getAdminStatus(t, admin)
+	if status.Status != "degraded" || status.Backend["status"] != "unavailable" || status.Backend["error"] != "backend returned 503" {
+		t.Errorf("backend down: status %q, backend %v", status.Status, status.Backend)
+	}
+	if status.Cache["status"] != "ok" {
+		t.Errorf("backend down: cache %v, want ok", status.Cache)
+	}
+
+	backendDown.Store(false)
+	cache.down.Store(true)
+	status = getAdminStatus(t, admin)
+	if status.Status != "degraded" || status.Cache["status"] != "unavailable" || status.Cache["error"] == nil {
+		t.Errorf("cache down: status %q, cache %v", status.Status, status.Cache)
+	}
+	if status.Backend["status"] != "ok" {
+		t.Errorf("cache down: backend %v, want ok", status.Backend)
+	}
+}
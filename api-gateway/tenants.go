@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// tenant is a customer with its own API key, rate limit and cache
+type tenant struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+
+	// RateLimit is the tenant's limit of requests per RATE_WINDOW, or 0 to
+	// apply the per-IP RATE_LIMIT
+	RateLimit int `json:"rate_limit,omitempty"`
+
+	// Namespace separates the tenant's cached responses from other
+	// tenants', or is empty to share the default cache
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// tenantContextKey is the request context key of the authenticated tenant
+type tenantContextKey struct{}
+
+// loadTenants returns the tenants from the JSON file named by the
+// TENANTS_CONFIG env var, or none if it is unset
+func loadTenants() ([]*tenant, error) {
+	path := getEnv("TENANTS_CONFIG", "")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var tenants []*tenant
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return validateTenants(tenants)
+}
+
+// validateTenants checks tenant IDs and keys are set and unique and rate
+// limits are not negative
+func validateTenants(tenants []*tenant) ([]*tenant, error) {
+	ids := make(map[string]bool, len(tenants))
+	keys := make(map[string]bool, len(tenants))
+	for i, t := range tenants {
+		if t == nil || t.ID == "" {
+			return nil, fmt.Errorf("tenant %d: missing id", i)
+		}
+		if ids[t.ID] {
+			return nil, fmt.Errorf("tenant %d: duplicate id %q", i, t.ID)
+		}
+		ids[t.ID] = true
+		if t.Key == "" {
+			return nil, fmt.Errorf("tenant %q: missing key", t.ID)
+		}
+		if keys[t.Key] {
+			return nil, fmt.Errorf("tenant %q: key already used by another tenant", t.ID)
+		}
+		keys[t.Key] = true
+		if t.RateLimit < 0 {
+			return nil, fmt.Errorf("tenant %q: invalid rate_limit %d", t.ID, t.RateLimit)
+		}
+	}
+	return tenants, nil
+}
+
+// findTenant returns the tenant with API key, comparing against every
+// tenant's key in constant time
+func findTenant(key string, tenants []*tenant) *tenant {
+	var found *tenant
+	for _, t := range tenants {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(t.Key)) == 1 {
+			found = t
+		}
+	}
+	return found
+}
+
+// withTenant returns a copy of ctx carrying the authenticated tenant
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, t)
+}
+
+// tenantFrom returns the tenant authenticated for a request context, or nil
+func tenantFrom(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*tenant)
+	return t
+}
+
+// tenantKey returns the cache key suffix separating the responses cached
+// for the request's tenant, or "" if it shares the default cache
+func tenantKey(ctx context.Context) string {
+	t := tenantFrom(ctx)
+	if t == nil || t.Namespace == "" {
+		return ""
+	}
+	return "|tenant=" + url.QueryEscape(t.Namespace)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenantsHaveOwnRateLimitsAndCaches(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	newTestRedis(t)
+	t.Setenv("API_KEYS", "")
+	t.Setenv("RATE_LIMIT", "0")
+	t.Setenv("RATE_WINDOW", "1m")
+	tenants, err := validateTenants([]*tenant{
+		{ID: "acme", Key: "key-acme", RateLimit: 2, Namespace: "acme"},
+		{ID: "globex", Key: "key-globex", RateLimit: 4, Namespace: "globex"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.Use(apiKeyAuth(tenants), rateLimit(tenants))
+	r.GET("/api/tenant-prices", cachedProxy("tenant-prices", time.Minute, time.Minute, 0))
+	acme := http.Header{"X-API-Key": {"key-acme"}}
+	globex := http.Header{"X-API-Key": {"key-globex"}}
+
+	for _, tc := range []struct {
+		tenant string
+		header http.Header
+		code   int
+		cache  string
+	}{
+		{"acme", acme, http.StatusOK, "MISS"},
+		{"acme", acme, http.StatusOK, "HIT"},
+		{"globex", globex, http.StatusOK, "MISS"},
+		{"acme", acme, http.StatusTooManyRequests, ""},
+		{"globex", globex, http.StatusOK, "HIT"},
+		{"globex", globex, http.StatusOK, "HIT"},
+		{"globex", globex, http.StatusOK, "HIT"},
+		{"globex", globex, http.StatusTooManyRequests, ""},
+	} {
+		w := serve(r, http.MethodGet, "/api/tenant-prices", tc.header, "")
+		if w.Code != tc.code || w.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("%s: status %d, X-Cache %q, want %d %q", tc.tenant, w.Code, w.Header().Get("X-Cache"), tc.code, tc.cache)
+		}
+	}
+
+	cacheKey := cacheKeyFor("tenant-prices", "")
+	for _, key := range []string{cacheKey + "|tenant=acme", cacheKey + "|tenant=globex"} {
+		if !cacheHas(key) {
+			t.Errorf("%s not cached", key)
+		}
+	}
+	if cacheHas(cacheKey) {
+		t.Error("tenant response cached in the shared cache")
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want once per tenant", hits)
+	}
+}
+
+func TestLoadTenants(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	config := `[{"id":"acme","key":"key-acme","rate_limit":100,"namespace":"acme"},{"id":"globex","key":"key-globex"}]`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANTS_CONFIG", path)
+	tenants, err := loadTenants()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tenants) != 2 || tenants[0].RateLimit != 100 || tenants[0].Namespace != "acme" || tenants[1].RateLimit != 0 {
+		t.Errorf("loaded %+v", tenants)
+	}
+	if found := findTenant("key-globex", tenants); found == nil || found.ID != "globex" {
+		t.Errorf("findTenant returned %+v, want globex", found)
+	}
+	if found := findTenant("key-other", tenants); found != nil {
+		t.Errorf("findTenant of an unknown key returned %+v", found)
+	}
+
+	t.Setenv("TENANTS_CONFIG", "")
+	if tenants, err := loadTenants(); tenants != nil || err != nil {
+		t.Errorf("without TENANTS_CONFIG: %v, %v, want no tenants", tenants, err)
+	}
+}
+
+func TestValidateTenantsRejectsInvalidConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tenants []*tenant
+	}{
+		{"missing id", []*tenant{{Key: "k"}}},
+		{"duplicate id", []*tenant{{ID: "a", Key: "k1"}, {ID: "a", Key: "k2"}}},
+		{"missing key", []*tenant{{ID: "a"}}},
+		{"shared key", []*tenant{{ID: "a", Key: "k"}, {ID: "b", Key: "k"}}},
+		{"negative rate limit", []*tenant{{ID: "a", Key: "k", RateLimit: -1}}},
+		{"null tenant", []*tenant{nil}},
+	} {
+		if _, err := validateTenants(tc.tenants); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
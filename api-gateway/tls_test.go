@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for commonName and its key
+// to certFile and keyFile, stamped with modTime
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der, modTime)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER, modTime)
+}
+
+// writePEM writes a PEM block to file, stamped with modTime
+func writePEM(t *testing.T, file, blockType string, der []byte, modTime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serveTLS serves a 200 over TLS with config, returning the server's URL
+func serveTLS(t *testing.T, config *tls.Config) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go server.Serve(tls.NewListener(listener, config))
+	t.Cleanup(func() { server.Close() })
+	return "https://" + listener.Addr().String()
+}
+
+// servedCommonName returns the common name of the certificate served at url
+func servedCommonName(t *testing.T, url string) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func TestCertReloaderServesAndHotSwapsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	renewed := time.Now().Add(-time.Hour)
+	writeTestCert(t, certFile, keyFile, "original", renewed)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveTLS(t, reloader.tlsConfig())
+	if cn := servedCommonName(t, url); cn != "original" {
+		t.Fatalf("served %q, want the original certificate", cn)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		reloader.watch(10*time.Millisecond, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+
+	writeTestCert(t, certFile, keyFile, "renewed", renewed.Add(time.Minute))
+	waitFor(t, func() bool { return servedCommonName(t, url) == "renewed" })
+}
+
+func TestCertReloaderKeepsCertificateOnFailedReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCert(t, certFile, keyFile, "original", time.Now().Add(-time.Hour))
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded, err := reloader.reload(); reloaded || err != nil {
+		t.Errorf("unchanged files: reload = %v, %v, want no reload", reloaded, err)
+	}
+
+	writePEM(t, certFile, "CERTIFICATE", []byte("garbage"), time.Now())
+	if reloaded, err := reloader.reload(); reloaded || err == nil {
+		t.Errorf("corrupt certificate: reload = %v, %v, want an error", reloaded, err)
+	}
+	cert, _ := reloader.getCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != "original" {
+		t.Errorf("serving %q after a failed reload, want the original certificate", leaf.Subject.CommonName)
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.crt"), keyFile); err == nil {
+		t.Error("missing certificate file: no error")
+	}
+}
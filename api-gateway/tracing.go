@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// tracer creates the gateway's spans; it is a no-op until setupTracing
+	// installs an exporting provider
+	tracer = otel.Tracer("api-gateway")
+
+	// tracerProvider is set when spans are exported, so they can be flushed
+	tracerProvider *sdktrace.TracerProvider
+)
+
+// setupTracing installs the W3C trace context propagator and, when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, an OTLP/HTTP span exporter
+func setupTracing() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" {
+		slog.Info("OTEL_EXPORTER_OTLP_ENDPOINT not set - tracing disabled")
+		return
+	}
+
+	// The exporter reads the endpoint and related OTEL_* settings itself
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		slog.Error("Error creating OTLP exporter, tracing disabled", "error", err)
+		return
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "api-gateway"))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	slog.Info("Tracing enabled")
+}
+
+// shutdownTracing flushes any buffered spans
+func shutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		slog.Error("Error shutting down tracing", "error", err)
+	}
+}
+
+// tracingMiddleware creates a middleware that starts a server span for each
+// request, continuing any trace context sent by the client
+func tracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		if route == "" {
+			route = c.Request.URL.Path
+		}
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", c.Request.Method, route),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("request_id", c.GetString("request_id")),
+			),
+		)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if cacheStatus := c.Writer.Header().Get("X-Cache"); cacheStatus != "" {
+			span.SetAttributes(attribute.String("cache.status", cacheStatus))
+		}
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
+
+// injectTraceContext adds the traceparent header for ctx to an outgoing request
+func injectTraceContext(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans makes the gateway's tracer record its spans in memory for the
+// rest of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	setForTest(t, &tracer, provider.Tracer("api-gateway"))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return recorder
+}
+
+func TestTracingSpanHierarchy(t *testing.T) {
+	var traceparent atomic.Value
+	ok := jsonBackend(`{"ok":true}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		traceparent.Store(r.Header.Get("traceparent"))
+		ok(w, r)
+	})
+	recorder := recordSpans(t)
+	r := gin.New()
+	r.Use(tracingMiddleware())
+	r.GET("/api/tracing-cached", cachedProxy("tracing-cached", time.Minute, time.Minute, 0))
+
+	get(r, "/api/tracing-cached")
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	server, ok1 := spans["GET /api/tracing-cached"]
+	lookup, ok2 := spans["cache.get"]
+	fetch, ok3 := spans["backend.request"]
+	if !ok1 || !ok2 || !ok3 {
+		t.Fatalf("recorded spans %v, want the request, cache.get and backend.request spans", spans)
+	}
+	serverID := server.SpanContext().SpanID()
+	for _, child := range []sdktrace.ReadOnlySpan{lookup, fetch} {
+		if child.Parent().SpanID() != serverID || child.SpanContext().TraceID() != server.SpanContext().TraceID() {
+			t.Errorf("span %s is not a child of the request span", child.Name())
+		}
+	}
+
+	want := "00-" + fetch.SpanContext().TraceID().String() + "-" + fetch.SpanContext().SpanID().String() + "-01"
+	if got, _ := traceparent.Load().(string); got != want {
+		t.Errorf("backend traceparent %q, want %q from the backend.request span", got, want)
+	}
+}
+
+func TestTracingContinuesClientTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	r := gin.New()
+	r.Use(tracingMiddleware())
+	r.GET("/traced", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	serve(r, http.MethodGet, "/traced", http.Header{"traceparent": {"00-" + traceID + "-00f067aa0ba902b7-01"}}, "")
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].SpanContext().TraceID().String() != traceID {
+		t.Errorf("recorded %d spans, want one in the client's trace %s", len(spans), traceID)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// responseTransform reshapes a parsed JSON backend body before it is cached
+// and served
+type responseTransform func(data interface{}) (interface{}, error)
+
+// routeTransforms are the transforms registered for endpoints in code.
+// Endpoints without one can drop fields with OMIT_FIELDS_<ENDPOINT>.
+var routeTransforms = map[string]responseTransform{}
+
+// omitFieldTransforms are the omitFields transforms configured with
+// OMIT_FIELDS_<ENDPOINT>, keyed by env var name
+var omitFieldTransforms = loadOmitFieldTransforms()
+
+// loadOmitFieldTransforms reads every OMIT_FIELDS_<ENDPOINT> env var
+func loadOmitFieldTransforms() map[string]responseTransform {
+	transforms := map[string]responseTransform{}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if !strings.HasPrefix(name, "OMIT_FIELDS_") {
+			continue
+		}
+		if fields := parseParamSet(value); len(fields) > 0 {
+			transforms[name] = omitFields(fields)
+		}
+	}
+	return transforms
+}
+
+// routeTransform returns the transform for endpoint, or nil if it has none
+func routeTransform(endpoint string) responseTransform {
+	if transform, ok := routeTransforms[endpoint]; ok {
+		return transform
+	}
+	return omitFieldTransforms[endpointEnvKey("OMIT_FIELDS_", endpoint)]
+}
+
+// omitFields creates a transform removing the named members from every
+// object in a JSON document, e.g. internal IDs
+func omitFields(fields map[string]bool) responseTransform {
+	var omit func(v interface{}) interface{}
+	omit = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for name, member := range v {
+				if fields[name] {
+					delete(v, name)
+				} else {
+					v[name] = omit(member)
+				}
+			}
+		case []interface{}:
+			for i, item := range v {
+				v[i] = omit(item)
+			}
+		}
+		return v
+	}
+	return func(data interface{}) (interface{}, error) {
+		return omit(data), nil
+	}
+}
+
+// transformBody applies the transform of endpoint to a JSON body, reporting
+// whether it changed the body. Bodies that aren't JSON, and transform
+// errors, leave the body untransformed with a warning.
+func transformBody(endpoint string, body []byte) ([]byte, bool) {
+	transform := routeTransform(endpoint)
+	if transform == nil {
+		return body, false
+	}
+
+	// Numbers are kept as json.Number so large IDs and precise prices
+	// survive the round trip
+	var data interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		sampledLog.Warn("Error parsing response for transform, serving it untransformed", "endpoint", endpoint, "error", err)
+		return body, false
+	}
+	data, err := transform(data)
+	if err != nil {
+		sampledLog.Warn("Error transforming response, serving it untransformed", "endpoint", endpoint, "error", err)
+		return body, false
+	}
+	transformed, err := json.Marshal(data)
+	if err != nil {
+		sampledLog.Warn("Error encoding transformed response, serving it untransformed", "endpoint", endpoint, "error", err)
+		return body, false
+	}
+	return transformed, true
+}
+
+// isJSONContentType reports whether a Content-Type header is JSON
+func isJSONContentType(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRouteTransformReshapesPayload(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"id":"internal-1","prediction":{"BTC":51000,"model_id":7}}`))
+	routeTransforms["transform-predictions"] = func(data interface{}) (interface{}, error) {
+		return data.(map[string]interface{})["prediction"], nil
+	}
+	t.Cleanup(func() { delete(routeTransforms, "transform-predictions") })
+	r := cachedTestRouter("transform-predictions", time.Minute, time.Minute)
+
+	for _, cache := range []string{"MISS", "HIT"} {
+		w := get(r, "/api/transform-predictions")
+		if w.Header().Get("X-Cache") != cache || w.Body.String() != `{"BTC":51000,"model_id":7}` {
+			t.Errorf("X-Cache %q, body %s, want the transformed body on a %s", w.Header().Get("X-Cache"), w.Body, cache)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want 1", hits)
+	}
+}
+
+func TestOmitFieldsTransform(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"id":1,"items":[{"id":2,"BTC":51000.123456789012345678},{"id":3,"ETH":12345678901234567890}]}`))
+	t.Setenv("OMIT_FIELDS_OMIT_PREDICTIONS", "id")
+	setForTest(t, &omitFieldTransforms, loadOmitFieldTransforms())
+	r := cachedTestRouter("omit-predictions", time.Minute, time.Minute)
+
+	want := `{"items":[{"BTC":51000.123456789012345678},{"ETH":12345678901234567890}]}`
+	if w := get(r, "/api/omit-predictions"); w.Body.String() != want {
+		t.Errorf("body %s, want every id removed and numbers unchanged", w.Body)
+	}
+}
+
+func TestRouteTransformErrorsServeUntransformedBody(t *testing.T) {
+	body := `{"id":"internal-1","BTC":51000}`
+	ok := jsonBackend(body)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("format") == "text" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("not json"))
+			return
+		}
+		ok(w, r)
+	})
+	routeTransforms["transform-broken"] = func(interface{}) (interface{}, error) {
+		return nil, errors.New("unexpected shape")
+	}
+	t.Cleanup(func() { delete(routeTransforms, "transform-broken") })
+	logs := captureLogs(t, slog.LevelInfo)
+	r := cachedTestRouter("transform-broken", time.Minute, time.Minute)
+
+	if w := get(r, "/api/transform-broken"); w.Code != http.StatusOK || w.Body.String() != body {
+		t.Errorf("failing transform: status %d, body %s, want the backend body", w.Code, w.Body)
+	}
+	if w := get(r, "/api/transform-broken?format=text"); w.Code != http.StatusOK || w.Body.String() != "not json" {
+		t.Errorf("invalid JSON: status %d, body %s, want the backend body", w.Code, w.Body)
+	}
+	if lines := logs.lines(t, "Error transforming response, serving it untransformed"); len(lines) != 1 || lines[0]["error"] != "unexpected shape" {
+		t.Errorf("transform error logged as %v", lines)
+	}
+	if lines := logs.lines(t, "Error parsing response for transform, serving it untransformed"); len(lines) != 1 {
+		t.Errorf("parse error logged %d times, want once", len(lines))
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// newBackendTransport returns the connection pool shared by all backend
+// calls. Idle connections are kept per backend so load doesn't churn
+// short-lived connections, tuned with BACKEND_MAX_IDLE_CONNS,
+// BACKEND_MAX_IDLE_CONNS_PER_HOST and BACKEND_IDLE_CONN_TIMEOUT. HTTP/2 is
+// negotiated with https backends unless BACKEND_HTTP2 is false.
+func newBackendTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   getEnvDuration("BACKEND_DIAL_TIMEOUT", 5*time.Second),
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     getEnvBool("BACKEND_HTTP2", true),
+		MaxIdleConns:          getEnvInt("BACKEND_MAX_IDLE_CONNS", 100),
+		MaxIdleConnsPerHost:   getEnvInt("BACKEND_MAX_IDLE_CONNS_PER_HOST", 32),
+		IdleConnTimeout:       getEnvDuration("BACKEND_IDLE_CONN_TIMEOUT", 90*time.Second),
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newConnCountingBackend starts a backend answering with JSON that counts
+// the connections opened to it
+func newConnCountingBackend(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+	tb.Helper()
+	var conns atomic.Int64
+	server := httptest.NewUnstartedServer(jsonBackend(`{"ok":true}`))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	server.Start()
+	tb.Cleanup(server.Close)
+	return server, &conns
+}
+
+func TestBackendTransportReusesConnections(t *testing.T) {
+	server, conns := newConnCountingBackend(t)
+	setForTest(t, &backends, parseBackends(server.URL))
+	setForTest(t, &backendBreaker, newBackendBreaker(5, 30*time.Second))
+	setForTest[http.RoundTripper](t, &backendClient.Transport, newBackendTransport())
+	r := directTestRouter(t, "transport-reuse", http.MethodGet)
+
+	for i := 0; i < 10; i++ {
+		if w := get(r, "/api/transport-reuse"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("opened %d backend connections for 10 sequential requests, want 1", n)
+	}
+}
+
+func TestBackendTransportFromEnv(t *testing.T) {
+	t.Setenv("BACKEND_MAX_IDLE_CONNS_PER_HOST", "7")
+	t.Setenv("BACKEND_IDLE_CONN_TIMEOUT", "15s")
+	t.Setenv("BACKEND_HTTP2", "false")
+	transport := newBackendTransport()
+	if transport.MaxIdleConnsPerHost != 7 || transport.IdleConnTimeout != 15*time.Second || transport.ForceAttemptHTTP2 {
+		t.Errorf("transport MaxIdleConnsPerHost %d, IdleConnTimeout %s, HTTP/2 %v", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout, transport.ForceAttemptHTTP2)
+	}
+}
+
+// BenchmarkBackendTransport compares concurrent backend requests through
+// Go's default transport, which keeps 2 idle connections per host, with the
+// tuned backend transport
+func BenchmarkBackendTransport(b *testing.B) {
+	for name, transport := range map[string]*http.Transport{
+		"default": http.DefaultTransport.(*http.Transport).Clone(),
+		"tuned":   newBackendTransport(),
+	} {
+		b.Run(name, func(b *testing.B) {
+			server, conns := newConnCountingBackend(b)
+			client := &http.Client{Transport: transport}
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Get(server.URL)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+			})
+			b.ReportMetric(float64(conns.Load()), "conns")
+			transport.CloseIdleConnections()
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// v2PricesEndpoint is the experimental v2 prices endpoint. Its responses are
+// cached under their own keys, apart from the v1 prices.
+const v2PricesEndpoint = "v2/prices"
+
+// v2Enabled turns on the experimental /api/v2 routes, from ENABLE_V2
+var v2Enabled = getEnvBool("ENABLE_V2", false)
+
+// registerV2Routes registers /api/v2/prices when ENABLE_V2 is set, proxied
+// to the BACKEND_V2_URL backends and cached for TTL_V2_PRICES. Without the
+// flag the route does not exist and requests get a 404.
+func registerV2Routes(r gin.IRoutes) {
+	if !v2Enabled {
+		return
+	}
+	pool := parseBackends(getEnv("BACKEND_V2_URL", ""))
+	if len(pool) == 0 {
+		slog.Warn("ENABLE_V2 set without BACKEND_V2_URL - v2 routes disabled")
+		return
+	}
+
+	endpointPoolsMu.Lock()
+	endpointPools[v2PricesEndpoint] = pool
+	endpointPoolsMu.Unlock()
+
+	cachedRoute(r, v2PricesEndpoint, 5*time.Minute, 0)
+	slog.Info("Experimental v2 routes enabled", "backends", getEnv("BACKEND_V2_URL", ""))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestV2RoutesDisabledByDefault(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &v2Enabled, false)
+	t.Setenv("BACKEND_V2_URL", "http://v2.internal")
+	r := gin.New()
+	registerV2Routes(r)
+
+	if w := get(r, "/api/v2/prices"); w.Code != http.StatusNotFound {
+		t.Errorf("flag off: status %d, want 404", w.Code)
+	}
+}
+
+func TestV2RoutesRequireBackend(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &v2Enabled, true)
+	t.Setenv("BACKEND_V2_URL", "")
+	r := gin.New()
+	registerV2Routes(r)
+
+	if w := get(r, "/api/v2/prices"); w.Code != http.StatusNotFound {
+		t.Errorf("flag on without BACKEND_V2_URL: status %d, want 404", w.Code)
+	}
+}
+
+func TestV2PricesRoute(t *testing.T) {
+	v2 := newTestBackend(t, jsonBackend(`{"data":[{"symbol":"BTC","price":50000}]}`))
+	v1 := newTestBackend(t, jsonBackend(`{"BTC":50000}`))
+	setForTest(t, &v2Enabled, true)
+	t.Setenv("BACKEND_V2_URL", v2.URL)
+	t.Setenv("TTL_V2_PRICES", "90s")
+	t.Cleanup(func() {
+		endpointPoolsMu.Lock()
+		defer endpointPoolsMu.Unlock()
+		delete(endpointPools, v2PricesEndpoint)
+	})
+	r := pricesTestRouter(t)
+	registerV2Routes(r)
+
+	for _, tc := range []struct {
+		target, cache, body string
+	}{
+		{"/api/v2/prices", "MISS", `{"data":[{"symbol":"BTC","price":50000}]}`},
+		{"/api/v2/prices", "HIT", `{"data":[{"symbol":"BTC","price":50000}]}`},
+		{"/api/prices", "MISS", `{"BTC":50000}`},
+	} {
+		w := get(r, tc.target)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tc.cache || w.Body.String() != tc.body {
+			t.Errorf("%s: status %d, X-Cache %q, body %s, want %s %s", tc.target, w.Code, w.Header().Get("X-Cache"), w.Body, tc.cache, tc.body)
+		}
+	}
+
+	w := get(r, "/api/v2/prices")
+	if remaining, _ := strconv.Atoi(w.Header().Get("X-Cache-TTL-Remaining")); remaining > 180 || remaining < 178 {
+		t.Errorf("X-Cache-TTL-Remaining %d, want about twice TTL_V2_PRICES", remaining)
+	}
+	if !cacheHas(cacheKeyFor(v2PricesEndpoint, "")) || !cacheHas(cacheKeyFor("prices", "")) {
+		t.Error("v1 and v2 prices not cached under their own keys")
+	}
+	if v2.hits.Load() != 1 || v1.hits.Load() != 1 {
+		t.Errorf("v2 backend hit %d times and v1 %d, want 1 each", v2.hits.Load(), v1.hits.Load())
+	}
+}
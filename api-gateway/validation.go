@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// allowedCurrencies are the accepted vs_currency values, from the
+// comma-separated ALLOWED_VS_CURRENCIES env var
+var allowedCurrencies = parseParamSet(strings.ToLower(getEnv("ALLOWED_VS_CURRENCIES", "usd,eur,btc,eth")))
+
+// symbolPattern matches valid symbols in path parameters, from the
+// SYMBOL_PATTERN env var
+var symbolPattern = compileSymbolPattern(getEnv("SYMBOL_PATTERN", `^[A-Za-z0-9]{1,10}$`))
+
+// compileSymbolPattern compiles the symbol pattern, exiting if it is invalid
+func compileSymbolPattern(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		slog.Error("Error compiling SYMBOL_PATTERN", "pattern", pattern, "error", err)
+		os.Exit(1)
+	}
+	return re
+}
+
+// defaultVSCurrency is set as the vs_currency of prices queries without
+// one, from the DEFAULT_VS_CURRENCY env var; empty leaves them unchanged
+var defaultVSCurrency = loadDefaultVSCurrency()
+
+// currencyEndpoints are the cached endpoints whose queries get
+// defaultVSCurrency
+var currencyEndpoints = map[string]bool{
+	"prices":             true,
+	bulkPricesEndpoint:   true,
+	priceHistoryEndpoint: true,
+}
+
+// loadDefaultVSCurrency reads DEFAULT_VS_CURRENCY, warning if it is not an
+// allowed currency
+func loadDefaultVSCurrency() string {
+	currency := strings.ToLower(strings.TrimSpace(getEnv("DEFAULT_VS_CURRENCY", "")))
+	if currency != "" && !allowedCurrencies[currency] {
+		slog.Warn("DEFAULT_VS_CURRENCY is not in ALLOWED_VS_CURRENCIES", "currency", currency)
+	}
+	return currency
+}
+
+// withDefaultCurrency returns a normalized query of endpoint with its
+// vs_currency param set to defaultVSCurrency if it has none. It is part of
+// building keyed queries, so client requests, warmup, batches and streams
+// share the cache key and the backend request names the currency
+// explicitly.
+func withDefaultCurrency(endpoint, query string) string {
+	if defaultVSCurrency == "" || !currencyEndpoints[endpoint] {
+		return query
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil || values.Has("vs_currency") {
+		return query
+	}
+	values.Set("vs_currency", defaultVSCurrency)
+	return values.Encode()
+}
+
+// validateCurrency creates a middleware that rejects requests whose
+// vs_currency param is not in the allowlist, before they are cached or
+// proxied. Requests without the param are passed through.
+func validateCurrency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		values, ok := c.GetQueryArray("vs_currency")
+		if !ok {
+			c.Next()
+			return
+		}
+		for _, v := range values {
+			if !allowedCurrencies[strings.ToLower(v)] {
+				errorResponse(c, http.StatusBadRequest, "unsupported_currency", fmt.Sprintf("Unsupported vs_currency %q", v))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// rejectUnknownParams makes allowedParams reject requests with unknown query
+// params with 400 instead of stripping them, when UNKNOWN_PARAMS is reject
+var rejectUnknownParams = strings.EqualFold(getEnv("UNKNOWN_PARAMS", "strip"), "reject")
+
+// allowedParams creates a middleware that strips query params not in
+// allowed, or rejects the request if rejectUnknownParams is set, so unknown
+// params neither fragment the cache nor reach the backend. The nocache
+// param is always kept for admins.
+func allowedParams(allowed map[string]bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		stripped := false
+		for name := range query {
+			if allowed[name] || name == nocacheParam {
+				continue
+			}
+			if rejectUnknownParams {
+				errorResponse(c, http.StatusBadRequest, "unsupported_param", fmt.Sprintf("Unsupported query parameter %q", name))
+				return
+			}
+			query.Del(name)
+			stripped = true
+		}
+		if stripped {
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
+
+// symbolParam creates a middleware that validates the :symbol path param
+// and moves it into the symbol query param, so the request is proxied and
+// cached like the equivalent query string form
+func symbolParam() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		symbol := c.Param("symbol")
+		if !symbolPattern.MatchString(symbol) {
+			errorResponse(c, http.StatusBadRequest, "invalid_symbol", fmt.Sprintf("Invalid symbol %q", symbol))
+			return
+		}
+		query := c.Request.URL.Query()
+		query.Set("symbol", symbol)
+		c.Request.URL.RawQuery = query.Encode()
+		c.Next()
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidateCurrency(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	r := gin.New()
+	r.GET("/api/validation-prices", validateCurrency(), cachedProxy("validation-prices", time.Minute, time.Minute, 0))
+
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"", http.StatusOK},
+		{"?vs_currency=usd", http.StatusOK},
+		{"?vs_currency=EUR", http.StatusOK},
+		{"?vs_currency=btc&vs_currency=eth", http.StatusOK},
+		{"?vs_currency=xyz", http.StatusBadRequest},
+		{"?vs_currency=usd&vs_currency=usd%27", http.StatusBadRequest},
+		{"?vs_currency=", http.StatusBadRequest},
+	} {
+		w := get(r, "/api/validation-prices"+tc.query)
+		if w.Code != tc.status {
+			t.Errorf("%q: status %d, want %d", tc.query, w.Code, tc.status)
+		}
+		if tc.status == http.StatusBadRequest && errorCode(t, w) != "unsupported_currency" {
+			t.Errorf("%q: body %s, want unsupported_currency", tc.query, w.Body)
+		}
+	}
+	if hits := backend.hits.Load(); hits != 4 {
+		t.Errorf("backend hit %d times, want 4 as invalid currencies are not proxied", hits)
+	}
+}
+
+// allowedParamsTestRouter returns a router with a cached and a direct route
+// accepting only the symbol and vs_currency params, and a cached route
+// accepting every param
+func allowedParamsTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	allowed := []string{"symbol", "vs_currency"}
+	routes, err := validateRoutes([]routeConfig{
+		{Endpoint: "allow-cached", TTL: "1m", Cache: true, AllowedParams: allowed},
+		{Endpoint: "allow-direct", AllowedParams: allowed},
+		{Endpoint: "allow-any", TTL: "1m", Cache: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(directEndpoints, "allow-direct") })
+	r := gin.New()
+	registerRoutes(r, routes)
+	return r
+}
+
+func TestAllowedParamsStripUnknownParams(t *testing.T) {
+	var uri atomic.Value
+	ok := jsonBackend(`{"ok":true}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		uri.Store(r.URL.RequestURI())
+		ok(w, r)
+	})
+	r := allowedParamsTestRouter(t)
+
+	for _, tc := range []struct {
+		target, cache, backendURI string
+	}{
+		{"/api/allow-cached?symbol=BTC&utm_source=twitter&debug=1", "MISS", "/api/allow-cached?symbol=BTC"},
+		{"/api/allow-cached?symbol=BTC&utm_source=reddit", "HIT", "/api/allow-cached?symbol=BTC"},
+		{"/api/allow-direct?vs_currency=eur&junk=x", "", "/api/allow-direct?vs_currency=eur"},
+		{"/api/allow-any?symbol=BTC&utm_source=twitter", "MISS", "/api/allow-any?symbol=BTC&utm_source=twitter"},
+	} {
+		w := get(r, tc.target)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("%s: status %d, X-Cache %q, want 200 %q", tc.target, w.Code, w.Header().Get("X-Cache"), tc.cache)
+		}
+		if got := uri.Load(); got != tc.backendURI {
+			t.Errorf("%s: backend requested %v, want %s", tc.target, got, tc.backendURI)
+		}
+	}
+	if !cacheHas(namespacedKey("cache:allow-cached:symbol=BTC")) {
+		t.Error("entry not keyed by the allowed params only")
+	}
+	if hits := backend.hits.Load(); hits != 3 {
+		t.Errorf("backend hit %d times, want 3", hits)
+	}
+}
+
+func TestAllowedParamsRejectUnknownParams(t *testing.T) {
+	backend := newTestBackend(t, jsonBackend(`{"ok":true}`))
+	setForTest(t, &rejectUnknownParams, true)
+	r := allowedParamsTestRouter(t)
+
+	w := get(r, "/api/allow-cached?symbol=BTC&utm_source=twitter")
+	if w.Code != http.StatusBadRequest || errorCode(t, w) != "unsupported_param" {
+		t.Errorf("unknown param: status %d, body %s, want 400 unsupported_param", w.Code, w.Body)
+	}
+	if w := get(r, "/api/allow-cached?symbol=BTC&vs_currency=usd"); w.Code != http.StatusOK {
+		t.Errorf("allowed params: status %d, want 200", w.Code)
+	}
+	if hits := backend.hits.Load(); hits != 1 {
+		t.Errorf("backend hit %d times, want only the allowed request", hits)
+	}
+}
+
+func TestAllowedParamsEnvOverride(t *testing.T) {
+	t.Setenv("ALLOWED_PARAMS_NEWS", "category, lang")
+	allowed := routeAllowedParams(routeConfig{Endpoint: "news", AllowedParams: []string{"category"}})
+	if len(allowed) != 2 || !allowed["category"] || !allowed["lang"] {
+		t.Errorf("allowed params %v, want ALLOWED_PARAMS_NEWS", allowed)
+	}
+	if allowed := routeAllowedParams(routeConfig{Endpoint: "prices"}); allowed != nil {
+		t.Errorf("allowed params %v without an allowlist, want nil", allowed)
+	}
+}
+
+func TestDefaultVSCurrency(t *testing.T) {
+	var uris []string
+	var mu sync.Mutex
+	ok := jsonBackend(`{}`)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		uris = append(uris, r.URL.RequestURI())
+		mu.Unlock()
+		ok(w, r)
+	})
+	setForTest(t, &defaultVSCurrency, "usd")
+	r := pricesTestRouter(t)
+
+	for _, tc := range []struct {
+		target string
+		cache  string
+		uri    string
+	}{
+		{"/api/prices?symbol=BTC", "MISS", "/api/prices?symbol=BTC&vs_currency=usd"},
+		{"/api/prices?symbol=BTC&vs_currency=usd", "HIT", ""},
+		{"/api/prices?symbol=BTC&vs_currency=eur", "MISS", "/api/prices?symbol=BTC&vs_currency=eur"},
+		{"/api/prices/bulk?symbols=ETH", "MISS", "/api/prices?symbol=ETH&vs_currency=usd"},
+	} {
+		before := backend.hits.Load()
+		w := get(r, tc.target)
+		if w.Code != http.StatusOK || w.Header().Get("X-Cache") != tc.cache {
+			t.Errorf("%s: status %d, X-Cache %q, want %s", tc.target, w.Code, w.Header().Get("X-Cache"), tc.cache)
+		}
+		mu.Lock()
+		if tc.uri != "" && (backend.hits.Load() != before+1 || uris[len(uris)-1] != tc.uri) {
+			t.Errorf("%s: backend requests %v, want %s", tc.target, uris, tc.uri)
+		}
+		mu.Unlock()
+	}
+	if !cacheHas(namespacedKey("cache:prices:symbol=BTC&vs_currency=usd")) {
+		t.Error("defaulted request not cached under the explicit currency")
+	}
+}
+
+func TestDefaultVSCurrencyOnlyAppliesToPriceEndpoints(t *testing.T) {
+	setForTest(t, &defaultVSCurrency, "usd")
+	for _, tc := range []struct {
+		endpoint, query, want string
+	}{
+		{"prices", "", "vs_currency=usd"},
+		{"prices", "symbol=BTC", "symbol=BTC&vs_currency=usd"},
+		{"prices", "symbol=BTC&vs_currency=eur", "symbol=BTC&vs_currency=eur"},
+		{priceHistoryEndpoint, "symbol=BTC", "symbol=BTC&vs_currency=usd"},
+		{"predictions", "symbol=BTC", "symbol=BTC"},
+	} {
+		if got := withDefaultCurrency(tc.endpoint, tc.query); got != tc.want {
+			t.Errorf("withDefaultCurrency(%s, %q) = %q, want %q", tc.endpoint, tc.query, got, tc.want)
+		}
+	}
+
+	setForTest(t, &defaultVSCurrency, "")
+	if got := withDefaultCurrency("prices", "symbol=BTC"); got != "symbol=BTC" {
+		t.Errorf("without DEFAULT_VS_CURRENCY: %q, want the query unchanged", got)
+	}
+	t.Setenv("DEFAULT_VS_CURRENCY", " EUR ")
+	if got := loadDefaultVSCurrency(); got != "eur" {
+		t.Errorf("DEFAULT_VS_CURRENCY loaded as %q, want eur", got)
+	}
+}
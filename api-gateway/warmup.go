@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// warmCache populates the cache with the default variant of every warmable
+// cached endpoint that isn't already cached, so the first users after a cold start
+// don't wait on the backend. Failures are logged and otherwise ignored.
+func warmCache(ctx context.Context) {
+	start := time.Now()
+	var g errgroup.Group
+	warmed := 0
+	for endpoint := range cachedEndpoints {
+		if !isWarmableEndpoint(endpoint) {
+			continue
+		}
+		warmed++
+		endpoint := endpoint
+		g.Go(func() error {
+			_, status, _, err := loadCachedBody(ctx, endpoint, "")
+			switch {
+			case err != nil:
+				slog.Warn("Error warming cache", "endpoint", endpoint, "error", err)
+			case status != http.StatusOK:
+				slog.Warn("Backend error warming cache", "endpoint", endpoint, "status", status)
+			default:
+				slog.Debug("Warmed cache", "endpoint", endpoint)
+			}
+			return nil
+		})
+	}
+	g.Wait()
+	slog.Info("Cache warmup finished", "endpoints", warmed, "duration_ms", msSince(start))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWarmCachePopulatesCachedEndpoints(t *testing.T) {
+	var mu sync.Mutex
+	paths := map[string]int{}
+	ok := jsonBackend(`{"ok":true}`)
+	newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/api/warm-fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		ok(w, r)
+	})
+	setForTest(t, &backendMaxRetries, 0)
+	for _, endpoint := range []string{"warm-a", "warm-b", "warm-fail", "warm-query"} {
+		cachedProxy(endpoint, time.Minute, time.Minute, 0)
+	}
+	requireQuery("warm-query")
+
+	warmCache(context.Background())
+
+	ctx := context.Background()
+	for _, endpoint := range []string{"warm-a", "warm-b"} {
+		if !cacheHas(defaultCacheKey(ctx, endpoint, "")) {
+			t.Errorf("%s not cached after warmup", endpoint)
+		}
+	}
+	if cacheHas(defaultCacheKey(ctx, "warm-fail", "")) {
+		t.Error("failed warmup of warm-fail was cached")
+	}
+	if paths["/api/warm-query"] != 0 {
+		t.Error("warmup fetched warm-query, which requires query params")
+	}
+
+	// Warm endpoints are then served from the cache
+	if w := get(cachedTestRouter("warm-a", time.Minute, time.Minute), "/api/warm-a"); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache %q after warmup, want HIT", w.Header().Get("X-Cache"))
+	}
+	if paths["/api/warm-a"] != 1 {
+		t.Errorf("warm-a fetched %d times, want 1", paths["/api/warm-a"])
+	}
+}
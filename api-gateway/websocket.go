@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// wsPollInterval is how often streamed cache entries are checked for changes
+	wsPollInterval = getEnvDuration("WS_POLL_INTERVAL", 5*time.Second)
+
+	// wsMinInterval is the default minimum time between frames sent to a
+	// websocket client
+	wsMinInterval = getEnvDuration("WS_MIN_INTERVAL", time.Second)
+
+	wsUpgrader = websocket.Upgrader{CheckOrigin: wsCheckOrigin}
+)
+
+// wsCheckOrigin allows websocket connections from the CORS allowed origins
+func wsCheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	return corsOrigins.allowed(origin)
+}
+
+// cacheStream creates a gin handler that streams the default cached payload
+// of endpoint over a websocket, sending a frame on connect and whenever the
+// cached body changes. The Redis cache is the source of truth; the backend
+// is only hit (once, shared) when the entry is missing. Frames are sent at
+// most once per WS_MIN_INTERVAL_<ENDPOINT> (default WS_MIN_INTERVAL), and a
+// slow client only gets the latest change, never a backlog.
+func cacheStream(endpoint string) gin.HandlerFunc {
+	minInterval := getEnvDuration(endpointEnvKey("WS_MIN_INTERVAL_", endpoint), wsMinInterval)
+
+	return func(c *gin.Context) {
+		if !isWarmableEndpoint(endpoint) {
+			errorResponse(c, http.StatusNotFound, "unknown_endpoint", "Unknown endpoint")
+			return
+		}
+
+		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			slog.Warn("Error upgrading websocket", "endpoint", endpoint, "error", err)
+			return
+		}
+		defer conn.Close()
+
+		// Read in the background so close frames are handled. The context
+		// keeps the request's tenant so its namespace is streamed.
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		defer cancel()
+		go func() {
+			defer cancel()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		// Changes are handed to the writer through a one-frame slot
+		updates := make(chan []byte, 1)
+		written := make(chan struct{})
+		go func() {
+			defer close(written)
+			defer cancel()
+			writeStream(ctx, conn, updates, minInterval)
+		}()
+		defer func() { <-written }()
+
+		ticker := time.NewTicker(wsPollInterval)
+		defer ticker.Stop()
+
+		var lastETag string
+		for {
+			body, _, etag, err := loadCachedBody(ctx, endpoint, "")
+			if err != nil {
+				slog.Warn("Error loading streamed payload", "endpoint", endpoint, "error", err)
+			} else if etag != lastETag {
+				if offerLatest(updates, body) {
+					slog.Debug("Dropped stale websocket frame", "endpoint", endpoint)
+				}
+				lastETag = etag
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// offerLatest puts body in the one-frame slot, replacing a frame the writer
+// has not taken yet, and reports whether one was replaced
+func offerLatest(slot chan []byte, body []byte) bool {
+	dropped := false
+	select {
+	case <-slot:
+		dropped = true
+	default:
+	}
+	slot <- body
+	return dropped
+}
+
+// writeStream sends the frames put in updates to conn, waiting at least
+// minInterval between frames, until ctx is done or a write fails
+func writeStream(ctx context.Context, conn *websocket.Conn, updates <-chan []byte, minInterval time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-updates:
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteMessage(websocket.TextMessage, body); err != nil {
+				return
+			}
+		}
+
+		// Changes arriving meanwhile replace each other in the slot
+		timer := time.NewTimer(minInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// dialStream connects a websocket client to path on server
+func dialStream(t *testing.T, server *httptest.Server, path string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + path
+	conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// readFrame reads the next text frame from conn
+func readFrame(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	return string(data)
+}
+
+// waitForStreams wraps handler so the test waits for the streams it serves
+// to end, once their clients have disconnected, before globals are restored
+func waitForStreams(t *testing.T, handler gin.HandlerFunc) gin.HandlerFunc {
+	t.Helper()
+	var active atomic.Int64
+	t.Cleanup(func() { waitFor(t, func() bool { return active.Load() == 0 }) })
+	return func(c *gin.Context) {
+		active.Add(1)
+		defer active.Add(-1)
+		handler(c)
+	}
+}
+
+func TestCacheStreamSendsInitialAndUpdateFrames(t *testing.T) {
+	var version atomic.Int64
+	version.Store(1)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, version.Load())
+	})
+	setForTest(t, &wsPollInterval, 10*time.Millisecond)
+	setForTest(t, &wsMinInterval, 0)
+	r := cachedTestRouter("ws-prices", time.Minute, time.Minute)
+	r.GET("/ws/prices", waitForStreams(t, cacheStream("ws-prices")))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	conn, _, err := dialStream(t, server, "/ws/prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, conn); frame != `{"version":1}` {
+		t.Fatalf("initial frame %s, want version 1", frame)
+	}
+
+	version.Store(2)
+	if _, err := cacheStore.Del(context.Background(), namespacedKey("cache:ws-prices:")); err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, conn); frame != `{"version":2}` {
+		t.Fatalf("update frame %s, want version 2", frame)
+	}
+	if hits := backend.hits.Load(); hits != 2 {
+		t.Errorf("backend hit %d times, want 2 as polls are served from the cache", hits)
+	}
+}
+
+func TestCacheStreamAuthenticatesWithQueryParam(t *testing.T) {
+	newTestBackend(t, jsonBackend(`{"ok":true}`))
+	t.Setenv("API_KEYS", "ws-key")
+	r := gin.New()
+	r.Use(apiKeyAuth(nil))
+	cachedProxy("ws-auth", time.Minute, time.Minute, 0)
+	r.GET("/ws/auth", cacheStream("ws-auth"))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	if _, resp, err := dialStream(t, server, "/ws/auth"); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("dial without a key: error %v, want a 401 handshake", err)
+	}
+	conn, _, err := dialStream(t, server, "/ws/auth?"+wsAPIKeyParam+"=ws-key")
+	if err != nil {
+		t.Fatalf("dial with the api_key param: %v", err)
+	}
+	if frame := readFrame(t, conn); frame != `{"ok":true}` {
+		t.Errorf("initial frame %s", frame)
+	}
+}
+
+func TestCacheStreamRejectsNonWarmableEndpoints(t *testing.T) {
+	cachedProxy("ws-query", time.Minute, time.Minute, 0)
+	requireQuery("ws-query")
+	r := gin.New()
+	r.GET("/ws/query", cacheStream("ws-query"))
+	if w := get(r, "/ws/query"); w.Code != http.StatusNotFound {
+		t.Errorf("stream of an endpoint requiring a query: status %d, want 404", w.Code)
+	}
+}
+
+func TestPredictionStreamThrottlesAndDropsIntermediateUpdates(t *testing.T) {
+	var version atomic.Int64
+	version.Store(1)
+	backend := newTestBackend(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"version":%d}`, version.Load())
+	})
+	setForTest(t, &wsPollInterval, 5*time.Millisecond)
+	setForTest(t, &wsMinInterval, 0)
+	const minInterval = 300 * time.Millisecond
+	t.Setenv("WS_MIN_INTERVAL_WS_PREDICTIONS", minInterval.String())
+	r := cachedTestRouter("ws-predictions", time.Minute, time.Minute)
+	r.GET("/ws/predictions", waitForStreams(t, cacheStream("ws-predictions")))
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+
+	// Timed before dialing, as the initial frame may be sent before it
+	// is read
+	sent := time.Now()
+	conn, _, err := dialStream(t, server, "/ws/predictions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame := readFrame(t, conn); frame != `{"version":1}` {
+		t.Fatalf("initial frame %s, want version 1", frame)
+	}
+
+	// Every change within the throttle window is picked up, but only the
+	// latest is sent once it ends
+	for v := int64(2); v <= 4; v++ {
+		version.Store(v)
+		if _, err := cacheStore.Del(context.Background(), namespacedKey("cache:ws-predictions:")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool { return backend.hits.Load() >= v })
+	}
+	if frame := readFrame(t, conn); frame != `{"version":4}` {
+		t.Fatalf("throttled frame %s, want the latest version 4", frame)
+	}
+	if elapsed := time.Since(sent); elapsed < minInterval {
+		t.Errorf("second frame after %v, want at least %v", elapsed, minInterval)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(minInterval + 100*time.Millisecond))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Errorf("unexpected frame %s without a change", data)
+	}
+}
+
+func TestOfferLatestReplacesPendingFrame(t *testing.T) {
+	slot := make(chan []byte, 1)
+	if offerLatest(slot, []byte("1")) {
+		t.Error("offering to an empty slot reported a drop")
+	}
+	if !offerLatest(slot, []byte("2")) {
+		t.Error("replacing a pending frame did not report a drop")
+	}
+	if frame := <-slot; string(frame) != "2" {
+		t.Errorf("slot holds %s, want the latest frame", frame)
+	}
+}